@@ -2,83 +2,232 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var prodDB DBObject     //DBObject for production database
 var testDB DBObject     //DBObject for testing database
 var currentDB *DBObject //DBObject to represent the currently used database
 
-//Select which database to use depending on if tests are being run by taking in a bool variable
+//Select which database to use depending on if tests are being run by taking in a bool variable. The production
+//database also starts a janitor that purges trashed items older than TrashRetention.
 func Initialize(isTesting bool) {
 	if isTesting {
 		currentDB = &testDB
-		currentDB.Data = []ProduceItem{
-			{"A12T-4GH7-QPL9-3N4M", "Lettuce", "$3.46"},
-			{"E5T6-9UI3-TH15-QR88", "Peach", "$2.99"},
-			{"YRT6-72AS-K736-L4AR", "Green Pepper", "$0.79"},
-			{"2222-2222-2222-2222", "Gala Apple", "$3.59"},
-		}
+		currentDB.storeCatalog(&catalog{Data: []ProduceItem{
+			{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", UnitPrice: "$3.46"},
+			{ProduceCode: "E5T6-9UI3-TH15-QR88", Name: "Peach", UnitPrice: "$2.99"},
+			{ProduceCode: "YRT6-72AS-K736-L4AR", Name: "Green Pepper", UnitPrice: "$0.79"},
+			{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59"},
+		}})
 	} else {
 		currentDB = &prodDB
-		currentDB.Data = []ProduceItem{
-			{"A12T-4GH7-QPL9-3N4M", "Lettuce", "$3.46"},
-			{"E5T6-9UI3-TH15-QR88", "Peach", "$2.99"},
-			{"YRT6-72AS-K736-L4AR", "Green Pepper", "$0.79"},
-			{"TQ4C-VV6T-75ZX-1RMR", "Gala Apple", "$3.59"},
+		currentDB.storeCatalog(&catalog{Data: []ProduceItem{
+			{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", UnitPrice: "$3.46"},
+			{ProduceCode: "E5T6-9UI3-TH15-QR88", Name: "Peach", UnitPrice: "$2.99"},
+			{ProduceCode: "YRT6-72AS-K736-L4AR", Name: "Green Pepper", UnitPrice: "$0.79"},
+			{ProduceCode: "TQ4C-VV6T-75ZX-1RMR", Name: "Gala Apple", UnitPrice: "$3.59"},
+		}})
+		go runTrashJanitor(time.Hour)
+	}
+}
+
+//This function fetches all produce items from the database and returns them in JSON format with a 200 status code. Deleted items are left out unless the
+//query parameter include_deleted=true is given, in which case the trashed items follow with their deleted_at time.
+//The category query parameter limits the listing to items in that category or any category below it, an unknown
+//category triggers a status 404. The listing is sent as CSV, XML or MessagePack instead when the Accept header
+//prefers one of them, an Accept header that allows none of listingFormats triggers a status 406.
+func handleGetAllProduce(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := negotiate(r.Header.Get("Accept"), listingFormats)
+	if !ok {
+		http.Error(w, "error 406 - produce can only be listed as "+strings.Join(listingFormats, ", "), 406)
+		return
+	}
+
+	db := tenantDB(r)
+	var categories map[string]bool
+	if category := r.URL.Query().Get("category"); category != "" {
+		var ok bool
+		if categories, ok = db.categoryDescendants(category); !ok {
+			http.Error(w, "error 404 - category does not exist", 404)
+			return
 		}
 	}
+
+	allItems, err := db.getAllProduceItems(r.Context()) //get all items from DB
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+	if categories != nil {
+		allItems = filterByCategory(allItems, categories)
+	}
+
+	if r.URL.Query().Get("include_deleted") != "true" {
+		if format != "application/json" {
+			listingResponse(w, format, listedItems(allItems, nil))
+			return
+		}
+		jsonResponse(w, http.StatusOK, allItems)
+		return
+	}
+
+	trash, err := db.getTrashedItems(r.Context()) //get deleted items from DB
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+
+	trashed := []TrashedItem{}
+	for _, item := range trash {
+		if categories == nil || categories[item.Category] {
+			trashed = append(trashed, item)
+		}
+	}
+	if format != "application/json" {
+		listingResponse(w, format, listedItems(allItems, trashed))
+		return
+	}
+	listing := make([]interface{}, 0, len(allItems)+len(trashed))
+	for _, item := range allItems {
+		listing = append(listing, item)
+	}
+	for _, item := range trashed {
+		listing = append(listing, item)
+	}
+	jsonResponse(w, http.StatusOK, listing)
 }
 
-//This function sends a request to the database to fetch all produce items through a goroutine then returns them on a
-// channel then finally returns them in JSON format with a 200 status code.
-func handleGetAllProduce(w http.ResponseWriter, _ *http.Request) {
-	pItemSliceChnl := make(chan []ProduceItem)
-	go getAllProduceItems(pItemSliceChnl) //get all items from DB
-	allItems := <-pItemSliceChnl
-	jsonResponse(w, http.StatusOK, allItems)
+//This function fetches the items in the trash and returns them in JSON format, each with the time it was deleted,
+//along with a 200 status code.
+func handleGetTrash(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	trash, err := db.getTrashedItems(r.Context()) //get deleted items from DB
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, trash)
 }
 
-//This function first retrieves the produce code from the URL and determines if it is valid. If it is not valid it
-//triggers a status 400 error. If it is valid it fires a goroutine to fetch that particular item and waits for a
-//response via a channel. If the database returned an item it is displayed in JSON along with a 200 status code. If
-//it is not found a 404 status code is triggered.
-func handleGetProduceItem(w http.ResponseWriter, r *http.Request) {
+//This function checks the produce code passed in from the URL, if it is not valid a status 400 is triggered. The
+//item is then moved from the trash back into the database. If the code is not in the trash a status 404 is
+//triggered, if an item with the same code was created since the delete or the item refers to data removed while it
+//was in the trash, such as its category, a status 409 is triggered. Otherwise a status 200 is triggered and the
+//restored item is returned as a JSON.
+func handleRestoreProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	pItem, err := db.restoreProduceItem(r.Context(), params["produce_code"]) //move item out of the trash
+
+	switch err {
+	case nil:
+	//if code not in the trash
+	case errProduceNotFound:
+		http.Error(w, "error 404 - produce code not found in trash", 404)
+		return
+	//code is in use again
+	case errProduceCodeExists:
+		http.Error(w, "error 409 - produce code already exists", 409)
+		return
+	default:
+		//item refers to data removed while it was in the trash
+		if _, ok := err.(referenceError); ok {
+			http.Error(w, "error 409 - "+err.Error(), 409)
+			return
+		}
+		modelErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, pItem)
+}
+
+//This function checks the produce code passed in from the URL, if it is not valid a status 400 is triggered. It
+//then permanently removes the item from the trash. If the code is not in the trash a status 404 is triggered,
+//otherwise a status 200 is triggered and the purged item is returned as a JSON.
+func handlePurgeTrashedItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
 	params := mux.Vars(r)
 
 	//check if produce code format is valid
-	if !isValidProduceCode(params["produce_code"]) {
+	if !db.isValidProduceCode(params["produce_code"]) {
 		http.Error(w, "error 400 - invalid produce code format", 400)
 		return
 	}
 
-	pItemChnl := make(chan ProduceItem)
+	pItem, err := db.purgeTrashedItem(r.Context(), params["produce_code"]) //remove item from the trash for good
 
-	go getProduceItem(params["produce_code"], pItemChnl) // get item of corresponding code from DB
+	//if code not in the trash
+	if err == errProduceNotFound {
+		http.Error(w, "error 404 - produce code not found in trash", 404)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
 
-	pItem := <-pItemChnl // wait for channel to return data and store it in pItem
+	jsonResponse(w, http.StatusOK, pItem)
+}
+
+//This function first retrieves the produce code from the URL and determines if it is valid. If it is not valid it
+//triggers a status 400 error. If it is valid it fetches that particular item from the database. If the database returned an item it is displayed in JSON along with a 200 status code and,
+//when a promotion is active, its effective price. If it is not found a 404 status code is triggered.
+func handleGetProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	pItem, err := db.getProduceItem(r.Context(), params["produce_code"]) // get item of corresponding code from DB
 
 	//if produce code not found
-	if pItem.ProduceCode == "" {
+	if err == errProduceNotFound {
 		http.Error(w, "error 404 - produce code does not exist", 404)
 		return
-
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
 	}
 	//else produce code is found
-	jsonResponse(w, http.StatusOK, pItem)
+	jsonResponse(w, http.StatusOK, db.withEffectivePrice(pItem))
 	return
 }
 
 //This function first parses the JSON body request (if body does not contain valid JSON status code 400 is triggered) into a `ProduceItem` type then checks to see that all fields are
-//valid and filled in by calling the `ProduceItem` method `validateProduceItem()`. If validation fails a status code
-//400 is triggered along with a JSON response of the errors. If the `ProduceItem` is valid a goroutine is triggered
-//to create an item with the data passed back through a channel. If the produce code already exists in the data a
-//status code 409 is triggered if not a 201 status code is triggered with the JSON of the `ProduceItem` returned.
+//valid and filled in by calling the `ProduceItem` method `validateProduceItem(db)`. If validation fails a status code
+//400 is triggered along with a JSON response of the errors. If the `ProduceItem` is valid the item is created in the
+//database. If the produce code already exists in the data a
+//status code 409 is triggered if not a 201 status code is triggered with the JSON of the `ProduceItem` returned. When
+//the produce code is left out and the configured scheme can generate codes an unused code is issued, if no unused
+//code can be found a status 409 is triggered.
 func handleCreateProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
 	var pItem ProduceItem
 
 	err := json.NewDecoder(r.Body).Decode(&pItem) //get request body and decode into JSON format
@@ -89,23 +238,37 @@ func handleCreateProduceItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validErrs := pItem.validateProduceItem() //check that the JSON is a valid produce item
+	//a code is generated when the item is stored if the client leaves it out and the scheme can generate one
+	_, canGenerate := db.codeScheme().(CodeGenerator)
+	generateCode := pItem.ProduceCode == "" && canGenerate
+
+	validErrs := pItem.validateProduceItem(db) //check that the JSON is a valid produce item
+	if generateCode {
+		validErrs.Del("produce_code")
+	}
+	db.validateItemReferences(pItem.ProduceCode, &pItem, validErrs)
 
 	//if len(validErrs) > 0 then errors occurred, display them to let the user know what they are
 	if len(validErrs) > 0 {
-		err := map[string]interface{}{"validationError": validErrs}
+		err := ValidationError{validErrs}
 		jsonResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
-	pItemChnl := make(chan ProduceItem)
-	go createProduceItem(pItem, pItemChnl) //attempt to add item to the database
-	pItem = <-pItemChnl                    //wait for channel to return data and store it in pItem
+	pItem, err = db.createProduceItem(r.Context(), pItem) //attempt to add item to the database
 
-	if pItem.ProduceCode == "" {
+	if err == errProduceCodeExists {
 		http.Error(w, "error 409 - produce code already exists", 409)
 		return
 	}
+	if err == errCodesNotGenerated {
+		http.Error(w, "error 409 - no unused produce code could be generated", 409)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
 
 	jsonResponse(w, http.StatusCreated, pItem)
 
@@ -113,17 +276,18 @@ func handleCreateProduceItem(w http.ResponseWriter, r *http.Request) {
 
 //This function checks if the produce code passed in from the URL is valid and the body contains valid JSON, if it does not a status code 400 is triggered.
 //If it is the JSON from the request body is placed into a `ProduceItem`. This JSON is then validated by calling the
-//`ProduceItem` method `validationProduceItem()`. Upon validation success a go routine is called and passes the updated
-//item back through a channel. If the produce code was not found a status code 404 is triggered or if the changed
-//produce code already exists a status 409 is triggered. Otherwise a status 200 is triggered and the updated item
-//contents are returned as a JSON.
+//`ProduceItem` method `validationProduceItem()`. Upon validation success the item is updated in the database. If the
+//produce code was not found a status code 404 is triggered or if the changed
+//produce code already exists a status 409 is triggered. Otherwise a status 200 is triggered and the item as stored,
+//with the stock it keeps, is returned as a JSON.
 func handleUpdateProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
 	var pItem ProduceItem
 	params := mux.Vars(r)
 
 	//check if produce code format is valid
 	params["produce_code"] = strings.ToUpper(params["produce_code"])
-	if !isValidProduceCode(params["produce_code"]) {
+	if !db.isValidProduceCode(params["produce_code"]) {
 		http.Error(w, "error 400 - invalid produce code format", 400)
 		return
 	}
@@ -137,67 +301,284 @@ func handleUpdateProduceItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//if len(validErrs) > 0 then errors occurred, display them to let the user know what they are
-	validErrs := pItem.validateProduceItem()
+	validErrs := pItem.validateProduceItem(db)
+	db.validateItemReferences(params["produce_code"], &pItem, validErrs)
 	if len(validErrs) > 0 {
-		err := map[string]interface{}{"validationError": validErrs}
+		err := ValidationError{validErrs}
 		jsonResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
-	pItemChnl := make(chan ProduceItem)
-	go updateProduceItem(params["produce_code"], pItem, pItemChnl) //update item of given produce code in DB
-	pItem = <-pItemChnl                                            //wait for channel to return data and store in pItem
+	pItem, err = db.updateProduceItem(r.Context(), params["produce_code"], pItem) //update item of given produce code in DB
+	if !updateSucceeded(w, err) {
+		return
+	}
+
+	//item updated successfully, display its stored contents
+	jsonResponse(w, http.StatusOK, pItem)
+
+}
+
+//This function replaces the item at the URL with the JSON body in full. Unlike the create end point the produce code
+//is taken from the URL, a code in the body must match it or be left out, and the request is idempotent: if the item
+//exists it is overwritten and a status 200 is triggered, if it does not exist it is created under that code and a
+//status 201 is triggered. Invalid codes, JSON syntax or field values trigger a status 400. The item is returned as
+//stored, stock sent in the body is ignored.
+func handleReplaceProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var pItem ProduceItem
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	params["produce_code"] = strings.ToUpper(params["produce_code"])
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&pItem) //get request body and decode into JSON format
 
-	//produce code not found
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	//the URL identifies the item, the body may not move it to another code
 	if pItem.ProduceCode == "" {
-		http.Error(w, "error 404 - produce code does not exist", 404)
+		pItem.ProduceCode = params["produce_code"]
+	}
+	if strings.ToUpper(pItem.ProduceCode) != params["produce_code"] {
+		http.Error(w, "error 400 - produce code in body does not match URL", http.StatusBadRequest)
 		return
 	}
-	//new produce code value already exists
-	if pItem.ProduceCode == "0" {
-		http.Error(w, "error 409 - updated produce code value already exists", 409)
+
+	validErrs := pItem.validateProduceItem(db)
+	db.validateItemReferences(params["produce_code"], &pItem, validErrs)
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
 		return
 	}
 
-	//item updated successfully, display its new contents
+	pItem, created, err := db.putProduceItem(r.Context(), pItem) //replace or create item in DB
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+
+	if created {
+		jsonResponse(w, http.StatusCreated, pItem)
+		return
+	}
 	jsonResponse(w, http.StatusOK, pItem)
+}
 
+//This function applies a partial update to the item at the URL. The body is read as a JSON Merge Patch when the
+//Content-Type is application/merge-patch+json or application/json and as a JSON Patch when it is
+//application/json-patch+json, any other type triggers a status 415. The patch is applied to the stored item under the
+//write lock of the database and only the merged result is validated, so a client may send just the fields it wants to
+//change. A malformed patch or an invalid result, a value of the wrong type included, triggers a status 400, a patch
+//that can not be applied (missing path, failed test) a status 409, and the usual 404/409 rules of the update end point apply when the item is stored. The
+//stored item is returned.
+func handlePatchProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	params["produce_code"] = strings.ToUpper(params["produce_code"])
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error 400 - unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	//the item is read, patched and stored under the write lock so no other write is lost
+	pItem, err := db.patchStoredItem(r.Context(), params["produce_code"], r.Header.Get("Content-Type"), body)
+	switch patchErr := err.(type) {
+	case patchSyntaxError:
+		http.Error(w, "error 400 - "+patchErr.Error(), http.StatusBadRequest)
+		return
+	case patchValidationError:
+		jsonResponse(w, http.StatusBadRequest, ValidationError{patchErr.errs})
+		return
+	case patchConflictError:
+		http.Error(w, "error 409 - "+patchErr.Error(), http.StatusConflict)
+		return
+	}
+	if err == errUnsupportedPatchType {
+		http.Error(w, "error 415 - "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	if !updateSucceeded(w, err) {
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, pItem)
 }
 
 //This function first checks if the produce code passed in from the URL is valid, if it is not a status code 400 is
-//triggered. If the produce code is valid a goroutine is triggered and passes the produce item back through a channel.
-//If the code was not found a status 404 is triggered, if it was found a status 200 is triggered and the deleted produce
-//item is returned as a JSON.
+//triggered. If the produce code is valid the item is deleted from the database. If the code was not found a status 404 is triggered, if it was found it is moved to the trash, a status 200 is
+//triggered and the deleted produce item is returned as a JSON.
 func handleDeleteProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
 	params := mux.Vars(r)
 
 	//check if produce code format is valid
-	if !isValidProduceCode(params["produce_code"]) {
+	if !db.isValidProduceCode(params["produce_code"]) {
 		http.Error(w, "error 400 - invalid produce code format", 400)
 		return
 	}
 
-	var pItem ProduceItem
-	pItemChnl := make(chan ProduceItem)
-	go deleteProduceItem(params["produce_code"], pItemChnl) //delete item from DB
-	pItem = <-pItemChnl                                     //wait for item to return on channel
+	pItem, err := db.deleteProduceItem(r.Context(), params["produce_code"]) //delete item from DB
 
 	//if code not found
-	if pItem.ProduceCode == "" {
+	if err == errProduceNotFound {
 		http.Error(w, "error 404 - produce code not found.", 404)
 		return
 	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
 
 	//code found
 	jsonResponse(w, http.StatusOK, pItem)
 }
 
-//This function accepts a produce string and validates via the regex expression "^[\d\w]{4}-[\d\w]{4}-[\d\w]{4}-[\d\w]{4}$"
-//to determine if it is valid or not and returns true if valid or false if not. This expression checks that code is
-//four groups of four alphanumeric characters.
+//This function streams catalog changes as Server-Sent Events. Each event carries its sequence number as the SSE id,
+//its type (create, update or delete) as the event name and the ChangeEvent as JSON data. A reconnecting client resumes
+//from the Last-Event-ID header or the since query parameter and first receives every retained event after it. If those
+//events are no longer retained a "resync" event is sent first so the client knows to reload the catalog. An invalid
+//sequence number triggers a status 400.
+func handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	since, ok := eventCursor(r)
+	if !ok {
+		http.Error(w, "error 400 - invalid event sequence number", http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "error 500 - streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backlog, events, complete := db.feed.subscribe(since)
+	defer db.feed.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	if !complete {
+		fmt.Fprintf(w, "event: resync\ndata: {\"seq\":%d}\n\n", db.feed.lastSeq())
+	}
+	for _, event := range backlog {
+		writeServerSentEvent(w, event)
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case event, open := <-events:
+			//dropped for falling behind, the client reconnects with its last id
+			if !open {
+				return
+			}
+			writeServerSentEvent(w, event)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+//This function streams catalog changes over a WebSocket. After the upgrade every retained event after the since query
+//parameter is sent, followed by new events as they happen, each as a text frame holding the ChangeEvent JSON. If
+//the requested events are no longer retained a {"type":"resync"} frame is sent first. The stream ends when the
+//client closes the connection or falls too far behind.
+func handleWebsocketEvents(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	since, ok := eventCursor(r)
+	if !ok {
+		http.Error(w, "error 400 - invalid event sequence number", http.StatusBadRequest)
+		return
+	}
+
+	ws := upgradeWebsocket(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.close()
+
+	backlog, events, complete := db.feed.subscribe(since)
+	defer db.feed.unsubscribe(events)
+	closed := ws.readLoop()
+
+	if !complete {
+		resync, _ := json.Marshal(map[string]interface{}{"type": "resync", "seq": db.feed.lastSeq()})
+		if ws.writeFrame(opText, resync) != nil {
+			return
+		}
+	}
+	for _, event := range backlog {
+		payload, _ := json.Marshal(event)
+		if ws.writeFrame(opText, payload) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				ws.writeFrame(opClose, nil)
+				return
+			}
+			payload, _ := json.Marshal(event)
+			if ws.writeFrame(opText, payload) != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+//returns the sequence number a streaming client wants to resume after, taken from the Last-Event-ID header or the
+//since query parameter. A client that gives neither only receives new events. ok is false if the value is not a number.
+func eventCursor(r *http.Request) (uint64, bool) {
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("since")
+	}
+	if cursor == "" {
+		return tenantDB(r).feed.lastSeq(), true
+	}
+	since, err := strconv.ParseUint(cursor, 10, 64)
+	return since, err == nil
+}
+
+//writes a single event in Server-Sent Events format
+func writeServerSentEvent(w io.Writer, event ChangeEvent) {
+	data, _ := json.Marshal(event)
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+}
+
+//This function accepts a produce string and validates it against the configured ProduceCodes scheme, returning true
+//if valid or false if not. The default scheme checks that the code is four groups of four alphanumeric characters.
 func isValidProduceCode(produceCode string) bool {
-	match, _ := regexp.MatchString(`^[\d\w]{4}-[\d\w]{4}-[\d\w]{4}-[\d\w]{4}$`, produceCode)
-	return match
+	return ProduceCodes.Valid(produceCode)
 }
 
 //This function accepts a unit price string and validates via the regex expression
@@ -209,12 +590,49 @@ func isValidUnitPrice(unitPrice string) bool {
 	return match
 }
 
-//This function accepts a name string and validates via the regex expression `\w+(?: \w+)*$` which will allow no
-//whitespace before or trailing whitespace after a single space set of alphanumeric characters. If valid returns
-//true if not valid returns false.
+//This function accepts a name string and validates it against namePattern which will allow no whitespace before or
+//trailing whitespace after words of Unicode letters and digits separated by a single space or a hyphen. Words may
+//contain apostrophes as in "Granny Smith's". If valid returns true if not valid returns false.
 func isValidName(name string) bool {
-	match, _ := regexp.MatchString(`^\w+(?: \w+)*$`, name)
-	return match
+	return namePattern.MatchString(name)
+}
+
+//type to store the body of a response to a request that failed validation, the error messages are keyed by field
+type ValidationError struct {
+	Errors url.Values `json:"validationError"`
+}
+
+//writes the error response of an update of the stored item and returns false, true is returned without writing when
+//the update succeeded. An unknown produce code triggers a status 404 and a new produce code that is already in use a
+//status 409.
+func updateSucceeded(w http.ResponseWriter, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case errProduceNotFound:
+		http.Error(w, "error 404 - produce code does not exist", 404)
+	case errProduceCodeExists:
+		http.Error(w, "error 409 - updated produce code value already exists", 409)
+	default:
+		modelErrorResponse(w, err)
+	}
+	return false
+}
+
+//writes the response for an error of a model method the handler has no response of its own for. A request that was
+//canceled or ran past its deadline triggers a status 503, an item that refers to data removed while the request was
+//handled a status 400 with the errors by field, any other error a status 500.
+func modelErrorResponse(w http.ResponseWriter, err error) {
+	if refErr, ok := err.(referenceError); ok {
+		jsonResponse(w, http.StatusBadRequest, ValidationError{refErr.errs})
+		return
+	}
+	switch err {
+	case context.Canceled, context.DeadlineExceeded:
+		http.Error(w, "error 503 - "+err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "error 500 - "+err.Error(), http.StatusInternalServerError)
+	}
 }
 
 //This function accepts a ResponseWriter, status code, and payload and then JSON encodes it via json.Marshall()
@@ -227,7 +645,14 @@ func jsonResponse(w http.ResponseWriter, statusCode int, payload interface{}) {
 		panic(err)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	writeResponse(w, statusCode, "application/json", response)
+}
+
+//writes the body with its Content-Type and Content-Length headers and the given status code. The length lets
+//compressResponses decide whether the body is worth compressing.
+func writeResponse(w http.ResponseWriter, statusCode int, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
 	w.WriteHeader(statusCode)
-	w.Write(response)
+	w.Write(body)
 }
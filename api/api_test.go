@@ -2,41 +2,20 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"unicode/utf8"
 )
 
-var (
-	server     *httptest.Server
-	reader     io.Reader
-	produceUrl string
-)
-
-//set produceURL for testing and tell api.go we are testing
-func init() {
-	server = httptest.NewServer(Handlers())
-	produceUrl = fmt.Sprintf("%s/api/produce", server.URL)
-	Initialize(true)
-}
-
-//set DB to default state
-func reinitTest() {
-	currentDB.Data = []ProduceItem{
-		{"A12T-4GH7-QPL9-3N4M", "Lettuce", "$3.46"},
-		{"E5T6-9UI3-TH15-QR88", "Peach", "$2.99"},
-		{"YRT6-72AS-K736-L4AR", "Green Pepper", "$0.79"},
-		{"2222-2222-2222-2222", "Gala Apple", "$3.59"},
-	}
-}
-
 //test isValidProduceCode regex
 func TestIsValidProduceCode(t *testing.T) {
+	t.Parallel()
 	var testCodes = []struct {
 		value string
 		valid bool
@@ -77,8 +56,45 @@ func TestIsValidProduceCode(t *testing.T) {
 	}
 }
 
+//checks a produce code without a regex, four groups of four ASCII letters, digits or underscores separated by hyphens
+func isFourByFourCode(code string) bool {
+	groups := strings.Split(code, "-")
+	if len(groups) != 4 {
+		return false
+	}
+	for _, group := range groups {
+		if len(group) != 4 {
+			return false
+		}
+		for _, char := range group {
+			if !('0' <= char && char <= '9' || 'a' <= char && char <= 'z' || 'A' <= char && char <= 'Z' || char == '_') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+//fuzz isValidProduceCode against a check written without a regex, valid codes must stay valid once upper cased as
+//that is how they are stored
+func FuzzIsValidProduceCode(f *testing.F) {
+	for _, seed := range []string{"abcd-1234-z9y8-q123", "AAAA-BBBB-CCCC-DDDD", "abcd-1234-z9y8-q13", "abcd-1234-z9y8-q123-", "____-____-____-____", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, code string) {
+		valid := isValidProduceCode(code)
+		if valid != isFourByFourCode(code) {
+			t.Fatalf("isValidProduceCode(%q) = %v", code, valid)
+		}
+		if valid && !isValidProduceCode(strings.ToUpper(code)) {
+			t.Fatalf("upper cased %q is invalid", code)
+		}
+	})
+}
+
 //test isValidName Regex
 func TestIsValidName(t *testing.T) {
+	t.Parallel()
 	var testNames = []struct {
 		value string
 		valid bool
@@ -93,14 +109,48 @@ func TestIsValidName(t *testing.T) {
 		{" ", false},
 		{" a", false},
 		{"m!lk", false},
+		{"Jalapeño", true},
+		{"Bok-Choy", true},
+		{"Granny Smith's", true},
+		{"Farmers' Market Kale", true},
+		{"Crème Fraîche", true},
+		{"Bok--Choy", false},
+		{"Bok - Choy", false},
+		{"'s", false},
+		{"Kale ", false},
+		{"snake_case", false},
 	}
 	for _, item := range testNames {
 		assert.Equal(t, item.valid, isValidName(item.value), fmt.Sprintf("On Unit Price: `%s`", item.value))
 	}
 }
 
+//fuzz isValidName and normalizeName, a valid name is valid UTF-8 that normalizing can not change the spacing of and
+//normalizing a normalized name changes nothing
+func FuzzIsValidName(f *testing.F) {
+	for _, seed := range []string{"Cow Milk 12", "Granny Smith's", "Crème Fraîche", "Bok--Choy", " a", "Kale\u00a0 ", "e\u0301clair"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, name string) {
+		normalized := normalizeName(name)
+		if normalizeName(normalized) != normalized {
+			t.Fatalf("normalizeName(%q) = %q is not normalized", name, normalized)
+		}
+		if !isValidName(name) {
+			return
+		}
+		if !utf8.ValidString(name) {
+			t.Fatalf("invalid UTF-8 %q accepted", name)
+		}
+		if strings.Join(strings.Fields(name), " ") != name {
+			t.Fatalf("name %q with stray white space accepted", name)
+		}
+	})
+}
+
 //test isValidUnitPrice regex
 func TestIsValidUnitPrice(t *testing.T) {
+	t.Parallel()
 	var testPrices = []struct {
 		value string
 		valid bool
@@ -135,9 +185,37 @@ func TestIsValidUnitPrice(t *testing.T) {
 
 }
 
+//fuzz isValidUnitPrice, a valid price parses and formats back to the same amount in a price that is valid too
+func FuzzIsValidUnitPrice(f *testing.F) {
+	for _, seed := range []string{"$0.1", "$4,000,001.23", "$4231", "$01", "$21,12345", "$5.123", "$", "$100000000000000000"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, price string) {
+		if !isValidUnitPrice(price) {
+			return
+		}
+		amount, err := parsePrice(price)
+		if err != nil {
+			t.Fatalf("valid price %q does not parse: %v", price, err)
+		}
+		cents, err := roundToCents(amount)
+		if err == errAmountTooLarge {
+			return
+		}
+		formatted := formatCents(cents)
+		if !isValidUnitPrice(formatted) {
+			t.Fatalf("price %q formats as invalid %q", price, formatted)
+		}
+		if again, _ := parsePrice(formatted); again == nil || again.Cmp(amount) != 0 {
+			t.Fatalf("price %q changed to %q when formatted", price, formatted)
+		}
+	})
+}
+
 
 
 func TestHandleGetAllProduce(t *testing.T) {
+	t.Parallel()
 	var getAllTests = []struct {
 		desc         string
 		method       string
@@ -145,28 +223,19 @@ func TestHandleGetAllProduce(t *testing.T) {
 		statusCode   int
 		expectedBody string
 	}{
-		{"all items payload from reinitTest()", "GET", produceUrl,
+		{"all items payload from seedItems()", "GET", "/api/produce",
 			200, `[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"},{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"},{"produce_code":"YRT6-72AS-K736-L4AR","name":"Green Pepper","unit_price":"$0.79"},{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","unit_price":"$3.59"}]`},
 	}
 
 	for _, item := range getAllTests {
-		reinitTest()
-		request, err := http.NewRequest(item.method, item.path, nil)
-		response, err := http.DefaultClient.Do(request)
-
-		if err != nil {
-			t.Error(err)
-		}
-
-		responseData, _ := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		assert.Equal(t, item.expectedBody, string(responseData), fmt.Sprintf("unexpected response for %s", item.desc))
-		assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", item.desc))
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, "").assert(t, item.statusCode, item.expectedBody, item.desc)
 	}
 
 }
 
 func TestHandleGetProduceItem(t *testing.T) {
+	t.Parallel()
 	var getItemTests = []struct {
 		desc         string
 		method       string
@@ -174,34 +243,25 @@ func TestHandleGetProduceItem(t *testing.T) {
 		statusCode   int
 		expectedBody string
 	}{
-		{"get existing item", "GET", fmt.Sprintf("%s/A12T-4GH7-QPL9-3N4M", produceUrl),
+		{"get existing item", "GET", "/api/produce/A12T-4GH7-QPL9-3N4M",
 			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
 		//
-		{"invalid produce code", "GET", fmt.Sprintf("%s/ABCDe-1234-EFGH-5678", produceUrl),
+		{"invalid produce code", "GET", "/api/produce/ABCDe-1234-EFGH-5678",
 			400, "error 400 - invalid produce code format\n"},
 		//
-		{"produce code does note exist", "GET", fmt.Sprintf("%s/ABCD-1234-EFGH-0000", produceUrl),
+		{"produce code does note exist", "GET", "/api/produce/ABCD-1234-EFGH-0000",
 			404, "error 404 - produce code does not exist\n"},
 	}
 
 	for _, item := range getItemTests {
-		reinitTest()
-		request, err := http.NewRequest(item.method, item.path, nil)
-		response, err := http.DefaultClient.Do(request)
-
-		if err != nil {
-			t.Error(err)
-		}
-
-		responseData, _ := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		assert.Equal(t, item.expectedBody, string(responseData), fmt.Sprintf("unexpected response for %s", item.desc))
-		assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", item.desc))
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, "").assert(t, item.statusCode, item.expectedBody, item.desc)
 	}
 
 }
 
 func TestHandleUpdateProduceItem(t *testing.T) {
+	t.Parallel()
 	var updateItemTests = []struct {
 		desc         string
 		method       string
@@ -210,47 +270,37 @@ func TestHandleUpdateProduceItem(t *testing.T) {
 		pItemJSON    string
 		expectedBody string
 	}{
-		{"produce code remains same", "POST", fmt.Sprintf("%s/A12T-4GH7-QPL9-3N4M", produceUrl),
+		{"produce code remains same", "POST", "/api/produce/A12T-4GH7-QPL9-3N4M",
 			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
 		//
-		{"bad JSON syntax", "POST", fmt.Sprintf("%s/A12T-4GH7-QPL9-3N4M", produceUrl),
+		{"bad JSON syntax", "POST", "/api/produce/A12T-4GH7-QPL9-3N4M",
 			400, `{"produce_code":"A12T-4GH7-QPL9-1111","name":"Cheese","unit_price":"$5.00"`, "error 400 - invalid JSON syntax\n"},
 		//
-		{"push to db check", "POST", fmt.Sprintf("%s/A12T-4GH7-QPL9-3N4M", produceUrl),
+		{"push to db check", "POST", "/api/produce/A12T-4GH7-QPL9-3N4M",
 			200, `{"produce_code":"A12T-4GH7-QPL9-1111","name":"Cheese","unit_price":"$5.00"}`, `{"produce_code":"A12T-4GH7-QPL9-1111","name":"Cheese","unit_price":"$5.00"}`},
 		//
-		{"updated code already exists", "POST", fmt.Sprintf("%s/E5T6-9UI3-TH15-QR88", produceUrl),
+		{"updated code already exists", "POST", "/api/produce/E5T6-9UI3-TH15-QR88",
 			409, `{"produce_code":"2222-2222-2222-2222","name":"Cheese","unit_price":"$5.00"}`, "error 409 - updated produce code value already exists\n"},
 		//
-		{"produce code doesn't exist to update", "POST", fmt.Sprintf("%s/E5T6-9UI3-TH15-1111", produceUrl),
+		{"produce code doesn't exist to update", "POST", "/api/produce/E5T6-9UI3-TH15-1111",
 			404, `{"produce_code":"A12T-4GH7-QPL9-3N4A","name":"Cheese","unit_price":"$5.00"}`, "error 404 - produce code does not exist\n"},
 		//
-		{"invalid end point", "POST", fmt.Sprintf("%s/E5T6-9UI3-TH15-111", produceUrl),
+		{"invalid end point", "POST", "/api/produce/E5T6-9UI3-TH15-111",
 			400, `{"produce_code":"","name":"","unit_price":""}`, "error 400 - invalid produce code format\n"},
 		//
-		{"bad payload", "POST", fmt.Sprintf("%s/E5T6-9UI3-TH15-QR88", produceUrl),
+		{"bad payload", "POST", "/api/produce/E5T6-9UI3-TH15-QR88",
 			400, `{"produce_code":"A12T-4GH7-QPL9-3NM","name":"","unit_price":"5.00"}`,
 			`{"validationError":{"name":["name field is required","invalid name format"],"produce_code":["invalid produce code format"],"unit_price":["invalid unit price format"]}}`},
 	}
 
 	for _, item := range updateItemTests {
-		reinitTest()
-		reader = strings.NewReader(item.pItemJSON)
-		request, err := http.NewRequest(item.method, item.path, reader)
-		response, err := http.DefaultClient.Do(request)
-
-		if err != nil {
-			t.Error(err)
-		}
-
-		responseData, _ := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		assert.Equal(t, item.expectedBody, string(responseData), fmt.Sprintf("unexpected response for %s", item.desc))
-		assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", item.desc))
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, item.pItemJSON).assert(t, item.statusCode, item.expectedBody, item.desc)
 	}
 }
 
 func TestHandleCreateProduceItem(t *testing.T) {
+	t.Parallel()
 	var createItemTests = []struct {
 		desc         string
 		method       string
@@ -259,44 +309,78 @@ func TestHandleCreateProduceItem(t *testing.T) {
 		pItemJSON    string
 		expectedBody string
 	}{
-		{"create item", "POST", produceUrl, 201, `{"produce_code":"1234-5678-90ab-cdef","name":"Cheese","unit_price":"$9.99"}`,
+		{"create item", "POST", "/api/produce", 201, `{"produce_code":"1234-5678-90ab-cdef","name":"Cheese","unit_price":"$9.99"}`,
 			`{"produce_code":"1234-5678-90AB-CDEF","name":"Cheese","unit_price":"$9.99"}`},
 		//
-		{"bad JSON syntax", "POST", produceUrl, 400, `{"produce_code":"1234-5678-90ab-cdef","name":"Cheese","unit_price","$9.99"`,
+		{"bad JSON syntax", "POST", "/api/produce", 400, `{"produce_code":"1234-5678-90ab-cdef","name":"Cheese","unit_price","$9.99"`,
 			"error 400 - invalid JSON syntax\n"},
 
-		{"try to create duplicate code", "POST", produceUrl, 409, `{"produce_code":"2222-2222-2222-2222","name":"Cheese","unit_price":"$9.99"}`,
+		{"try to create duplicate code", "POST", "/api/produce", 409, `{"produce_code":"2222-2222-2222-2222","name":"Cheese","unit_price":"$9.99"}`,
 			"error 409 - produce code already exists\n"},
 		//
-		{"left produce code field empty", "POST", produceUrl, 400, `{"produce_code":"","name":"Cheese","unit_price":"$4.60"}`,
-			`{"validationError":{"produce_code":["produce field is required","invalid produce code format"]}}`},
-		//
-		{"left name and unit field empty", "POST", produceUrl, 400, `{"produce_code":"1111-1111-1111-1111","name":"","unit_price":""}`,
+		{"left name and unit field empty", "POST", "/api/produce", 400, `{"produce_code":"1111-1111-1111-1111","name":"","unit_price":""}`,
 			`{"validationError":{"name":["name field is required","invalid name format"],"unit_price":["unit price field is required","invalid unit price format"]}}`},
 		//
-		{"all fields invalid", "POST", produceUrl, 400, `{"produce_code":"23aja-fafe-grge-sdf","name":"Ch!eese","unit_price":"23.432"}`,
+		{"all fields invalid", "POST", "/api/produce", 400, `{"produce_code":"23aja-fafe-grge-sdf","name":"Ch!eese","unit_price":"23.432"}`,
 			`{"validationError":{"name":["invalid name format"],"produce_code":["invalid produce code format"],"unit_price":["invalid unit price format"]}}`},
 	}
 
 	for _, item := range createItemTests {
-		reinitTest()
-		//produceItemJson := `{"produce_code":"` + item.produceCode + `","name":"` + item.name + `","unit_price":"` + item.unitPrice + `"}`
-		reader = strings.NewReader(item.pItemJSON)
-		request, err := http.NewRequest(item.method, item.path, reader)
-		response, err := http.DefaultClient.Do(request)
-
-		if err != nil {
-			t.Error(err)
-		}
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, item.pItemJSON).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
 
-		responseData, _ := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		assert.Equal(t, item.expectedBody, string(responseData), fmt.Sprintf("unexpected response for %s", item.desc))
-		assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", item.desc))
+//fuzz the decoding and validation of new items, whatever the body the handler answers 201, 400 or 409 and only a 201
+//stores an item, a valid one
+func FuzzHandleCreateProduceItem(f *testing.F) {
+	for _, seed := range []string{
+		`{"produce_code":"1234-5678-90ab-cdef","name":"Cheese","unit_price":"$9.99"}`,
+		`{"name":"Cheese","unit_price":"$9.99","stock":{"on_hand":5}}`,
+		`{"produce_code":"2222-2222-2222-2222","name":"Cheese","unit_price":"$9.99"}`,
+		`{"produce_code":"1111-1111-1111-1111","name":"Lettuce ","unit_price":"$3.46","barcodes":["4061"]}`,
+		`{"produce_code":"23aja-fafe-grge-sdf","name":"Ch!eese","unit_price":"23.432"}`,
+		`{"produce_code":1234,"name":null}`,
+		`[]`,
+		`{"name":`,
+	} {
+		f.Add(seed)
 	}
+	f.Fuzz(func(t *testing.T, body string) {
+		db := newTestDB(nil)
+		response := httptest.NewRecorder()
+		handlersFor(db).ServeHTTP(response, httptest.NewRequest("POST", "/api/produce", strings.NewReader(body)))
+
+		data := db.catalog().Data
+		stored := len(data) - len(seedItems())
+		switch response.Code {
+		case 201:
+			var pItem ProduceItem
+			if err := json.Unmarshal(response.Body.Bytes(), &pItem); err != nil {
+				t.Fatalf("invalid JSON response %q: %v", response.Body, err)
+			}
+			if stored != 1 || !reflect.DeepEqual(data[len(data)-1], pItem) {
+				t.Fatalf("created item %+v not stored as returned", pItem)
+			}
+			if errs := pItem.validateProduceItem(db); len(errs) > 0 {
+				t.Fatalf("invalid item %+v created from %q: %v", pItem, body, errs)
+			}
+		case 400, 409:
+			if stored != 0 {
+				t.Fatalf("item stored for status %d", response.Code)
+			}
+			var validationErr ValidationError
+			if json.Unmarshal(response.Body.Bytes(), &validationErr) == nil && len(validationErr.Errors) == 0 {
+				t.Fatalf("validation error without errors %q", response.Body)
+			}
+		default:
+			t.Fatalf("unexpected status %d for %q", response.Code, body)
+		}
+	})
 }
 
 func TestHandleDeleteProduceItem(t *testing.T) {
+	t.Parallel()
 	var deleteItemTests = []struct {
 		desc         string
 		method       string
@@ -304,31 +388,203 @@ func TestHandleDeleteProduceItem(t *testing.T) {
 		statusCode   int
 		expectedBody string
 	}{
-		{"delete item", "DELETE", fmt.Sprintf("%s/A12T-4GH7-QPL9-3N4M", produceUrl),
+		{"delete item", "DELETE", "/api/produce/A12T-4GH7-QPL9-3N4M",
 			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
 		//
-		{"invalid produce code", "DELETE", fmt.Sprintf("%s/ABCDe-1234-EFGH-5678", produceUrl),
+		{"invalid produce code", "DELETE", "/api/produce/ABCDe-1234-EFGH-5678",
 			400, "error 400 - invalid produce code format\n"},
 		//
-		{"code does not exist", "DELETE", fmt.Sprintf("%s/A12T-4GH7-QPL9-ABCD", produceUrl),
+		{"code does not exist", "DELETE", "/api/produce/A12T-4GH7-QPL9-ABCD",
 			404, "error 404 - produce code not found.\n"},
 	}
 
 	for _, item := range deleteItemTests {
-		reinitTest()
-		request, err := http.NewRequest(item.method, item.path, nil)
-		response, err := http.DefaultClient.Do(request)
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, "").assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+
+}
 
-		if err != nil {
-			t.Error(err)
-		}
 
-		responseData, _ := ioutil.ReadAll(response.Body)
-		response.Body.Close()
-		assert.Equal(t, item.expectedBody, string(responseData), fmt.Sprintf("unexpected response for %s", item.desc))
-		assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", item.desc))
+
+func TestHandleReplaceProduceItem(t *testing.T) {
+	t.Parallel()
+	var replaceItemTests = []struct {
+		desc         string
+		method       string
+		path         string
+		statusCode   int
+		pItemJSON    string
+		expectedBody string
+	}{
+		{"replace existing item", "PUT", "/api/produce/A12T-4GH7-QPL9-3N4M",
+			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Romaine","unit_price":"$2.10"}`, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Romaine","unit_price":"$2.10"}`},
+		//
+		{"code taken from url when omitted", "PUT", "/api/produce/a12t-4gh7-qpl9-3n4m",
+			200, `{"name":"Romaine","unit_price":"$2.10"}`, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Romaine","unit_price":"$2.10"}`},
+		//
+		{"create item at url", "PUT", "/api/produce/1111-2222-3333-4444",
+			201, `{"name":"Kale","unit_price":"$1.99"}`, `{"produce_code":"1111-2222-3333-4444","name":"Kale","unit_price":"$1.99"}`},
+		//
+		{"body code does not match url", "PUT", "/api/produce/A12T-4GH7-QPL9-3N4M",
+			400, `{"produce_code":"2222-2222-2222-2222","name":"Kale","unit_price":"$1.99"}`, "error 400 - produce code in body does not match URL\n"},
+		//
+		{"missing fields", "PUT", "/api/produce/A12T-4GH7-QPL9-3N4M",
+			400, `{"unit_price":"$1.99"}`, `{"validationError":{"name":["name field is required","invalid name format"]}}`},
 	}
 
+	for _, item := range replaceItemTests {
+		f := newFixture(t, nil)
+		f.request(item.method, item.path, item.pItemJSON).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
 }
 
+//test that the update, replace and patch end points return the item as stored, stock sent by the client is ignored
+func TestHandlersReturnStoredItem(t *testing.T) {
+	t.Parallel()
+	seed := []ProduceItem{{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", UnitPrice: "$3.46", Stock: &StockLevel{OnHand: 4, Unit: "each"}}}
+	stored := `"stock":{"on_hand":4,"unit":"each","allow_backorder":false}}`
+	var storedItemTests = []struct {
+		desc         string
+		method       string
+		body         string
+		expectedBody string
+	}{
+		{"update", "POST", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Romaine","unit_price":"$3.46","stock":{"on_hand":99}}`,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Romaine","unit_price":"$3.46",` + stored},
+		//
+		{"replace", "PUT", `{"name":"Iceberg","unit_price":"$2.10","stock":{"on_hand":99}}`,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg","unit_price":"$2.10",` + stored},
+		//
+		{"patch", "PATCH", `{"unit_price":"$1.00","stock":{"on_hand":99}}`,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$1.00",` + stored},
+	}
+
+	for _, item := range storedItemTests {
+		f := newFixture(t, seed)
+		f.request(item.method, "/api/produce/A12T-4GH7-QPL9-3N4M", item.body).assert(t, 200, item.expectedBody, item.desc)
+	}
+}
 
+func TestHandlePatchProduceItem(t *testing.T) {
+	t.Parallel()
+	var patchItemTests = []struct {
+		desc         string
+		path         string
+		contentType  string
+		statusCode   int
+		patchJSON    string
+		expectedBody string
+	}{
+		{"merge patch price only", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/merge-patch+json",
+			200, `{"unit_price":"$1.00"}`, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$1.00"}`},
+		//
+		{"plain json is a merge patch", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/json",
+			200, `{"name":"Iceberg Lettuce"}`, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg Lettuce","unit_price":"$3.46"}`},
+		//
+		{"merge patch null removes required field", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/merge-patch+json",
+			400, `{"name":null}`, `{"validationError":{"name":["name field is required","invalid name format"]}}`},
+		//
+		{"merge patch invalid value", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/merge-patch+json",
+			400, `{"unit_price":"1.00"}`, `{"validationError":{"unit_price":["invalid unit price format"]}}`},
+		//
+		{"merge patch value of wrong type", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/merge-patch+json",
+			400, `{"unit_price":5}`, `{"validationError":{"unit_price":["invalid value type"]}}`},
+		//
+		{"json patch value of wrong type", "/api/produce/A12T-4GH7-QPL9-3N4M", "application/json-patch+json",
+			400, `[{"op":"replace","path":"/name","value":["Lettuce"]}]`, `{"validationError":{"name":["invalid value type"]}}`},
+		//
+		{"json patch replace", "/api/produce/E5T6-9UI3-TH15-QR88", "application/json-patch+json",
+			200, `[{"op":"test","path":"/unit_price","value":"$2.99"},{"op":"replace","path":"/unit_price","value":"$2.49"}]`,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.49"}`},
+		//
+		{"json patch failed test", "/api/produce/E5T6-9UI3-TH15-QR88", "application/json-patch+json",
+			409, `[{"op":"test","path":"/unit_price","value":"$9.99"},{"op":"replace","path":"/unit_price","value":"$2.49"}]`,
+			"error 409 - test failed for /unit_price\n"},
+		//
+		{"json patch to existing code", "/api/produce/E5T6-9UI3-TH15-QR88", "application/json-patch+json",
+			409, `[{"op":"replace","path":"/produce_code","value":"2222-2222-2222-2222"}]`,
+			"error 409 - updated produce code value already exists\n"},
+		//
+		{"json patch unknown op", "/api/produce/E5T6-9UI3-TH15-QR88", "application/json-patch+json",
+			400, `[{"op":"frobnicate","path":"/name"}]`, "error 400 - operation 0 has unknown op \"frobnicate\"\n"},
+		//
+		{"unsupported content type", "/api/produce/E5T6-9UI3-TH15-QR88", "text/plain",
+			415, `name=Peach`, "error 415 - unsupported patch content type\n"},
+		//
+		{"produce code does not exist", "/api/produce/E5T6-9UI3-TH15-1111", "application/merge-patch+json",
+			404, `{"name":"Peach"}`, "error 404 - produce code does not exist\n"},
+	}
+
+	for _, item := range patchItemTests {
+		f := newFixture(t, nil)
+		f.request("PATCH", item.path, item.patchJSON, "Content-Type", item.contentType).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
+
+func TestHandleTrash(t *testing.T) {
+	t.Parallel()
+	var trashTests = []struct {
+		desc         string
+		method       string
+		path         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"restore deleted item", "POST", "/api/produce/trash/A12T-4GH7-QPL9-3N4M/restore",
+			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
+		//
+		{"restore code not in trash", "POST", "/api/produce/trash/E5T6-9UI3-TH15-QR88/restore",
+			404, "error 404 - produce code not found in trash\n"},
+		//
+		{"restore invalid code", "POST", "/api/produce/trash/A12T-4GH7/restore",
+			400, "error 400 - invalid produce code format\n"},
+		//
+		{"purge deleted item", "DELETE", "/api/produce/trash/A12T-4GH7-QPL9-3N4M",
+			200, `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
+		//
+		{"deleted item hidden from listing", "GET", "/api/produce",
+			200, `[{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"},{"produce_code":"YRT6-72AS-K736-L4AR","name":"Green Pepper","unit_price":"$0.79"},{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","unit_price":"$3.59"}]`},
+		//
+		{"deleted item hidden from get", "GET", "/api/produce/A12T-4GH7-QPL9-3N4M",
+			404, "error 404 - produce code does not exist\n"},
+	}
+
+	for _, item := range trashTests {
+		f := newFixture(t, nil)
+		f.request("DELETE", "/api/produce/A12T-4GH7-QPL9-3N4M", "")
+		f.request(item.method, item.path, "").assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
+
+//test that handlers stop with a status 503 when the request context is done before the model is called
+func TestHandlersStopOnDoneRequest(t *testing.T) {
+	t.Parallel()
+	var stoppedRequestTests = []struct {
+		desc   string
+		method string
+		path   string
+		body   string
+	}{
+		{"list items", "GET", "/api/produce", ""},
+		{"get item", "GET", "/api/produce/2222-2222-2222-2222", ""},
+		{"create item", "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Bacon","unit_price":"$1.23"}`},
+		{"update item", "POST", "/api/produce/2222-2222-2222-2222", `{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","unit_price":"$1.23"}`},
+		{"delete item", "DELETE", "/api/produce/2222-2222-2222-2222", ""},
+		{"price cart", "POST", "/api/cart", `{"items":[{"produce_code":"2222-2222-2222-2222"}]}`},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, item := range stoppedRequestTests {
+		db := newTestDB(nil)
+		before := db.catalog()
+		response := httptest.NewRecorder()
+		request := httptest.NewRequest(item.method, item.path, strings.NewReader(item.body)).WithContext(ctx)
+		handlersFor(db).ServeHTTP(response, request)
+
+		assert.Equal(t, 503, response.Code, fmt.Sprintf("unexpected status code for %s", item.desc))
+		assert.Equal(t, "error 503 - context canceled\n", response.Body.String(), fmt.Sprintf("unexpected response for %s", item.desc))
+		assert.True(t, before == db.catalog(), fmt.Sprintf("database changed for %s", item.desc))
+	}
+}
@@ -0,0 +1,151 @@
+//contains validation of the barcodes scanners read, PLU, UPC-A and EAN-13, and the lookup of produce items by barcode
+//along with its handler function
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+//barcode types
+const (
+	barcodePLU   = "plu"    //4 digit price look-up code, or 5 digits with a leading 9 for organic produce
+	barcodeUPCA  = "upc_a"  //12 digit UPC-A with a check digit
+	barcodeEAN13 = "ean_13" //13 digit EAN-13 with a check digit
+)
+
+//returns the type of the barcode or an empty string if it is not a valid PLU, UPC-A or EAN-13. PLUs must be in the
+//3000-4999 range assigned to produce, check digits of UPC-A and EAN-13 codes must match.
+func barcodeType(barcode string) string {
+	if match, _ := regexp.MatchString(`^\d+$`, barcode); !match {
+		return ""
+	}
+
+	switch len(barcode) {
+	case 4:
+		if isValidPLU(barcode) {
+			return barcodePLU
+		}
+	case 5:
+		//organic produce prefixes the conventional PLU with a 9
+		if barcode[0] == '9' && isValidPLU(barcode[1:]) {
+			return barcodePLU
+		}
+	case 12:
+		if hasValidCheckDigit(barcode) {
+			return barcodeUPCA
+		}
+	case 13:
+		if hasValidCheckDigit(barcode) {
+			return barcodeEAN13
+		}
+	}
+	return ""
+}
+
+//checks that a 4 digit PLU is in the range assigned to produce
+func isValidPLU(plu string) bool {
+	number, err := strconv.Atoi(plu)
+	return err == nil && number >= 3000 && number <= 4999
+}
+
+//checks the last digit of a UPC-A or EAN-13 code against the GS1 check digit of the digits before it. From the right,
+//digits are weighted 3 and 1 in turn and the check digit brings the sum up to a multiple of 10.
+func hasValidCheckDigit(barcode string) bool {
+	sum := 0
+	for index := len(barcode) - 2; index >= 0; index-- {
+		digit := int(barcode[index] - '0')
+		if (len(barcode)-2-index)%2 == 0 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(barcode[len(barcode)-1]-'0')
+}
+
+//returns the key barcodes are compared by. A UPC-A code is the EAN-13 code with a leading 0, so both forms of the same
+//code share a key.
+func barcodeKey(barcode string) string {
+	if len(barcode) == 12 {
+		return "0" + barcode
+	}
+	return barcode
+}
+
+//adds an error to errs for every barcode that is invalid, listed twice or already belongs to another item of the
+//catalog than the one stored under pCode
+func validateItemBarcodes(current *catalog, pCode string, pItem *ProduceItem, errs url.Values) {
+	seen := map[string]bool{}
+	for _, barcode := range pItem.Barcodes {
+		if barcodeType(barcode) == "" {
+			errs.Add("barcodes", fmt.Sprintf("invalid barcode %q", barcode))
+			continue
+		}
+		if seen[barcodeKey(barcode)] {
+			errs.Add("barcodes", fmt.Sprintf("duplicate barcode %q", barcode))
+			continue
+		}
+		seen[barcodeKey(barcode)] = true
+
+		for _, item := range current.Data {
+			if item.ProduceCode != pCode && item.hasBarcode(barcode) {
+				errs.Add("barcodes", fmt.Sprintf("barcode %q already belongs to %s", barcode, item.ProduceCode))
+			}
+		}
+	}
+}
+
+//checks if the item carries the barcode in any of its forms
+func (pItem *ProduceItem) hasBarcode(barcode string) bool {
+	for _, own := range pItem.Barcodes {
+		if barcodeKey(own) == barcodeKey(barcode) {
+			return true
+		}
+	}
+	return false
+}
+
+//returns a copy of the item that carries the given barcode, errProduceNotFound is returned if no item does. The item
+//is read from the current catalog so no lock is needed.
+func (db *DBObject) getProduceItemByBarcode(ctx context.Context, barcode string) (ProduceItem, error) {
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	for _, item := range db.catalog().Data {
+		if item.hasBarcode(barcode) {
+			return item.clone(), nil
+		}
+	}
+	return ProduceItem{}, errProduceNotFound
+}
+
+//This function resolves the PLU, UPC-A or EAN-13 barcode in the URL to the produce item that carries it and returns
+//the item with a 200 status code. UPC-A codes also match the same code stored as EAN-13 and the other way around. A
+//barcode that is not valid or fails its check digit triggers a status 400, an unknown barcode a status 404.
+func handleLookupBarcode(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	barcode := mux.Vars(r)["barcode"]
+
+	if barcodeType(barcode) == "" {
+		http.Error(w, "error 400 - invalid barcode", 400)
+		return
+	}
+
+	pItem, err := db.getProduceItemByBarcode(r.Context(), barcode) //find the item that carries the barcode
+
+	//if no item carries the barcode
+	if err == errProduceNotFound {
+		http.Error(w, "error 404 - barcode does not exist", 404)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, db.withEffectivePrice(pItem))
+}
@@ -0,0 +1,80 @@
+//tests for barcodes.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBarcodeType(t *testing.T) {
+	t.Parallel()
+	var barcodeTests = []struct {
+		barcode      string
+		expectedType string
+	}{
+		{"4011", barcodePLU},
+		{"3000", barcodePLU},
+		{"4999", barcodePLU},
+		{"94011", barcodePLU},
+		{"2011", ""},
+		{"5000", ""},
+		{"84011", ""},
+		{"92011", ""},
+		{"036000291452", barcodeUPCA},
+		{"036000291453", ""},
+		{"4006381333931", barcodeEAN13},
+		{"0036000291452", barcodeEAN13},
+		{"4006381333932", ""},
+		{"40063813339", ""},
+		{"4O11", ""},
+		{"", ""},
+	}
+
+	for _, test := range barcodeTests {
+		assert.Equal(t, test.expectedType, barcodeType(test.barcode), fmt.Sprintf("wrong type for %q", test.barcode))
+	}
+}
+
+func TestHandleBarcodes(t *testing.T) {
+	t.Parallel()
+	barcodeUrl := "/api/barcodes"
+	f := newFixture(t, nil)
+
+	var barcodeTests = []struct {
+		desc         string
+		method       string
+		url          string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"invalid barcodes", "PUT", "/api/produce/A12T-4GH7-QPL9-3N4M", `{"name":"Lettuce","unit_price":"$3.46","barcodes":["2011","036000291453","4011","4011"]}`, 400,
+			`{"validationError":{"barcodes":["invalid barcode \"2011\"","invalid barcode \"036000291453\"","duplicate barcode \"4011\""]}}`},
+		//
+		{"add barcodes", "PUT", "/api/produce/A12T-4GH7-QPL9-3N4M", `{"name":"Lettuce","unit_price":"$3.46","barcodes":["4640","94640","036000291452"]}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","barcodes":["4640","94640","036000291452"]}`},
+		//
+		{"keep own barcodes", "POST", "/api/produce/A12T-4GH7-QPL9-3N4M", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg Lettuce","unit_price":"$3.46","barcodes":["4640","036000291452"]}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg Lettuce","unit_price":"$3.46","barcodes":["4640","036000291452"]}`},
+		//
+		{"barcode of another item", "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Romaine","unit_price":"$2.49","barcodes":["0036000291452"]}`, 400,
+			`{"validationError":{"barcodes":["barcode \"0036000291452\" already belongs to A12T-4GH7-QPL9-3N4M"]}}`},
+		//
+		{"lookup PLU", "GET", barcodeUrl + "/4640", "", 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg Lettuce","unit_price":"$3.46","barcodes":["4640","036000291452"]}`},
+		//
+		{"lookup UPC-A as EAN-13", "GET", barcodeUrl + "/0036000291452", "", 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Iceberg Lettuce","unit_price":"$3.46","barcodes":["4640","036000291452"]}`},
+		//
+		{"lookup removed organic PLU", "GET", barcodeUrl + "/94640", "", 404, "error 404 - barcode does not exist\n"},
+		//
+		{"lookup bad check digit", "GET", barcodeUrl + "/036000291453", "", 400, "error 400 - invalid barcode\n"},
+		//
+		{"lookup produce code", "GET", barcodeUrl + "/A12T-4GH7-QPL9-3N4M", "", 400, "error 400 - invalid barcode\n"},
+	}
+
+	for _, item := range barcodeTests {
+		f.request(item.method, item.url, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
@@ -0,0 +1,233 @@
+//contains the cart pricing engine that prices a basket of produce items server side, along with its handler function
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//type to store a cart sent to the cart end point
+type CartRequest struct {
+	Items []CartItem `json:"items"`
+}
+
+//type to store a single entry of a cart. Quantity is a count for items priced per each or bunch and a weight for items
+//priced per lb or kg, it defaults to 1. Unit defaults to the price unit of the item.
+type CartItem struct {
+	ProduceCode string      `json:"produce_code"`
+	Quantity    json.Number `json:"quantity"`
+	Unit        string      `json:"unit"`
+}
+
+//type to store a priced cart entry. ExtendedPrice is the price before promotions and Discount the amount the applied
+//Promotions take off it. Tax is charged on the discounted price at the rate of TaxCategory. Lines that could not be
+//priced only carry the produce code and an error in the same format the produce end points use.
+type CartLine struct {
+	ProduceCode   string   `json:"produce_code"`
+	Name          string   `json:"name,omitempty"`
+	Quantity      string   `json:"quantity,omitempty"`
+	Unit          string   `json:"unit,omitempty"`
+	UnitPrice     string   `json:"unit_price,omitempty"`
+	PriceUnit     string   `json:"price_unit,omitempty"`
+	ExtendedPrice string   `json:"extended_price,omitempty"`
+	Discount      string   `json:"discount,omitempty"`
+	Promotions    []string `json:"promotions,omitempty"`
+	TaxCategory   string   `json:"tax_category,omitempty"`
+	Tax           string   `json:"tax,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+//type to store a priced cart. Subtotal is the sum of the extended prices, Discount the sum of the line discounts and
+//Tax the tax of the lines rounded as the jurisdiction requires.
+type CartTotals struct {
+	Lines    []CartLine `json:"lines"`
+	Subtotal string     `json:"subtotal"`
+	Discount string     `json:"discount,omitempty"`
+	Tax      string     `json:"tax"`
+	Total    string     `json:"total"`
+}
+
+//prices every entry of the cart by looking it up in the produce store and applying the promotions active now.
+//Entries with an invalid or unknown code, an invalid quantity or an amount that would take the totals beyond
+//maxAmountCents become error lines and are left out of the totals. Each line is rounded to the cent once after its
+//discounts. Tax is taken from the active tax table and rounded per line or once for the cart depending on the
+//jurisdiction. Pricing stops with the error of the context when it is done.
+//
+//A cart priced for a store uses the prices and availability of the store and the tax table of its jurisdiction,
+//entries the store does not sell become error lines. errStoreNotFound is returned if the store does not exist.
+func (db *DBObject) priceCart(ctx context.Context, items []CartItem, storeID string) (CartTotals, error) {
+	totals := CartTotals{Lines: []CartLine{}}
+	var subtotal, discounts, taxes int64
+	now := time.Now()
+	table := db.taxBook().activeTable()
+	var overrides map[string]StoreOverride
+	if storeID != "" {
+		store, ok := db.getStore(storeID)
+		if !ok {
+			return CartTotals{}, errStoreNotFound
+		}
+		overrides, _ = db.storeOverrides(storeID)
+		if storeTable, ok := db.taxBook().jurisdictionTable(store.Jurisdiction); ok {
+			table = storeTable
+		}
+	}
+	exactTax := new(big.Rat)
+
+	for _, cartItem := range items {
+		line := CartLine{ProduceCode: strings.ToUpper(cartItem.ProduceCode)}
+
+		quantityParam := cartItem.Quantity.String()
+		if quantityParam == "" {
+			quantityParam = "1"
+		}
+		quantity, err := parseQuantity(quantityParam)
+
+		switch {
+		case !db.isValidProduceCode(line.ProduceCode):
+			line.Error = "error 400 - invalid produce code format"
+		case err != nil:
+			line.Error = "error 400 - " + err.Error()
+		}
+		if line.Error != "" {
+			totals.Lines = append(totals.Lines, line)
+			continue
+		}
+
+		pItem, err := db.getProduceItem(ctx, line.ProduceCode) // get item of corresponding code from DB
+		if err == errProduceNotFound {
+			line.Error = "error 404 - produce code does not exist"
+			totals.Lines = append(totals.Lines, line)
+			continue
+		}
+		if err != nil {
+			return CartTotals{}, err
+		}
+		override := overrides[pItem.ProduceCode]
+		if override.Available != nil && !*override.Available {
+			line.Error = "error 404 - produce code is not sold in this store"
+			totals.Lines = append(totals.Lines, line)
+			continue
+		}
+		if override.UnitPrice != nil {
+			pItem.UnitPrice = *override.UnitPrice
+		}
+
+		line.Unit = cartItem.Unit
+		if line.Unit == "" {
+			line.Unit = pItem.pricingUnit()
+		}
+		price, err := exactPrice(pItem, quantity, line.Unit)
+		if err != nil {
+			line.Error = "error 400 - " + err.Error()
+			line.Unit = ""
+			totals.Lines = append(totals.Lines, line)
+			continue
+		}
+		converted, _ := quantityInPriceUnit(pItem, quantity, line.Unit)
+		discount, promotions := db.promotionDiscount(pItem, converted, now)
+
+		cents, err := roundToCents(price)
+		discounted, discountedErr := roundToCents(new(big.Rat).Sub(price, discount))
+		discountCents := cents - discounted
+
+		//unknown categories are rejected when items are saved, a table swapped in later falls back to the default
+		rate, ok := table.rate(pItem.TaxCategory)
+		if !ok {
+			rate, _ = table.rate("")
+		}
+		lineTax := new(big.Rat).Mul(big.NewRat(discounted, 100), rate)
+		lineTaxCents, taxErr := table.round(lineTax)
+
+		//the totals must stay in range as well
+		switch {
+		case err != nil:
+		case discountedErr != nil:
+			err = discountedErr
+		case taxErr != nil:
+			err = taxErr
+		case subtotal+cents > maxAmountCents || taxes+lineTaxCents > maxAmountCents:
+			err = errAmountTooLarge
+		}
+		if err != nil {
+			line.Error = "error 400 - " + err.Error()
+			line.Unit = ""
+			totals.Lines = append(totals.Lines, line)
+			continue
+		}
+
+		line.Name = pItem.Name
+		line.Quantity = quantityParam
+		line.UnitPrice = pItem.UnitPrice
+		line.PriceUnit = pItem.pricingUnit()
+		line.ExtendedPrice = formatCents(cents)
+		if discountCents > 0 {
+			line.Discount = formatCents(discountCents)
+			line.Promotions = promotions
+		}
+
+		line.TaxCategory = pItem.TaxCategory
+		line.Tax = formatCents(lineTaxCents)
+
+		totals.Lines = append(totals.Lines, line)
+		subtotal += cents
+		discounts += discountCents
+		taxes += lineTaxCents
+		exactTax.Add(exactTax, lineTax)
+	}
+
+	//the lines kept the per line taxes in range, their exact sum rounds to at most a cent per line more
+	tax := taxes
+	if table.RoundPer == roundPerInvoice {
+		if invoiceTax, err := table.round(exactTax); err == nil {
+			tax = invoiceTax
+		}
+	}
+
+	totals.Subtotal = formatCents(subtotal)
+	if discounts > 0 {
+		totals.Discount = formatCents(discounts)
+	}
+	totals.Tax = formatCents(tax)
+	totals.Total = formatCents(subtotal - discounts + tax)
+	return totals, nil
+}
+
+//This function decodes a cart from the JSON body and returns its lines, subtotal, tax and total with a 200 status
+//code. Entries that can not be priced are reported per line and do not fail the whole cart. Invalid JSON or a cart
+//without items triggers a status 400. On the store route the cart is priced as sold in the store in the URL and an
+//unknown store triggers a status 404.
+func handlePriceCart(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var cart CartRequest
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	err := decoder.Decode(&cart) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	if len(cart.Items) == 0 {
+		http.Error(w, "error 400 - cart has no items", http.StatusBadRequest)
+		return
+	}
+
+	totals, err := db.priceCart(r.Context(), cart.Items, mux.Vars(r)["store_id"])
+	if err == errStoreNotFound {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, totals)
+}
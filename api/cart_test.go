@@ -0,0 +1,49 @@
+//tests for cart.go
+package api
+
+import (
+	"testing"
+)
+
+func TestHandlePriceCart(t *testing.T) {
+	var cartTests = []struct {
+		desc         string
+		taxRate      string
+		cartJSON     string
+		statusCode   int
+		expectedBody string
+	}{
+		{"counted and weighed items", "0", `{"items":[{"produce_code":"YRT6-72AS-K736-L4AR","quantity":3},{"produce_code":"e5t6-9ui3-th15-qr88","quantity":"1.5","unit":"lb"}]}`, 200,
+			`{"lines":[{"produce_code":"YRT6-72AS-K736-L4AR","name":"Green Pepper","quantity":"3","unit":"each","unit_price":"$0.79","price_unit":"each","extended_price":"$2.37","tax":"$0.00"},` +
+				`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","quantity":"1.5","unit":"lb","unit_price":"$2.99","price_unit":"lb","extended_price":"$4.49","tax":"$0.00"}],` +
+				`"subtotal":"$6.86","tax":"$0.00","total":"$6.86"}`},
+		//
+		{"tax on subtotal", "0.0575", `{"items":[{"produce_code":"2222-2222-2222-2222"}]}`, 200,
+			`{"lines":[{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","quantity":"1","unit":"each","unit_price":"$3.59","price_unit":"each","extended_price":"$3.59","tax":"$0.21"}],` +
+				`"subtotal":"$3.59","tax":"$0.21","total":"$3.80"}`},
+		//
+		{"per line errors", "0", `{"items":[{"produce_code":"ABCDe-1234-EFGH-5678"},{"produce_code":"ABCD-1234-EFGH-0000"},{"produce_code":"2222-2222-2222-2222","quantity":"0.5"},{"produce_code":"2222-2222-2222-2222","quantity":2}]}`, 200,
+			`{"lines":[{"produce_code":"ABCDE-1234-EFGH-5678","error":"error 400 - invalid produce code format"},` +
+				`{"produce_code":"ABCD-1234-EFGH-0000","error":"error 404 - produce code does not exist"},` +
+				`{"produce_code":"2222-2222-2222-2222","error":"error 400 - quantity must be a whole number for count priced items"},` +
+				`{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","quantity":"2","unit":"each","unit_price":"$3.59","price_unit":"each","extended_price":"$7.18","tax":"$0.00"}],` +
+				`"subtotal":"$7.18","tax":"$0.00","total":"$7.18"}`},
+		//
+		{"amount too large", "0", `{"items":[{"produce_code":"2222-2222-2222-2222","quantity":"99999999999999999999"},{"produce_code":"2222-2222-2222-2222"}]}`, 200,
+			`{"lines":[{"produce_code":"2222-2222-2222-2222","error":"error 400 - amount is too large to be priced"},` +
+				`{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","quantity":"1","unit":"each","unit_price":"$3.59","price_unit":"each","extended_price":"$3.59","tax":"$0.00"}],` +
+				`"subtotal":"$3.59","tax":"$0.00","total":"$3.59"}`},
+		//
+		{"empty cart", "0", `{"items":[]}`, 400, "error 400 - cart has no items\n"},
+		//
+		{"bad JSON syntax", "0", `{"items":[`, 400, "error 400 - invalid JSON syntax\n"},
+	}
+
+	for _, item := range cartTests {
+		seed := seedItems()
+		seed[1].PriceUnit = "lb"
+		f := newFixture(t, seed)
+		useSalesTaxRate(t, item.taxRate)
+		f.request("POST", "/api/cart", item.cartJSON).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
@@ -0,0 +1,100 @@
+//contains the copy-on-write catalog that holds the produce items and the trash of a database, so catalog reads never
+//wait on writers
+package api
+
+//type to store one version of the produce items and the trash of a database. A catalog is never changed once it has
+//been published, writers publish a changed copy instead. Readers load the current catalog without taking a lock and
+//can keep using it while newer versions are published. Items are cloned when they are stored in or handed out of a
+//catalog by the model methods, so no caller holds memory a catalog shares with other readers.
+type catalog struct {
+	Data  []ProduceItem
+	Trash []TrashedItem
+}
+
+//the catalog of a database nothing has been stored in yet
+var emptyCatalog = &catalog{Data: []ProduceItem{}}
+
+//returns the current catalog of the database. It is shared with every other reader and must not be changed.
+func (db *DBObject) catalog() *catalog {
+	if current, ok := db.current.Load().(*catalog); ok {
+		return current
+	}
+	return emptyCatalog
+}
+
+//publishes next as the current catalog of the database. Callers must hold the write lock unless no other goroutine
+//can reach the database yet, as for a database being set up.
+func (db *DBObject) storeCatalog(next *catalog) {
+	db.current.Store(next)
+}
+
+//returns copies of the items of the catalog that share no memory with it
+func (c *catalog) items() []ProduceItem {
+	items := make([]ProduceItem, len(c.Data))
+	for index, item := range c.Data {
+		items[index] = item.clone()
+	}
+	return items
+}
+
+//returns a copy of the item that shares no memory with it, its barcodes and stock level are copied as well
+func (pItem ProduceItem) clone() ProduceItem {
+	if pItem.Barcodes != nil {
+		pItem.Barcodes = append([]string{}, pItem.Barcodes...)
+	}
+	if pItem.Stock != nil {
+		stock := *pItem.Stock
+		pItem.Stock = &stock
+	}
+	return pItem
+}
+
+//returns the index of the item of the given produce code or -1 if there is none
+func (c *catalog) index(pCode string) int {
+	for index, item := range c.Data {
+		if item.ProduceCode == pCode {
+			return index
+		}
+	}
+	return -1
+}
+
+//returns a copy of the catalog with the item at index replaced by pItem, or with pItem appended if index is the number
+//of items
+func (c *catalog) withItem(index int, pItem ProduceItem) *catalog {
+	data := make([]ProduceItem, len(c.Data), len(c.Data)+1)
+	copy(data, c.Data)
+	if index == len(data) {
+		data = append(data, pItem)
+	} else {
+		data[index] = pItem
+	}
+	return &catalog{Data: data, Trash: c.Trash}
+}
+
+//returns a copy of the catalog without the item at index
+func (c *catalog) withoutItem(index int) *catalog {
+	data := make([]ProduceItem, 0, len(c.Data)-1)
+	data = append(append(data, c.Data[:index]...), c.Data[index+1:]...)
+	return &catalog{Data: data, Trash: c.Trash}
+}
+
+//returns a copy of the catalog with the given trash
+func (c *catalog) withTrash(trash []TrashedItem) *catalog {
+	return &catalog{Data: c.Data, Trash: trash}
+}
+
+//returns a new trash slice without the entry of the given produce code along with the removed entry, which is empty
+//if the code is not in the trash
+func (c *catalog) trashWithout(pCode string) ([]TrashedItem, TrashedItem) {
+	trash := make([]TrashedItem, 0, len(c.Trash)+1)
+	var removed TrashedItem
+	for _, item := range c.Trash {
+		if item.ProduceCode == pCode && removed.ProduceCode == "" {
+			removed = item
+			continue
+		}
+		trash = append(trash, item)
+	}
+	return trash, removed
+}
@@ -0,0 +1,145 @@
+//tests and benchmarks for catalog.go
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+//test that a catalog loaded by a reader is left as it was by later writes
+func TestCatalogSnapshotUnchanged(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	snapshot := db.catalog()
+	before := append([]ProduceItem{}, snapshot.Data...)
+
+	ctx := context.Background()
+	db.updateProduceItem(ctx, "A12T-4GH7-QPL9-3N4M", ProduceItem{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Romaine", UnitPrice: "$2.00"})
+	db.deleteProduceItem(ctx, "E5T6-9UI3-TH15-QR88")
+	db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"})
+	db.setStockLevel(ctx, "2222-2222-2222-2222", StockLevel{OnHand: 5, Unit: "each"})
+
+	assert.Equal(t, before, snapshot.Data, "loaded catalog changed by writes")
+	assert.Empty(t, snapshot.Trash, "loaded catalog trash changed by delete")
+	current := db.catalog()
+	assert.Len(t, current.Data, 4, "writes not published")
+	assert.Equal(t, "Romaine", current.Data[0].Name, "update not published")
+	assert.Len(t, current.Trash, 1, "delete not published")
+}
+
+//type to hold the catalog behind a read write mutex the way the database did before catalogs, used as the baseline
+//of BenchmarkCatalogReads and BenchmarkCatalogWrites
+type rwMutexCatalog struct {
+	mu   sync.RWMutex
+	data []ProduceItem
+}
+
+//returns the item of the given produce code under the read lock
+func (c *rwMutexCatalog) item(pCode string) ProduceItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, item := range c.data {
+		if item.ProduceCode == pCode {
+			return item
+		}
+	}
+	return ProduceItem{}
+}
+
+//changes the price of the item at index in place under the write lock
+func (c *rwMutexCatalog) setPrice(index int, price string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[index].UnitPrice = price
+}
+
+//type to hold an implementation the catalog benchmarks compare, read looks up an item by code and write changes the
+//price of the item at an index
+type catalogImpl struct {
+	name  string
+	read  func(pCode string) ProduceItem
+	write func(index int, price string)
+}
+
+//returns the read write mutex baseline and the copy-on-write catalog, each holding benchmarkCatalogSize items
+func catalogImpls() []catalogImpl {
+	locked := &rwMutexCatalog{data: append([]ProduceItem{}, benchmarkDB(benchmarkCatalogSize).catalog().Data...)}
+	db := benchmarkDB(benchmarkCatalogSize)
+	return []catalogImpl{
+		{name: "rwmutex", read: locked.item, write: locked.setPrice},
+		{name: "snapshot",
+			read: func(pCode string) ProduceItem {
+				current := db.catalog()
+				if index := current.index(pCode); index >= 0 {
+					return current.Data[index]
+				}
+				return ProduceItem{}
+			},
+			write: func(index int, price string) {
+				db.mu.Lock()
+				defer db.mu.Unlock()
+				current := db.catalog()
+				item := current.Data[index]
+				item.UnitPrice = price
+				db.storeCatalog(current.withItem(index, item))
+			}},
+	}
+}
+
+//benchmarks parallel item lookups while a number of writers keep changing prices, comparing the read write mutex the
+//database used to hold with the copy-on-write catalog. Readers of the mutex wait for every writer, readers of the
+//catalog never do.
+func BenchmarkCatalogReads(b *testing.B) {
+	for _, writers := range []int{0, 1, 4} {
+		for _, impl := range catalogImpls() {
+			b.Run(fmt.Sprintf("%s/writers=%d", impl.name, writers), func(b *testing.B) {
+				done := make(chan struct{})
+				var wg sync.WaitGroup
+				for writer := 0; writer < writers; writer++ {
+					wg.Add(1)
+					go func(writer int) {
+						defer wg.Done()
+						for step := 0; ; step++ {
+							select {
+							case <-done:
+								return
+							default:
+								impl.write((writer*31+step)%benchmarkCatalogSize, fmt.Sprintf("$%d.99", step%10))
+							}
+						}
+					}(writer)
+				}
+
+				pCode := benchmarkCode(benchmarkCatalogSize / 2)
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if impl.read(pCode).ProduceCode != pCode {
+							b.Error("item not found")
+						}
+					}
+				})
+				b.StopTimer()
+				close(done)
+				wg.Wait()
+			})
+		}
+	}
+}
+
+//benchmarks a single writer changing prices without readers. The catalog copies every item on each write, this is
+//the price paid for reads that never wait.
+func BenchmarkCatalogWrites(b *testing.B) {
+	for _, impl := range catalogImpls() {
+		b.Run(impl.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				impl.write(i%benchmarkCatalogSize, "$2.49")
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+//contains the category tree produce items are grouped in, e.g. Leafy Greens > Lettuce, along with its handler
+//functions
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+//errors returned by category operations
+var (
+	errCategoryNotFound = errors.New("category does not exist")
+	errCategoryExists   = errors.New("category id already exists")
+	errUnknownParent    = errors.New("parent category does not exist")
+	errCategoryCycle    = errors.New("category can not be moved below itself")
+	errCategoryHasChild = errors.New("category has subcategories")
+	errCategoryInUse    = errors.New("category has produce items")
+)
+
+//type to store a category. A category without a ParentID is at the top of the tree.
+type Category struct {
+	ID       string `json:"category_id"`
+	Name     string `json:"name"`
+	ParentID string `json:"parent_id,omitempty"`
+}
+
+//type to hold the category tree of a database
+type categoryTree struct {
+	mu         sync.RWMutex
+	categories []Category
+}
+
+//checks that the category id is a short lowercase slug and the category has a name
+func (category *Category) validateCategory() url.Values {
+	errs := url.Values{}
+
+	if category.ID == "" {
+		errs.Add("category_id", "category id field is required")
+	}
+	if match, _ := regexp.MatchString(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`, category.ID); !match {
+		errs.Add("category_id", "invalid category id format")
+	}
+	if category.Name == "" {
+		errs.Add("name", "name field is required")
+	}
+	return errs
+}
+
+//adds the category error to errs when the item is assigned to a category that does not exist
+func (db *DBObject) validateItemCategory(pItem *ProduceItem, errs url.Values) {
+	if pItem.Category == "" {
+		return
+	}
+	if _, ok := db.getCategory(pItem.Category); !ok {
+		errs.Add("category", "unknown category")
+	}
+}
+
+//returns all categories
+func (db *DBObject) getCategories() []Category {
+	db.categories.mu.RLock()
+	defer db.categories.mu.RUnlock()
+	return append([]Category{}, db.categories.categories...)
+}
+
+//returns the category of the given id or false if it does not exist
+func (db *DBObject) getCategory(id string) (Category, bool) {
+	for _, category := range db.getCategories() {
+		if category.ID == id {
+			return category, true
+		}
+	}
+	return Category{}, false
+}
+
+//returns the ids of the category of the given id and every category below it or false if it does not exist
+func (db *DBObject) categoryDescendants(id string) (map[string]bool, bool) {
+	categories := db.getCategories()
+
+	descendants := map[string]bool{}
+	for _, category := range categories {
+		if category.ID == id {
+			descendants[id] = true
+		}
+	}
+	if len(descendants) == 0 {
+		return nil, false
+	}
+	//add children of the categories found so far until no new ones turn up
+	for grown := true; grown; {
+		grown = false
+		for _, category := range categories {
+			if descendants[category.ParentID] && !descendants[category.ID] {
+				descendants[category.ID] = true
+				grown = true
+			}
+		}
+	}
+	return descendants, true
+}
+
+//checks that the parent of the category exists and is not the category itself or one of its descendants. Callers
+//must hold the category lock.
+func (tree *categoryTree) checkParent(category Category) error {
+	if category.ParentID == "" {
+		return nil
+	}
+	parents := map[string]string{}
+	for _, existing := range tree.categories {
+		parents[existing.ID] = existing.ParentID
+	}
+	if _, ok := parents[category.ParentID]; !ok {
+		return errUnknownParent
+	}
+	for id := category.ParentID; id != ""; id = parents[id] {
+		if id == category.ID {
+			return errCategoryCycle
+		}
+	}
+	return nil
+}
+
+//adds a category below its parent
+func (db *DBObject) addCategory(category Category) error {
+	db.categories.mu.Lock()
+	defer db.categories.mu.Unlock()
+
+	for _, existing := range db.categories.categories {
+		if existing.ID == category.ID {
+			return errCategoryExists
+		}
+	}
+	if err := db.categories.checkParent(category); err != nil {
+		return err
+	}
+	db.categories.categories = append(db.categories.categories, category)
+	return nil
+}
+
+//renames or moves the category of the given id, its subcategories and items move along with it
+func (db *DBObject) replaceCategory(category Category) error {
+	db.categories.mu.Lock()
+	defer db.categories.mu.Unlock()
+
+	for index, existing := range db.categories.categories {
+		if existing.ID == category.ID {
+			if err := db.categories.checkParent(category); err != nil {
+				return err
+			}
+			db.categories.categories[index] = category
+			return nil
+		}
+	}
+	return errCategoryNotFound
+}
+
+//removes the category of the given id. Categories that still have subcategories or produce items assigned to them
+//are kept so no item is left pointing at a missing category. The write lock of the database is held so the items
+//are not changed while they are checked, an item assigned to the category by a request that validated it before the
+//removal is rejected when it is stored, see checkItemReferences.
+func (db *DBObject) removeCategory(id string) (Category, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.categories.mu.Lock()
+	defer db.categories.mu.Unlock()
+
+	for index, category := range db.categories.categories {
+		if category.ID != id {
+			continue
+		}
+		for _, child := range db.categories.categories {
+			if child.ParentID == id {
+				return Category{}, errCategoryHasChild
+			}
+		}
+		for _, item := range db.catalog().Data {
+			if item.Category == id {
+				return Category{}, errCategoryInUse
+			}
+		}
+		db.categories.categories = append(db.categories.categories[:index], db.categories.categories[index+1:]...)
+		return category, nil
+	}
+	return Category{}, errCategoryNotFound
+}
+
+//decodes and validates a category from the request body. On failure the error response is written and false is
+//returned.
+func decodeCategory(w http.ResponseWriter, r *http.Request) (Category, bool) {
+	var category Category
+
+	err := json.NewDecoder(r.Body).Decode(&category) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return category, false
+	}
+
+	//the URL identifies the category when there is one, the body may not name another
+	if id, ok := mux.Vars(r)["category_id"]; ok {
+		if category.ID == "" {
+			category.ID = id
+		}
+		if category.ID != id {
+			http.Error(w, "error 400 - category id in body does not match URL", http.StatusBadRequest)
+			return category, false
+		}
+	}
+
+	validErrs := category.validateCategory()
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return category, false
+	}
+	return category, true
+}
+
+//writes the result of a category operation with the given status code, mapping its error to a status code
+func categoryResponse(w http.ResponseWriter, statusCode int, category Category, err error) {
+	switch err {
+	case nil:
+		jsonResponse(w, statusCode, category)
+	case errCategoryNotFound:
+		http.Error(w, "error 404 - "+err.Error(), 404)
+	case errUnknownParent, errCategoryCycle:
+		err := ValidationError{url.Values{"parent_id": {err.Error()}}}
+		jsonResponse(w, http.StatusBadRequest, err)
+	default:
+		http.Error(w, "error 409 - "+err.Error(), 409)
+	}
+}
+
+//This function lists the categories with a 200 status code.
+func handleGetCategories(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	jsonResponse(w, http.StatusOK, db.getCategories())
+}
+
+//This function creates a category from the JSON body and returns it with a 201 status code. Invalid JSON, a category
+//that fails validation or an unknown parent triggers a status 400 and a category id that is already taken a 409.
+func handleCreateCategory(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	category, ok := decodeCategory(w, r)
+	if !ok {
+		return
+	}
+	categoryResponse(w, http.StatusCreated, category, db.addCategory(category))
+}
+
+//This function returns the category of the id in the URL with a 200 status code or triggers a 404.
+func handleGetCategory(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	category, ok := db.getCategory(mux.Vars(r)["category_id"])
+	if !ok {
+		http.Error(w, "error 404 - category does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, category)
+}
+
+//This function renames the category of the id in the URL or moves it below another parent from the JSON body and
+//returns it with a 200 status code. Invalid JSON, an invalid category, an unknown parent or a parent below the
+//category itself triggers a status 400 and an unknown id a 404.
+func handleReplaceCategory(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	category, ok := decodeCategory(w, r)
+	if !ok {
+		return
+	}
+	categoryResponse(w, http.StatusOK, category, db.replaceCategory(category))
+}
+
+//This function removes the category of the id in the URL and returns it with a 200 status code. An unknown id
+//triggers a status 404, a category that still has subcategories or produce items a 409.
+func handleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	category, err := db.removeCategory(mux.Vars(r)["category_id"])
+	categoryResponse(w, http.StatusOK, category, err)
+}
+
+//returns the items that belong to the given categories
+func filterByCategory(pItems []ProduceItem, categories map[string]bool) []ProduceItem {
+	filtered := []ProduceItem{}
+	for _, pItem := range pItems {
+		if categories[pItem.Category] {
+			filtered = append(filtered, pItem)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,99 @@
+//tests for categories.go
+package api
+
+import (
+	"testing"
+)
+
+func TestHandleCategories(t *testing.T) {
+	t.Parallel()
+	categoryUrl := "/api/categories"
+	f := newFixture(t, seedItems()[:2])
+
+	var categoryTests = []struct {
+		desc         string
+		method       string
+		url          string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"invalid category", "POST", categoryUrl, `{"category_id":"Leafy Greens"}`, 400,
+			`{"validationError":{"category_id":["invalid category id format"],"name":["name field is required"]}}`},
+		//
+		{"create top category", "POST", categoryUrl, `{"category_id":"fruit","name":"Fruit"}`, 201,
+			`{"category_id":"fruit","name":"Fruit"}`},
+		//
+		{"create child category", "POST", categoryUrl, `{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}`, 201,
+			`{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}`},
+		//
+		{"create leafy greens", "POST", categoryUrl, `{"category_id":"leafy-greens","name":"Leafy Greens"}`, 201,
+			`{"category_id":"leafy-greens","name":"Leafy Greens"}`},
+		//
+		{"duplicate category", "POST", categoryUrl, `{"category_id":"fruit","name":"Fruit"}`, 409,
+			"error 409 - category id already exists\n"},
+		//
+		{"unknown parent", "POST", categoryUrl, `{"category_id":"citrus","name":"Citrus","parent_id":"fruits"}`, 400,
+			`{"validationError":{"parent_id":["parent category does not exist"]}}`},
+		//
+		{"move below own child", "PUT", categoryUrl + "/fruit", `{"name":"Fruit","parent_id":"stone-fruit"}`, 400,
+			`{"validationError":{"parent_id":["category can not be moved below itself"]}}`},
+		//
+		{"id in body does not match", "PUT", categoryUrl + "/fruit", `{"category_id":"veg","name":"Fruit"}`, 400,
+			"error 400 - category id in body does not match URL\n"},
+		//
+		{"rename category", "PUT", categoryUrl + "/fruit", `{"name":"Fresh Fruit"}`, 200,
+			`{"category_id":"fruit","name":"Fresh Fruit"}`},
+		//
+		{"get category", "GET", categoryUrl + "/stone-fruit", "", 200,
+			`{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}`},
+		//
+		{"assign unknown category", "POST", "/api/produce/E5T6-9UI3-TH15-QR88", `{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","category":"stone"}`, 400,
+			`{"validationError":{"category":["unknown category"]}}`},
+		//
+		{"assign category", "POST", "/api/produce/E5T6-9UI3-TH15-QR88", `{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","category":"stone-fruit"}`, 200,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","category":"stone-fruit"}`},
+		//
+		{"assign category with patch", "PATCH", "/api/produce/A12T-4GH7-QPL9-3N4M", `{"category":"leafy-greens"}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","category":"leafy-greens"}`},
+		//
+		{"filter includes descendants", "GET", "/api/produce?category=fruit", "", 200,
+			`[{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","category":"stone-fruit"}]`},
+		//
+		{"filter leaf category", "GET", "/api/produce?category=leafy-greens", "", 200,
+			`[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","category":"leafy-greens"}]`},
+		//
+		{"filter unknown category", "GET", "/api/produce?category=dairy", "", 404, "error 404 - category does not exist\n"},
+		//
+		{"delete category with children", "DELETE", categoryUrl + "/fruit", "", 409, "error 409 - category has subcategories\n"},
+		//
+		{"delete category with items", "DELETE", categoryUrl + "/stone-fruit", "", 409, "error 409 - category has produce items\n"},
+		//
+		{"delete unknown category", "DELETE", categoryUrl + "/dairy", "", 404, "error 404 - category does not exist\n"},
+		//
+		{"unassign category", "POST", "/api/produce/A12T-4GH7-QPL9-3N4M", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`},
+		//
+		{"delete category", "DELETE", categoryUrl + "/leafy-greens", "", 200, `{"category_id":"leafy-greens","name":"Leafy Greens"}`},
+		//
+		{"list categories", "GET", categoryUrl, "", 200,
+			`[{"category_id":"fruit","name":"Fresh Fruit"},{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}]`},
+		//
+		{"trash item of category", "DELETE", "/api/produce/E5T6-9UI3-TH15-QR88", "", 200,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","category":"stone-fruit"}`},
+		//
+		{"delete category of trashed item", "DELETE", categoryUrl + "/stone-fruit", "", 200,
+			`{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}`},
+		//
+		{"restore item of deleted category", "POST", "/api/produce/trash/E5T6-9UI3-TH15-QR88/restore", "", 409,
+			"error 409 - category: unknown category\n"},
+	}
+
+	for _, item := range categoryTests {
+		var headers []string
+		if item.method == "PATCH" {
+			headers = []string{"Content-Type", "application/merge-patch+json"}
+		}
+		f.request(item.method, item.url, item.body, headers...).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
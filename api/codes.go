@@ -0,0 +1,214 @@
+//contains the schemes produce codes are checked against and the generators that issue new codes when a client leaves
+//the produce code out
+package api
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//characters generated codes are made of, a check character is drawn from the same set
+const (
+	alphanumericChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	numericChars      = "0123456789"
+)
+
+//limits of the SKU length, with fewer digits there are too few codes to generate unused ones from
+const (
+	minSKUDigits = 6
+	maxSKUDigits = 32
+)
+
+//number of codes generated before giving up on finding one that is not used yet
+const maxCodeAttempts = 100
+
+//type implemented by every produce code scheme. Valid reports whether a code is in the format of the scheme.
+type CodeScheme interface {
+	Valid(code string) bool
+}
+
+//type implemented by schemes that can issue new codes. Generate returns a random code with a check character in the
+//format of the scheme, collisions are avoided by the caller.
+type CodeGenerator interface {
+	CodeScheme
+	Generate() string
+}
+
+//the scheme produce codes are checked against and generated in for the default database and every tenant that has no
+//scheme of its own. Must be set before the server starts.
+var ProduceCodes CodeScheme = AlphanumericCodes{}
+
+//returns the scheme produce codes of the database are checked against and generated in, ProduceCodes unless the
+//tenant was given its own
+func (db *DBObject) codeScheme() CodeScheme {
+	if db.codes != nil {
+		return db.codes
+	}
+	return ProduceCodes
+}
+
+//checks a produce code against the scheme of the database. Codes of items already stored or in the trash stay valid
+//so items created before the scheme was changed can still be reached.
+func (db *DBObject) isValidProduceCode(produceCode string) bool {
+	if db.codeScheme().Valid(produceCode) {
+		return true
+	}
+	current := db.catalog()
+	if current.index(produceCode) >= 0 {
+		return true
+	}
+	for _, item := range current.Trash {
+		if item.ProduceCode == produceCode {
+			return true
+		}
+	}
+	return false
+}
+
+//scheme of four groups of four alphanumeric characters, e.g. A12T-4GH7-QPL9-3N4M. When Checked is set the last
+//character must be the Luhn mod 36 check character of the others, generated codes always carry one.
+type AlphanumericCodes struct {
+	Checked bool
+}
+
+//scheme of numeric SKUs of a fixed number of digits. When Checked is set the last digit must be the Luhn check digit
+//of the others, generated codes always carry one.
+type NumericCodes struct {
+	Digits  int
+	Checked bool
+}
+
+//scheme of codes matching a custom regular expression. Codes can not be generated for it.
+type PatternCodes struct {
+	pattern *regexp.Regexp
+}
+
+//returns the scheme described by spec: "4x4" for four groups of four alphanumeric characters, "sku:N" for numeric
+//SKUs of N digits, 6 to 32, either followed by "+luhn" to require a check character, or "regex:PATTERN" for a custom
+//pattern the whole code must match
+func ParseCodeScheme(spec string) (CodeScheme, error) {
+	switch {
+	case strings.HasPrefix(spec, "regex:"):
+		source := strings.TrimPrefix(spec, "regex:")
+		if _, err := regexp.Compile(source); err != nil {
+			return nil, fmt.Errorf("invalid produce code pattern: %v", err)
+		}
+		return PatternCodes{regexp.MustCompile(`^(?:` + source + `)$`)}, nil
+	case spec == "4x4" || spec == "4x4+luhn":
+		return AlphanumericCodes{Checked: spec == "4x4+luhn"}, nil
+	case strings.HasPrefix(spec, "sku:"):
+		digits := strings.TrimSuffix(strings.TrimPrefix(spec, "sku:"), "+luhn")
+		count, err := strconv.Atoi(digits)
+		if err != nil || count < minSKUDigits || count > maxSKUDigits {
+			return nil, fmt.Errorf("invalid SKU length %q, expected %d to %d digits", digits, minSKUDigits, maxSKUDigits)
+		}
+		return NumericCodes{Digits: count, Checked: strings.HasSuffix(spec, "+luhn")}, nil
+	}
+	return nil, fmt.Errorf("unknown produce code scheme %q", spec)
+}
+
+//checks that the code is four groups of four alphanumeric characters and, if Checked, ends in its check character
+func (scheme AlphanumericCodes) Valid(code string) bool {
+	match, _ := regexp.MatchString(`^[\d\w]{4}-[\d\w]{4}-[\d\w]{4}-[\d\w]{4}$`, code)
+	if !match || !scheme.Checked {
+		return match
+	}
+	chars := strings.ToUpper(strings.Replace(code, "-", "", -1))
+	return hasLuhnCheck(chars, alphanumericChars)
+}
+
+//returns a random code of four groups of four characters whose last character is the check character
+func (scheme AlphanumericCodes) Generate() string {
+	chars := withLuhnCheck(randomChars(15, alphanumericChars), alphanumericChars)
+	return chars[0:4] + "-" + chars[4:8] + "-" + chars[8:12] + "-" + chars[12:16]
+}
+
+//checks that the code is made of Digits digits and, if Checked, ends in its check digit
+func (scheme NumericCodes) Valid(code string) bool {
+	match, _ := regexp.MatchString(fmt.Sprintf(`^\d{%d}$`, scheme.Digits), code)
+	if !match || !scheme.Checked {
+		return match
+	}
+	return hasLuhnCheck(code, numericChars)
+}
+
+//returns a random SKU whose last digit is the check digit. The first digit is never 0 so the SKU keeps its length
+//when handled as a number.
+func (scheme NumericCodes) Generate() string {
+	first := randomChars(1, numericChars[1:])
+	return withLuhnCheck(first+randomChars(scheme.Digits-2, numericChars), numericChars)
+}
+
+//checks that the code matches the pattern, a scheme without a pattern accepts no code
+func (scheme PatternCodes) Valid(code string) bool {
+	return scheme.pattern != nil && scheme.pattern.MatchString(code)
+}
+
+//returns n characters drawn at random from chars
+func randomChars(n int, chars string) string {
+	code := make([]byte, n)
+	for index := range code {
+		pick, _ := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		code[index] = chars[pick.Int64()]
+	}
+	return string(code)
+}
+
+//returns the Luhn mod N check character of payload, N being the number of characters in chars. Starting from the
+//right, every other character is doubled and its digits summed in base N, the check character brings the total up to
+//a multiple of N. With the ten digits this is the Luhn check digit used on card numbers.
+func luhnCheck(payload string, chars string) byte {
+	base := len(chars)
+	sum := 0
+	for index := len(payload) - 1; index >= 0; index-- {
+		value := strings.IndexByte(chars, payload[index])
+		if (len(payload)-1-index)%2 == 0 {
+			value *= 2
+			value = value/base + value%base
+		}
+		sum += value
+	}
+	return chars[(base-sum%base)%base]
+}
+
+//returns the payload followed by its check character
+func withLuhnCheck(payload string, chars string) string {
+	return payload + string(luhnCheck(payload, chars))
+}
+
+//checks that the last character of code is the check character of the characters before it
+func hasLuhnCheck(code string, chars string) bool {
+	if code == "" {
+		return false
+	}
+	for index := range code {
+		if strings.IndexByte(chars, code[index]) < 0 {
+			return false
+		}
+	}
+	return luhnCheck(code[:len(code)-1], chars) == code[len(code)-1]
+}
+
+//returns a generated code that is neither used by an item nor by an item in the trash, so restoring a deleted item
+//can never clash with it. errCodesNotGenerated is returned if maxCodeAttempts codes in a row were taken, the codes of
+//the scheme are running out then. Callers must hold the write lock and store the code in the catalog they passed.
+func (c *catalog) unusedProduceCode(generator CodeGenerator) (string, error) {
+	for attempt := 0; attempt < maxCodeAttempts; attempt++ {
+		code := generator.Generate()
+		used := false
+		for _, item := range c.Data {
+			used = used || item.ProduceCode == code
+		}
+		for _, item := range c.Trash {
+			used = used || item.ProduceCode == code
+		}
+		if !used {
+			return code, nil
+		}
+	}
+	return "", errCodesNotGenerated
+}
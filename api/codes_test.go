@@ -0,0 +1,161 @@
+//tests for codes.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParseCodeScheme(t *testing.T) {
+	t.Parallel()
+	var schemeTests = []struct {
+		spec    string
+		code    string
+		valid   bool
+		invalid string
+	}{
+		{"4x4", "A12T-4GH7-QPL9-3N4M", true, ""},
+		{"4x4", "A12T-4GH7-QPL9", false, ""},
+		{"4x4+luhn", "A12T-4GH7-QPL9-3N4M", false, ""},
+		{"4x4+luhn", "0000-0000-0000-0000", true, ""},
+		{"sku:8", "12345678", true, ""},
+		{"sku:8", "1234567", false, ""},
+		{"sku:8+luhn", "12345678", false, ""},
+		{"sku:8+luhn", "12345674", true, ""},
+		{"sku:11+luhn", "79927398713", true, ""},
+		{"regex:^PRD-\\d{3}$", "PRD-123", true, ""},
+		{"regex:^PRD-\\d{3}$", "PRD-12", false, ""},
+		{"regex:PRD-\\d{3}", "PRD-123", true, ""},
+		{"regex:PRD-\\d{3}", "XPRD-1234", false, ""},
+		{"regex:A|B", "AB", false, ""},
+		{"regex:(", "", false, "invalid produce code pattern: error parsing regexp: missing closing ): `(`"},
+		{"sku:1", "", false, `invalid SKU length "1", expected 6 to 32 digits`},
+		{"sku:5", "", false, `invalid SKU length "5", expected 6 to 32 digits`},
+		{"sku:33", "", false, `invalid SKU length "33", expected 6 to 32 digits`},
+		{"sku:6", "123456", true, ""},
+		{"5x5", "", false, `unknown produce code scheme "5x5"`},
+	}
+
+	for _, test := range schemeTests {
+		scheme, err := ParseCodeScheme(test.spec)
+		if test.invalid != "" {
+			assert.EqualError(t, err, test.invalid, fmt.Sprintf("wrong error for %s", test.spec))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("scheme %s rejected", test.spec))
+		assert.Equal(t, test.valid, scheme.Valid(test.code), fmt.Sprintf("wrong result for %s in %s", test.code, test.spec))
+	}
+}
+
+//test that generated codes are valid in their own scheme and carry their check character
+func TestGenerateCodes(t *testing.T) {
+	t.Parallel()
+	for _, spec := range []string{"4x4", "sku:8"} {
+		scheme, _ := ParseCodeScheme(spec)
+		checked, _ := ParseCodeScheme(spec + "+luhn")
+		for i := 0; i < 100; i++ {
+			code := scheme.(CodeGenerator).Generate()
+			assert.True(t, checked.Valid(code), fmt.Sprintf("generated code %s invalid in %s+luhn", code, spec))
+		}
+	}
+	assert.Equal(t, "79927398713", withLuhnCheck("7992739871", numericChars), "wrong Luhn check digit")
+}
+
+func TestCreateGeneratedCode(t *testing.T) {
+	defer func(scheme CodeScheme) { ProduceCodes = scheme }(ProduceCodes)
+	pattern, _ := ParseCodeScheme(`regex:^PRD-\d{3}$`)
+
+	var generateTests = []struct {
+		desc       string
+		scheme     CodeScheme
+		statusCode int
+		valid      bool
+	}{
+		{"4x4 code", AlphanumericCodes{}, 201, true},
+		{"sku code", NumericCodes{Digits: 8}, 201, true},
+		{"scheme can not generate", pattern, 400, false},
+	}
+
+	for _, test := range generateTests {
+		f := newFixture(t, nil)
+		ProduceCodes = test.scheme
+		response := f.request("POST", "/api/produce", `{"name":"Cheese","unit_price":"$4.60"}`)
+		assert.Equal(t, test.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", test.desc))
+		if !test.valid {
+			assert.Equal(t, `{"validationError":{"produce_code":["produce field is required","invalid produce code format"]}}`,
+				response.Body, fmt.Sprintf("unexpected response for %s", test.desc))
+			continue
+		}
+
+		var pItem ProduceItem
+		response.decode(t, &pItem)
+		assert.True(t, test.scheme.Valid(pItem.ProduceCode), fmt.Sprintf("generated code %q invalid for %s", pItem.ProduceCode, test.desc))
+		assert.Len(t, f.db.catalog().Data, 5, fmt.Sprintf("item not stored for %s", test.desc))
+	}
+}
+
+//generator that returns its codes in order and repeats the last one once they run out
+type sequenceCodes struct {
+	NumericCodes
+	codes []string
+}
+
+func (s *sequenceCodes) Generate() string {
+	code := s.codes[0]
+	if len(s.codes) > 1 {
+		s.codes = s.codes[1:]
+	}
+	return code
+}
+
+//test that generated codes skip codes already in use or in the trash and that generating gives up once every code
+//it comes up with is taken
+func TestUnusedProduceCode(t *testing.T) {
+	t.Parallel()
+	current := &catalog{
+		Data:  []ProduceItem{{ProduceCode: "26"}},
+		Trash: []TrashedItem{{ProduceItem: ProduceItem{ProduceCode: "18"}}},
+	}
+
+	code, err := current.unusedProduceCode(&sequenceCodes{codes: []string{"26", "18", "34"}})
+	assert.Nil(t, err, "no code generated")
+	assert.Equal(t, "34", code, "code of stored or trashed item reused")
+
+	code, err = current.unusedProduceCode(&sequenceCodes{codes: []string{"18", "26"}})
+	assert.Equal(t, errCodesNotGenerated, err, "generating did not give up on used codes")
+	assert.Equal(t, "", code, "code returned after giving up")
+}
+
+//test that a scheme without unused codes left makes creating an item without a code fail instead of hanging
+func TestCreateCodesExhausted(t *testing.T) {
+	f := newFixture(t, nil)
+	f.db.codes = exhaustedCodes{}
+	f.request("POST", "/api/produce", `{"name":"Cheese","unit_price":"$4.60"}`).
+		assert(t, 409, "error 409 - no unused produce code could be generated\n", "exhausted scheme")
+	assert.Len(t, f.db.catalog().Data, 4, "item stored without a code")
+}
+
+//scheme that only ever generates the code of a seeded item
+type exhaustedCodes struct {
+	AlphanumericCodes
+}
+
+func (exhaustedCodes) Generate() string {
+	return "A12T-4GH7-QPL9-3N4M"
+}
+
+//test that items stored before the code scheme was switched can still be reached while unknown codes of the old
+//scheme are rejected
+func TestStoredCodesAfterSchemeSwitch(t *testing.T) {
+	f := newFixture(t, nil)
+	f.db.codes = NumericCodes{Digits: 8}
+	f.request("GET", "/api/produce/A12T-4GH7-QPL9-3N4M", "").assert(t, 200,
+		`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`, "seeded item")
+	f.request("DELETE", "/api/produce/E5T6-9UI3-TH15-QR88", "").assert(t, 200,
+		`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"}`, "delete seeded item")
+	f.request("POST", "/api/produce/trash/E5T6-9UI3-TH15-QR88/restore", "").assert(t, 200,
+		`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"}`, "restore trashed item")
+	f.request("GET", "/api/produce/ZZZZ-ZZZZ-ZZZZ-ZZZZ", "").assert(t, 400,
+		"error 400 - invalid produce code format\n", "code of old scheme")
+}
@@ -0,0 +1,98 @@
+//contains the change feed that records create/update/delete events from the model layer and fans them out to
+//streaming clients
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+//number of past events kept so reconnecting clients can catch up
+const feedHistory = 1000
+
+//number of events buffered per subscriber before it is considered too slow and dropped
+const subscriberBuffer = 64
+
+//type to store a single change to the catalog. ProduceCode is the code the change was made to, which differs from
+//Item.ProduceCode when an update renamed the item.
+type ChangeEvent struct {
+	Seq         uint64      `json:"seq"`
+	Type        string      `json:"type"`
+	ProduceCode string      `json:"produce_code"`
+	Item        ProduceItem `json:"item"`
+	Time        time.Time   `json:"time"`
+}
+
+//type to buffer recent events with increasing sequence numbers and deliver new ones to subscribers. The zero value is
+//ready to use.
+type changeFeed struct {
+	mu          sync.Mutex
+	seq         uint64
+	events      []ChangeEvent
+	subscribers map[chan ChangeEvent]struct{}
+}
+
+//records an event and delivers it to every subscriber. Model functions call this while holding the database write
+//lock so the sequence numbers follow the order the changes were made in. A subscriber whose buffer is full is dropped
+//by closing its channel, it can reconnect and catch up from the last sequence number it saw.
+func (feed *changeFeed) publish(eventType string, pCode string, pItem ProduceItem) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	feed.seq++
+	event := ChangeEvent{feed.seq, eventType, pCode, pItem, time.Now().UTC()}
+	feed.events = append(feed.events, event)
+	if len(feed.events) > feedHistory {
+		feed.events = append([]ChangeEvent(nil), feed.events[len(feed.events)-feedHistory:]...)
+	}
+
+	for subscriber := range feed.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(feed.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+//registers a new subscriber and returns the events after sequence number since along with a channel for the events
+//that follow. Both are taken under the same lock so no event is missed or repeated. complete is false when events after
+//since have already been dropped from the history or since is ahead of the feed, a cursor from before a restart for
+//example, the client then has to reload the catalog.
+func (feed *changeFeed) subscribe(since uint64) (backlog []ChangeEvent, events chan ChangeEvent, complete bool) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	complete = since == feed.seq || (since < feed.seq && len(feed.events) > 0 && feed.events[0].Seq <= since+1)
+	for _, event := range feed.events {
+		if event.Seq > since {
+			backlog = append(backlog, event)
+		}
+	}
+
+	events = make(chan ChangeEvent, subscriberBuffer)
+	if feed.subscribers == nil {
+		feed.subscribers = map[chan ChangeEvent]struct{}{}
+	}
+	feed.subscribers[events] = struct{}{}
+	return backlog, events, complete
+}
+
+//removes a subscriber. It is safe to call after the feed has already dropped it.
+func (feed *changeFeed) unsubscribe(events chan ChangeEvent) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+
+	if _, ok := feed.subscribers[events]; ok {
+		delete(feed.subscribers, events)
+		close(events)
+	}
+}
+
+//returns the sequence number of the latest event
+func (feed *changeFeed) lastSeq() uint64 {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	return feed.seq
+}
@@ -0,0 +1,212 @@
+//tests for feed.go and the streaming end points
+package api
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+//test that subscribers receive the backlog after their cursor and new events in order
+func TestChangeFeedSubscribe(t *testing.T) {
+	t.Parallel()
+	var feed changeFeed
+	for index := 0; index < 3; index++ {
+		feed.publish("create", "1111-1111-1111-1111", ProduceItem{})
+	}
+
+	backlog, events, complete := feed.subscribe(1)
+	defer feed.unsubscribe(events)
+	assert.True(t, complete, "backlog should be complete")
+	assert.Equal(t, 2, len(backlog), "unexpected backlog length")
+	assert.Equal(t, uint64(2), backlog[0].Seq, "backlog should start after cursor")
+
+	feed.publish("delete", "1111-1111-1111-1111", ProduceItem{})
+	event := <-events
+	assert.Equal(t, uint64(4), event.Seq, "unexpected sequence number")
+	assert.Equal(t, "delete", event.Type, "unexpected event type")
+}
+
+//test that a cursor older than the retained history is reported as incomplete
+func TestChangeFeedHistoryGap(t *testing.T) {
+	t.Parallel()
+	var feed changeFeed
+	for index := 0; index < feedHistory+5; index++ {
+		feed.publish("update", "1111-1111-1111-1111", ProduceItem{})
+	}
+
+	backlog, events, complete := feed.subscribe(2)
+	feed.unsubscribe(events)
+	assert.False(t, complete, "gap not detected")
+	assert.Equal(t, feedHistory, len(backlog), "history not capped")
+
+	_, events, complete = feed.subscribe(5)
+	feed.unsubscribe(events)
+	assert.True(t, complete, "cursor at edge of history reported as gap")
+
+	backlog, events, complete = feed.subscribe(feedHistory + 5)
+	feed.unsubscribe(events)
+	assert.True(t, complete, "cursor at latest event reported as gap")
+	assert.Empty(t, backlog, "backlog after latest event")
+}
+
+//test that a cursor ahead of the feed, as kept by a client across a restart, is reported as incomplete
+func TestChangeFeedCursorAhead(t *testing.T) {
+	t.Parallel()
+	var feed changeFeed
+	_, events, complete := feed.subscribe(0)
+	feed.unsubscribe(events)
+	assert.True(t, complete, "empty feed reported as gap")
+
+	_, events, complete = feed.subscribe(7)
+	feed.unsubscribe(events)
+	assert.False(t, complete, "cursor ahead of empty feed not reported as gap")
+
+	feed.publish("create", "1111-1111-1111-1111", ProduceItem{})
+	backlog, events, complete := feed.subscribe(7)
+	feed.unsubscribe(events)
+	assert.False(t, complete, "cursor ahead of feed not reported as gap")
+	assert.Empty(t, backlog, "backlog after cursor ahead of feed")
+}
+
+//test that a subscriber that stops reading is dropped instead of blocking publishers
+func TestChangeFeedSlowSubscriber(t *testing.T) {
+	t.Parallel()
+	var feed changeFeed
+	_, events, _ := feed.subscribe(0)
+	for index := 0; index < subscriberBuffer+1; index++ {
+		feed.publish("update", "1111-1111-1111-1111", ProduceItem{})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, subscriberBuffer, received, "slow subscriber not dropped")
+	feed.unsubscribe(events)
+}
+
+//test that the SSE end point replays events after the Last-Event-ID
+func TestHandleStreamEvents(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+	since := f.db.feed.lastSeq()
+	f.db.createProduceItem(context.Background(), ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"})
+
+	request, _ := http.NewRequest("GET", f.server.URL+"/api/produce/events", nil)
+	request.Header.Set("Last-Event-ID", fmt.Sprint(since))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"), "unexpected content type")
+	reader := bufio.NewReader(response.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	assert.Equal(t, fmt.Sprintf("id: %d", since+1), lines[0], "unexpected event id")
+	assert.Equal(t, "event: create", lines[1], "unexpected event name")
+	assert.True(t, strings.Contains(lines[2], `"produce_code":"1111-1111-1111-1111"`), "unexpected event data")
+
+	assert.Equal(t, 400, f.request("GET", "/api/produce/events?since=abc", "").StatusCode, "invalid cursor accepted")
+}
+
+//test that the WebSocket end point completes the handshake and pushes new events
+func TestHandleWebsocketEvents(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+	conn, err := net.Dial("tcp", f.server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	fmt.Fprintf(conn, "GET /api/produce/events/ws HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\n"+
+		"Connection: Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", key)
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 101, response.StatusCode, "upgrade refused")
+
+	//the subscription is registered after the handshake, keep publishing until the frame arrives
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(10 * time.Millisecond):
+				f.db.updateProduceItem(context.Background(), "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.00"})
+			}
+		}
+	}()
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, byte(0x81), header[0], "expected final text frame")
+	payload := make([]byte, header[1]&0x7F)
+	if header[1]&0x7F == 126 {
+		extended := make([]byte, 2)
+		io.ReadFull(reader, extended)
+		payload = make([]byte, int(extended[0])<<8|int(extended[1]))
+	}
+	io.ReadFull(reader, payload)
+
+	var event ChangeEvent
+	assert.Nil(t, json.Unmarshal(payload, &event), "frame is not a change event")
+	assert.Equal(t, "update", event.Type, "unexpected event type")
+	assert.Equal(t, "$3.00", event.Item.UnitPrice, "unexpected event item")
+}
+
+//test that a frame the client does not take in time fails and closes the connection instead of blocking the writer.
+//Changes websocketWriteTimeout so it does not run in parallel.
+func TestWebsocketWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { websocketWriteTimeout = timeout }(websocketWriteTimeout)
+	websocketWriteTimeout = 20 * time.Millisecond
+
+	server, client := net.Pipe()
+	defer client.Close()
+	ws := &wsConn{conn: server, rw: bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server))}
+
+	written := make(chan error, 1)
+	go func() { written <- ws.writeFrame(opText, []byte("event")) }()
+	select {
+	case err := <-written:
+		assert.Error(t, err, "write to a client that does not read succeeded")
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked past its deadline")
+	}
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err, "connection left open")
+}
@@ -0,0 +1,115 @@
+//test fixtures that give every test a server and database of its own so tests can run in parallel
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+//type to hold a test server that serves requests without a tenant from db. Tests using only fixtures may call
+//t.Parallel(), tests that change package variables such as ProduceCodes or the tenants must not.
+type testFixture struct {
+	t      *testing.T
+	db     *DBObject
+	server *httptest.Server
+}
+
+//type to store a response read by a fixture
+type testResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       string
+}
+
+//returns the items most tests start from
+func seedItems() []ProduceItem {
+	return []ProduceItem{
+		{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", UnitPrice: "$3.46"},
+		{ProduceCode: "E5T6-9UI3-TH15-QR88", Name: "Peach", UnitPrice: "$2.99"},
+		{ProduceCode: "YRT6-72AS-K736-L4AR", Name: "Green Pepper", UnitPrice: "$0.79"},
+		{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59"},
+	}
+}
+
+//returns a new database holding the seed items, seedItems when seed is nil
+func newTestDB(seed []ProduceItem) *DBObject {
+	if seed == nil {
+		seed = seedItems()
+	}
+	db := &DBObject{}
+	db.storeCatalog(&catalog{Data: append([]ProduceItem{}, seed...)})
+	return db
+}
+
+//starts a server on a new database holding the seed items, seedItems when seed is nil. The server is closed when the
+//test ends. The rest of the database, e.g. categories or promotions, can be set up through db before requests are made.
+func newFixture(t *testing.T, seed []ProduceItem) *testFixture {
+	db := newTestDB(seed)
+	server := httptest.NewServer(handlersFor(db))
+	t.Cleanup(server.Close)
+	return &testFixture{t: t, db: db, server: server}
+}
+
+//makes a request to the path of the fixture server and reads the response. headers are pairs of names and values.
+func (f *testFixture) request(method string, path string, body string, headers ...string) testResponse {
+	request, err := http.NewRequest(method, f.server.URL+path, strings.NewReader(body))
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	for index := 0; index+1 < len(headers); index += 2 {
+		request.Header.Set(headers[index], headers[index+1])
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	responseData, _ := ioutil.ReadAll(response.Body)
+	return testResponse{StatusCode: response.StatusCode, Header: response.Header, Body: string(responseData)}
+}
+
+//asserts the status code and body of the response, desc names the case in failure messages
+func (response testResponse) assert(t *testing.T, statusCode int, expectedBody string, desc string) {
+	t.Helper()
+	assert.Equal(t, expectedBody, response.Body, fmt.Sprintf("unexpected response for %s", desc))
+	assert.Equal(t, statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", desc))
+}
+
+//asserts the status code of the response and that its body is JSON equal to expectedJSON, ignoring key order and
+//white space
+func (response testResponse) assertJSON(t *testing.T, statusCode int, expectedJSON string, desc string) {
+	t.Helper()
+	assert.JSONEq(t, expectedJSON, response.Body, fmt.Sprintf("unexpected response for %s", desc))
+	assert.Equal(t, statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s", desc))
+}
+
+//decodes the JSON body of the response into value
+func (response testResponse) decode(t *testing.T, value interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(response.Body), value); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", response.Body, err)
+	}
+}
+
+//test that fixtures do not share data with each other
+func TestFixturesAreIsolated(t *testing.T) {
+	t.Parallel()
+	first := newFixture(t, nil)
+	second := newFixture(t, []ProduceItem{})
+
+	first.request("DELETE", "/api/produce/A12T-4GH7-QPL9-3N4M", "").assert(t, 200,
+		`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46"}`, "delete from first fixture")
+	second.request("GET", "/api/produce", "").assert(t, 200, "[]", "second fixture starts empty")
+	second.request("POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$1.99"}`).assert(t, 201,
+		`{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$1.99"}`, "create in second fixture")
+
+	assert.Len(t, first.db.catalog().Data, 3, "first fixture changed by the second")
+	assert.Len(t, second.db.catalog().Data, 1, "second fixture changed by the first")
+}
@@ -0,0 +1,242 @@
+//contains the CSV, XML and MessagePack representations of the produce listing, chosen through the Accept header
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//media types the produce listing can be sent as, JSON is sent when the Accept header prefers none of them
+var listingFormats = []string{"application/json", "text/csv", "application/xml", "text/xml", "application/msgpack",
+	"application/x-msgpack", "application/vnd.msgpack"}
+
+//columns of the CSV representation of the produce listing
+var listingColumns = []string{"produce_code", "name", "unit_price", "price_unit", "tax_category", "category", "barcodes",
+	"on_hand", "stock_unit", "allow_backorder", "deleted_at"}
+
+//type to store a row of the produce listing, DeletedAt is only set for items listed from the trash. XMLBarcodes holds
+//the barcodes of the XML representation, encoding/xml would write an empty barcodes element for an item without any.
+type listedItem struct {
+	ProduceItem
+	XMLBarcodes *xmlBarcodes `json:"-" xml:"barcodes,omitempty"`
+	DeletedAt   *time.Time   `json:"deleted_at,omitempty" xml:"deleted_at,omitempty"`
+}
+
+//type of the barcodes element of an item in the XML representation of the produce listing
+type xmlBarcodes struct {
+	Barcodes []string `xml:"barcode"`
+}
+
+//type of the root element of the XML representation of the produce listing
+type xmlListing struct {
+	XMLName xml.Name     `xml:"produce"`
+	Items   []listedItem `xml:"item"`
+}
+
+//returns the rows of a listing of the given items followed by the given trashed items
+func listedItems(pItems []ProduceItem, trash []TrashedItem) []listedItem {
+	listing := make([]listedItem, 0, len(pItems)+len(trash))
+	for _, pItem := range pItems {
+		listing = append(listing, newListedItem(pItem))
+	}
+	for _, item := range trash {
+		deletedAt := item.DeletedAt
+		listed := newListedItem(item.ProduceItem)
+		listed.DeletedAt = &deletedAt
+		listing = append(listing, listed)
+	}
+	return listing
+}
+
+//returns the row of the produce listing of the item
+func newListedItem(pItem ProduceItem) listedItem {
+	listed := listedItem{ProduceItem: pItem}
+	if len(pItem.Barcodes) > 0 {
+		listed.XMLBarcodes = &xmlBarcodes{Barcodes: pItem.Barcodes}
+	}
+	return listed
+}
+
+//writes the listing in the given format, one of listingFormats other than application/json, with a 200 status code
+func listingResponse(w http.ResponseWriter, format string, listing []listedItem) {
+	var body bytes.Buffer
+	contentType := format
+	switch format {
+	case "text/csv":
+		writeListingCSV(&body, listing)
+		contentType = "text/csv; charset=utf-8"
+	case "application/xml", "text/xml":
+		body.WriteString(xml.Header)
+		if err := xml.NewEncoder(&body).Encode(xmlListing{Items: listing}); err != nil {
+			panic(err)
+		}
+		contentType = format + "; charset=utf-8"
+	default:
+		writeMsgpack(&body, listing)
+	}
+	writeResponse(w, http.StatusOK, contentType, body.Bytes())
+}
+
+//writes the listing as CSV with a header row of listingColumns. Barcodes are separated by spaces and the columns of a
+//missing stock level or deletion time are left empty.
+func writeListingCSV(out io.Writer, listing []listedItem) {
+	writer := csv.NewWriter(out)
+	writer.Write(listingColumns)
+	for _, item := range listing {
+		var onHand, stockUnit, allowBackorder, deletedAt string
+		if item.Stock != nil {
+			onHand = strconv.FormatFloat(item.Stock.OnHand, 'f', -1, 64)
+			stockUnit = item.Stock.Unit
+			allowBackorder = strconv.FormatBool(item.Stock.AllowBackorder)
+		}
+		if item.DeletedAt != nil {
+			deletedAt = item.DeletedAt.Format(time.RFC3339Nano)
+		}
+		writer.Write([]string{item.ProduceCode, item.Name, item.UnitPrice, item.PriceUnit, item.TaxCategory,
+			item.Category, strings.Join(item.Barcodes, " "), onHand, stockUnit, allowBackorder, deletedAt})
+	}
+	writer.Flush()
+}
+
+//type to store a decoded JSON object with its fields in the order they were written
+type msgpackField struct {
+	key   string
+	value interface{}
+}
+
+//writes the value as MessagePack. The value is first encoded as JSON, so the MessagePack document has the same
+//fields in the same order as the JSON representation. Whole numbers are written as integers and every other number as
+//a 64 bit float.
+func writeMsgpack(out *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	tree, err := decodeJSONTree(decoder)
+	if err != nil {
+		panic(err)
+	}
+	encodeMsgpack(out, tree)
+}
+
+//decodes the next JSON value of the decoder into a json.Number, string, bool, nil, []interface{} or []msgpackField
+func decodeJSONTree(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('['):
+		values := []interface{}{}
+		for decoder.More() {
+			value, err := decodeJSONTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		_, err = decoder.Token() //closing bracket
+		return values, err
+	case json.Delim('{'):
+		fields := []msgpackField{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeJSONTree(decoder)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, msgpackField{key: key.(string), value: value})
+		}
+		_, err = decoder.Token() //closing brace
+		return fields, err
+	}
+	return token, nil
+}
+
+//writes a value decoded by decodeJSONTree as MessagePack, using the shortest encoding of each value
+func encodeMsgpack(out *bytes.Buffer, value interface{}) {
+	switch value := value.(type) {
+	case nil:
+		out.WriteByte(0xc0)
+	case bool:
+		if value {
+			out.WriteByte(0xc3)
+		} else {
+			out.WriteByte(0xc2)
+		}
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			encodeMsgpackInt(out, integer)
+			return
+		}
+		float, _ := value.Float64()
+		out.WriteByte(0xcb)
+		binary.Write(out, binary.BigEndian, math.Float64bits(float))
+	case string:
+		encodeMsgpackHeader(out, len(value), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		out.WriteString(value)
+	case []interface{}:
+		encodeMsgpackHeader(out, len(value), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, element := range value {
+			encodeMsgpack(out, element)
+		}
+	case []msgpackField:
+		encodeMsgpackHeader(out, len(value), 0x80, 15, 0, 0xde, 0xdf)
+		for _, field := range value {
+			encodeMsgpack(out, field.key)
+			encodeMsgpack(out, field.value)
+		}
+	}
+}
+
+//writes the header of a string, array or map of the given length. Lengths up to fixMax are stored in the fix byte, the
+//others after the 8, 16 or 32 bit marker. Arrays and maps have no 8 bit form, their marker8 is 0.
+func encodeMsgpackHeader(out *bytes.Buffer, length int, fix byte, fixMax int, marker8 byte, marker16 byte, marker32 byte) {
+	switch {
+	case length <= fixMax:
+		out.WriteByte(fix | byte(length))
+	case marker8 != 0 && length <= math.MaxUint8:
+		out.Write([]byte{marker8, byte(length)})
+	case length <= math.MaxUint16:
+		out.WriteByte(marker16)
+		binary.Write(out, binary.BigEndian, uint16(length))
+	default:
+		out.WriteByte(marker32)
+		binary.Write(out, binary.BigEndian, uint32(length))
+	}
+}
+
+//writes an integer in the shortest MessagePack form that holds it
+func encodeMsgpackInt(out *bytes.Buffer, integer int64) {
+	switch {
+	case integer >= 0 && integer <= math.MaxInt8:
+		out.WriteByte(byte(integer))
+	case integer < 0 && integer >= -32:
+		out.WriteByte(byte(int8(integer)))
+	case integer >= math.MinInt8 && integer <= math.MaxInt8:
+		out.Write([]byte{0xd0, byte(int8(integer))})
+	case integer >= math.MinInt16 && integer <= math.MaxInt16:
+		out.WriteByte(0xd1)
+		binary.Write(out, binary.BigEndian, int16(integer))
+	case integer >= math.MinInt32 && integer <= math.MaxInt32:
+		out.WriteByte(0xd2)
+		binary.Write(out, binary.BigEndian, int32(integer))
+	default:
+		out.WriteByte(0xd3)
+		binary.Write(out, binary.BigEndian, integer)
+	}
+}
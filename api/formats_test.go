@@ -0,0 +1,144 @@
+//tests for formats.go
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+//returns the items the format tests list, the first is stocked with barcodes and a category
+func formatItems() []ProduceItem {
+	return []ProduceItem{
+		{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Lettuce", UnitPrice: "$3.46", Category: "greens",
+			Barcodes: []string{"4061", "0033383"}, Stock: &StockLevel{OnHand: 2.5, Unit: "kg"}},
+		{ProduceCode: "E5T6-9UI3-TH15-QR88", Name: "Peach", UnitPrice: "$2.99"},
+	}
+}
+
+//test that the listing is sent in the format the Accept header prefers
+func TestListingFormats(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, formatItems())
+	csvBody := "produce_code,name,unit_price,price_unit,tax_category,category,barcodes,on_hand,stock_unit,allow_backorder,deleted_at\n" +
+		"A12T-4GH7-QPL9-3N4M,Lettuce,$3.46,,,greens,4061 0033383,2.5,kg,false,\n" +
+		"E5T6-9UI3-TH15-QR88,Peach,$2.99,,,,,,,,\n"
+	xmlBody := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<produce>` +
+		`<item><produce_code>A12T-4GH7-QPL9-3N4M</produce_code><name>Lettuce</name><unit_price>$3.46</unit_price>` +
+		`<category>greens</category><stock><on_hand>2.5</on_hand><unit>kg</unit><allow_backorder>false</allow_backorder></stock>` +
+		`<barcodes><barcode>4061</barcode><barcode>0033383</barcode></barcodes></item>` +
+		`<item><produce_code>E5T6-9UI3-TH15-QR88</produce_code><name>Peach</name><unit_price>$2.99</unit_price></item>` +
+		`</produce>`
+	jsonBody := `[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","category":"greens",` +
+		`"barcodes":["4061","0033383"],"stock":{"on_hand":2.5,"unit":"kg","allow_backorder":false}},` +
+		`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"}]`
+
+	tests := []struct {
+		accept       string
+		expectedCode int
+		contentType  string
+		expectedBody string
+	}{
+		{"", 200, "application/json", jsonBody},
+		{"application/json", 200, "application/json", jsonBody},
+		{"text/csv", 200, "text/csv; charset=utf-8", csvBody},
+		{"application/xml", 200, "application/xml; charset=utf-8", xmlBody},
+		{"text/xml", 200, "text/xml; charset=utf-8", xmlBody},
+		{"text/*", 200, "text/csv; charset=utf-8", csvBody},
+		{"application/json;q=0.5, text/csv;q=0.9", 200, "text/csv; charset=utf-8", csvBody},
+		{"text/html, */*;q=0.1", 200, "application/json", jsonBody},
+		{"application/x-msgpack", 200, "application/x-msgpack", ""},
+		{"text/html", 406, "text/plain; charset=utf-8", "error 406 - produce can only be listed as " +
+			"application/json, text/csv, application/xml, text/xml, application/msgpack, application/x-msgpack, " +
+			"application/vnd.msgpack\n"},
+	}
+
+	for _, test := range tests {
+		desc := fmt.Sprintf("Accept %q", test.accept)
+		response := f.request("GET", "/api/produce", "", "Accept", test.accept)
+		assert.Equal(t, test.contentType, response.Header.Get("Content-Type"), fmt.Sprintf("unexpected type for %s", desc))
+		assert.Contains(t, response.Header.Values("Vary"), "Accept", fmt.Sprintf("missing Vary for %s", desc))
+		if test.contentType == "application/x-msgpack" {
+			var expected bytes.Buffer
+			writeMsgpack(&expected, listedItems(formatItems(), nil))
+			test.expectedBody = expected.String()
+		}
+		response.assert(t, test.expectedCode, test.expectedBody, desc)
+	}
+}
+
+//test that trashed items are listed with their deletion time in every format
+func TestListingFormatsIncludeDeleted(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, formatItems())
+	_, err := f.db.deleteProduceItem(context.Background(), "E5T6-9UI3-TH15-QR88")
+	assert.NoError(t, err, "delete failed")
+	deletedAt := f.db.catalog().Trash[0].DeletedAt.Format(time.RFC3339Nano)
+
+	response := f.request("GET", "/api/produce?include_deleted=true", "", "Accept", "text/csv")
+	lines := strings.Split(response.Body, "\n")
+	assert.Len(t, lines, 4, "unexpected number of lines")
+	assert.Equal(t, "E5T6-9UI3-TH15-QR88,Peach,$2.99,,,,,,,,"+deletedAt, lines[2], "unexpected trashed row")
+
+	response = f.request("GET", "/api/produce?include_deleted=true", "", "Accept", "application/xml")
+	assert.Contains(t, response.Body, "<deleted_at>"+deletedAt+"</deleted_at></item></produce>", "missing deletion time")
+	assert.Equal(t, 1, strings.Count(response.Body, "<deleted_at>"), "deletion time on items that are not deleted")
+}
+
+//test that MessagePack values are written in their shortest encoding
+func TestEncodeMsgpack(t *testing.T) {
+	t.Parallel()
+	nils := []interface{}{}
+	for index := 0; index < 16; index++ {
+		nils = append(nils, nil)
+	}
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, "\xc0"},
+		{true, "\xc3"},
+		{false, "\xc2"},
+		{json.Number("1"), "\x01"},
+		{json.Number("127"), "\x7f"},
+		{json.Number("-1"), "\xff"},
+		{json.Number("-32"), "\xe0"},
+		{json.Number("-33"), "\xd0\xdf"},
+		{json.Number("200"), "\xd1\x00\xc8"},
+		{json.Number("-200"), "\xd1\xff\x38"},
+		{json.Number("70000"), "\xd2\x00\x01\x11\x70"},
+		{json.Number("5000000000"), "\xd3\x00\x00\x00\x01\x2a\x05\xf2\x00"},
+		{json.Number("2.5"), "\xcb\x40\x04\x00\x00\x00\x00\x00\x00"},
+		{"", "\xa0"},
+		{"kg", "\xa2kg"},
+		{strings.Repeat("a", 40), "\xd9\x28" + strings.Repeat("a", 40)},
+		{strings.Repeat("a", 300), "\xda\x01\x2c" + strings.Repeat("a", 300)},
+		{[]interface{}{}, "\x90"},
+		{nils, "\xdc\x00\x10" + strings.Repeat("\xc0", 16)},
+		{[]msgpackField{{"b", json.Number("1")}, {"a", nil}}, "\x82\xa1b\x01\xa1a\xc0"},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		encodeMsgpack(&out, test.value)
+		assert.Equal(t, []byte(test.expected), out.Bytes(), fmt.Sprintf("unexpected encoding of %v", test.value))
+	}
+}
+
+//test that a listing is written as MessagePack with the fields of its JSON representation in the same order
+func TestWriteMsgpackListing(t *testing.T) {
+	t.Parallel()
+	listing := listedItems([]ProduceItem{{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Peach", UnitPrice: "$2.99",
+		Stock: &StockLevel{OnHand: 2.5, Unit: "kg"}}}, nil)
+	expected := "\x91\x84" + "\xacproduce_code\xb3A12T-4GH7-QPL9-3N4M" + "\xa4name\xa5Peach" + "\xaaunit_price\xa5$2.99" +
+		"\xa5stock\x83" + "\xa7on_hand\xcb\x40\x04\x00\x00\x00\x00\x00\x00" + "\xa4unit\xa2kg" + "\xafallow_backorder\xc2"
+
+	var out bytes.Buffer
+	writeMsgpack(&out, listing)
+	assert.Equal(t, []byte(expected), out.Bytes(), "unexpected MessagePack listing")
+}
@@ -3,13 +3,80 @@ package api
 
 import "github.com/gorilla/mux"
 
-//creates new router and sets end point function triggers
+//creates new router and sets end point function triggers. Every request is served from the database of its tenant,
+//which is named by the X-Tenant-ID header or, when TenantDomain is set, by the subdomain. Requests for a tenant must
+//carry its api key as a bearer token, admin writes such as replacing validation rules its admin key.
 func Handlers() *mux.Router {
+	return handlersFor(nil)
+}
+
+//creates a router like Handlers that serves requests which name no tenant from db instead of the default database,
+//so a test can run a server on a database of its own. db nil means the default database.
+func handlersFor(db *DBObject) *mux.Router {
 	router := mux.NewRouter()
+	router.Use(compressResponses)
+	if TenantDomain != "" {
+		registerRoutes(router.Host("{tenant}." + TenantDomain).Subrouter())
+	}
+	registerRoutes(router)
+	if db != nil {
+		router.Use(withDatabase(db))
+	}
+	router.Use(resolveTenant)
+	return router
+}
+
+//sets the end point function triggers on the given router
+func registerRoutes(router *mux.Router) {
+	router.HandleFunc("/openapi.json", handleGetOpenAPI).Methods("GET")
 	router.HandleFunc("/api/produce", handleGetAllProduce).Methods("GET")
+	router.HandleFunc("/api/produce/trash", handleGetTrash).Methods("GET")
+	router.HandleFunc("/api/produce/events", handleStreamEvents).Methods("GET")
+	router.HandleFunc("/api/produce/events/ws", handleWebsocketEvents).Methods("GET")
+	router.HandleFunc("/api/produce/trash/{produce_code}/restore", handleRestoreProduceItem).Methods("POST")
+	router.HandleFunc("/api/produce/trash/{produce_code}", handlePurgeTrashedItem).Methods("DELETE")
 	router.HandleFunc("/api/produce/{produce_code}", handleGetProduceItem).Methods("GET")
 	router.HandleFunc("/api/produce/{produce_code}", handleUpdateProduceItem).Methods("POST")
+	router.HandleFunc("/api/produce/{produce_code}", handleReplaceProduceItem).Methods("PUT")
+	router.HandleFunc("/api/produce/{produce_code}", handlePatchProduceItem).Methods("PATCH")
 	router.HandleFunc("/api/produce", handleCreateProduceItem).Methods("POST")
 	router.HandleFunc("/api/produce/{produce_code}", handleDeleteProduceItem).Methods("DELETE")
-	return router
+	router.HandleFunc("/api/produce/{produce_code}/price", handleGetPrice).Methods("GET")
+	router.HandleFunc("/api/produce/{produce_code}/inventory", handleGetStock).Methods("GET")
+	router.HandleFunc("/api/produce/{produce_code}/inventory", handleSetStock).Methods("PUT")
+	router.HandleFunc("/api/produce/{produce_code}/inventory/receive", handleReceiveStock).Methods("POST")
+	router.HandleFunc("/api/produce/{produce_code}/inventory/adjust", handleAdjustStock).Methods("POST")
+	router.HandleFunc("/api/produce/{produce_code}/inventory/sale", handleSellStock).Methods("POST")
+	router.HandleFunc("/api/barcodes/{barcode}", handleLookupBarcode).Methods("GET")
+	router.HandleFunc("/api/cart", handlePriceCart).Methods("POST")
+	router.HandleFunc("/api/tax", handleGetTaxTable).Methods("GET")
+	router.HandleFunc("/api/rules", handleGetRules).Methods("GET")
+	router.HandleFunc("/api/rules", requireAdmin(handleReplaceRules)).Methods("PUT")
+	router.HandleFunc("/api/promotions", handleGetPromotions).Methods("GET")
+	router.HandleFunc("/api/promotions", handleCreatePromotion).Methods("POST")
+	router.HandleFunc("/api/promotions/{id}", handleGetPromotion).Methods("GET")
+	router.HandleFunc("/api/promotions/{id}", handleReplacePromotion).Methods("PUT")
+	router.HandleFunc("/api/promotions/{id}", handleDeletePromotion).Methods("DELETE")
+	router.HandleFunc("/api/categories", handleGetCategories).Methods("GET")
+	router.HandleFunc("/api/categories", handleCreateCategory).Methods("POST")
+	router.HandleFunc("/api/categories/{category_id}", handleGetCategory).Methods("GET")
+	router.HandleFunc("/api/categories/{category_id}", handleReplaceCategory).Methods("PUT")
+	router.HandleFunc("/api/categories/{category_id}", handleDeleteCategory).Methods("DELETE")
+	router.HandleFunc("/api/stores", handleGetStores).Methods("GET")
+	router.HandleFunc("/api/stores", handleCreateStore).Methods("POST")
+	router.HandleFunc("/api/stores/{store_id}", handleGetStore).Methods("GET")
+	router.HandleFunc("/api/stores/{store_id}", handleDeleteStore).Methods("DELETE")
+	router.HandleFunc("/api/stores/{store_id}/produce", handleGetStoreProduce).Methods("GET")
+	router.HandleFunc("/api/stores/{store_id}/cart", handlePriceCart).Methods("POST")
+	router.HandleFunc("/api/stores/{store_id}/rules", handleGetStoreRules).Methods("GET")
+	router.HandleFunc("/api/stores/{store_id}/rules", requireAdmin(handleReplaceStoreRules)).Methods("PUT")
+	router.HandleFunc("/api/stores/{store_id}/produce/{produce_code}", handleGetStoreProduceItem).Methods("GET")
+	router.HandleFunc("/api/stores/{store_id}/produce/{produce_code}", handleSetStoreOverride).Methods("PUT")
+	router.HandleFunc("/api/stores/{store_id}/produce/{produce_code}", handleDeleteStoreOverride).Methods("DELETE")
+	router.HandleFunc("/api/webhooks", handleGetWebhooks).Methods("GET")
+	router.HandleFunc("/api/webhooks", handleCreateWebhook).Methods("POST")
+	router.HandleFunc("/api/webhooks/deadletters", handleGetDeadLetters).Methods("GET")
+	router.HandleFunc("/api/webhooks/deadletters/{id}/retry", handleRetryDeadLetter).Methods("POST")
+	router.HandleFunc("/api/webhooks/{id}", handleGetWebhook).Methods("GET")
+	router.HandleFunc("/api/webhooks/{id}", handleDeleteWebhook).Methods("DELETE")
 }
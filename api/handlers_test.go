@@ -0,0 +1,205 @@
+//benchmarks of the handlers registered in handlers.go
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//number of items in the catalog the handlers are benchmarked against
+const benchmarkCatalogSize = 1000
+
+//type to describe a request benchmarked against one handler. setup runs once before the timer starts, undo after
+//every request with the timer stopped so every iteration finds the database in the same state.
+type handlerBenchmark struct {
+	name        string
+	method      string
+	path        string
+	contentType string
+	body        string
+	statusCode  int
+	setup       func(db *DBObject)
+	undo        func(db *DBObject)
+}
+
+//returns the code of the benchmark catalog item at index
+func benchmarkCode(index int) string {
+	return fmt.Sprintf("%04X-BNCH-0000-0000", index)
+}
+
+//returns a database holding size items. The first item is stocked, has a barcode and a category, is on promotion
+//and has its price overridden by a store. A webhook subscription is registered without starting delivery. Requests
+//are made with its admin key bench-admin so admin writes can be measured too.
+func benchmarkDB(size int) *DBObject {
+	data := []ProduceItem{}
+	for index := 0; index < size; index++ {
+		code := benchmarkCode(index)
+		data = append(data, ProduceItem{ProduceCode: code, Name: "Item " + code[:4] + " " + code[:4], UnitPrice: "$1.99"})
+	}
+	first := benchmarkCode(0)
+	data[0].Barcodes = []string{"4011"}
+	data[0].Category = "fruit"
+	db := &DBObject{adminKey: "bench-admin"}
+	db.storeCatalog(&catalog{Data: data})
+	db.addCategory(Category{ID: "fruit", Name: "Fruit"})
+	db.addCategory(Category{ID: "dairy", Name: "Dairy"})
+
+	db.setStockLevel(context.Background(), first, StockLevel{OnHand: 1e9, Unit: "each"})
+
+	price := "$1.49"
+	db.addStore(Store{ID: "columbus-1", Name: "Columbus"})
+	db.setStoreOverride(context.Background(), "columbus-1", StoreOverride{ProduceCode: first, UnitPrice: &price})
+	db.promotions.promotions = []Promotion{{ID: "bench-promo", Name: "Special", Type: promoPercentOff, Percent: "10", ProduceCodes: []string{first}}}
+	db.webhooks.subscriptions = []WebhookSubscription{{ID: "bench-hook", URL: "http://127.0.0.1:1/hook", Events: []string{"update"}}}
+	db.webhooks.queues = map[string]chan ChangeEvent{"bench-hook": make(chan ChangeEvent, webhookQueueSize)}
+	return db
+}
+
+//moves the item of the given code into the trash
+func trashBenchmarkItem(db *DBObject, pCode string) {
+	db.deleteProduceItem(context.Background(), pCode)
+}
+
+//the requests benchmarked, one for every handler except the event streams which never answer and the dead letter
+//retry which needs a failed delivery
+var handlerBenchmarks = []handlerBenchmark{
+	{name: "GetOpenAPI", method: "GET", path: "/openapi.json", statusCode: 200},
+	{name: "GetAllProduce", method: "GET", path: "/api/produce", statusCode: 200},
+	{name: "GetProduceItem", method: "GET", path: "/api/produce/" + benchmarkCode(benchmarkCatalogSize/2), statusCode: 200},
+	{name: "CreateProduceItem", method: "POST", path: "/api/produce", statusCode: 201,
+		body: `{"produce_code":"FFFF-BNCH-0000-0000","name":"Benchmark Kale","unit_price":"$2.49"}`,
+		undo: func(db *DBObject) {
+			current := db.catalog()
+			db.storeCatalog(current.withoutItem(len(current.Data) - 1))
+		}},
+	{name: "UpdateProduceItem", method: "POST", path: "/api/produce/" + benchmarkCode(1), statusCode: 200,
+		body: `{"produce_code":"` + benchmarkCode(1) + `","name":"Item 0001 0001","unit_price":"$2.49"}`},
+	{name: "ReplaceProduceItem", method: "PUT", path: "/api/produce/" + benchmarkCode(1), statusCode: 200,
+		body: `{"name":"Item 0001 0001","unit_price":"$2.49"}`},
+	{name: "PatchProduceItem", method: "PATCH", path: "/api/produce/" + benchmarkCode(1), statusCode: 200,
+		contentType: mergePatchType, body: `{"unit_price":"$2.49"}`},
+	{name: "DeleteProduceItem", method: "DELETE", path: "/api/produce/" + benchmarkCode(1), statusCode: 200,
+		undo: func(db *DBObject) { db.restoreProduceItem(context.Background(), benchmarkCode(1)) }},
+	{name: "GetTrash", method: "GET", path: "/api/produce/trash", statusCode: 200,
+		setup: func(db *DBObject) { trashBenchmarkItem(db, benchmarkCode(1)) }},
+	{name: "RestoreProduceItem", method: "POST", path: "/api/produce/trash/" + benchmarkCode(1) + "/restore", statusCode: 200,
+		setup: func(db *DBObject) { trashBenchmarkItem(db, benchmarkCode(1)) },
+		undo:  func(db *DBObject) { trashBenchmarkItem(db, benchmarkCode(1)) }},
+	{name: "PurgeTrashedItem", method: "DELETE", path: "/api/produce/trash/" + benchmarkCode(1), statusCode: 200,
+		setup: func(db *DBObject) { trashBenchmarkItem(db, benchmarkCode(1)) },
+		undo: func(db *DBObject) {
+			current := db.catalog()
+			trashed := TrashedItem{ProduceItem{ProduceCode: benchmarkCode(1), Name: "Item 0001 0001", UnitPrice: "$1.99"}, time.Now()}
+			db.storeCatalog(current.withTrash(append(current.Trash[:len(current.Trash):len(current.Trash)], trashed)))
+		}},
+	{name: "GetPrice", method: "GET", path: "/api/produce/" + benchmarkCode(0) + "/price?quantity=3", statusCode: 200},
+	{name: "GetStock", method: "GET", path: "/api/produce/" + benchmarkCode(0) + "/inventory", statusCode: 200},
+	{name: "SetStock", method: "PUT", path: "/api/produce/" + benchmarkCode(0) + "/inventory", statusCode: 200,
+		body: `{"on_hand":1000000000,"unit":"each"}`},
+	{name: "ReceiveStock", method: "POST", path: "/api/produce/" + benchmarkCode(0) + "/inventory/receive", statusCode: 200,
+		body: `{"quantity":1}`},
+	{name: "AdjustStock", method: "POST", path: "/api/produce/" + benchmarkCode(0) + "/inventory/adjust", statusCode: 200,
+		body: `{"quantity":-1}`},
+	{name: "SellStock", method: "POST", path: "/api/produce/" + benchmarkCode(0) + "/inventory/sale", statusCode: 200,
+		body: `{"quantity":1}`},
+	{name: "LookupBarcode", method: "GET", path: "/api/barcodes/4011", statusCode: 200},
+	{name: "PriceCart", method: "POST", path: "/api/cart", statusCode: 200,
+		body: `{"items":[{"produce_code":"` + benchmarkCode(0) + `","quantity":2},{"produce_code":"` + benchmarkCode(benchmarkCatalogSize-1) + `"}]}`},
+	{name: "GetTaxTable", method: "GET", path: "/api/tax", statusCode: 200},
+	{name: "GetRules", method: "GET", path: "/api/rules", statusCode: 200},
+	{name: "ReplaceRules", method: "PUT", path: "/api/rules", statusCode: 200,
+		body: `{"rules":[{"field":"name","max_length":40}]}`},
+	{name: "GetPromotions", method: "GET", path: "/api/promotions", statusCode: 200},
+	{name: "CreatePromotion", method: "POST", path: "/api/promotions", statusCode: 201,
+		body: `{"name":"Bogo","type":"bogo","buy_quantity":1,"get_quantity":1,"produce_codes":["` + benchmarkCode(2) + `"]}`,
+		undo: func(db *DBObject) { db.promotions.promotions = db.promotions.promotions[:1] }},
+	{name: "GetPromotion", method: "GET", path: "/api/promotions/bench-promo", statusCode: 200},
+	{name: "ReplacePromotion", method: "PUT", path: "/api/promotions/bench-promo", statusCode: 200,
+		body: `{"name":"Special","type":"percent_off","percent":"15","produce_codes":["` + benchmarkCode(0) + `"]}`},
+	{name: "DeletePromotion", method: "DELETE", path: "/api/promotions/bench-promo", statusCode: 200,
+		undo: func(db *DBObject) { db.promotions.promotions = benchmarkDB(1).promotions.promotions }},
+	{name: "GetCategories", method: "GET", path: "/api/categories", statusCode: 200},
+	{name: "CreateCategory", method: "POST", path: "/api/categories", statusCode: 201,
+		body: `{"category_id":"stone-fruit","name":"Stone Fruit","parent_id":"fruit"}`,
+		undo: func(db *DBObject) { db.removeCategory("stone-fruit") }},
+	{name: "GetCategory", method: "GET", path: "/api/categories/fruit", statusCode: 200},
+	{name: "ReplaceCategory", method: "PUT", path: "/api/categories/fruit", statusCode: 200,
+		body: `{"name":"Fresh Fruit"}`},
+	{name: "DeleteCategory", method: "DELETE", path: "/api/categories/dairy", statusCode: 200,
+		undo: func(db *DBObject) { db.addCategory(Category{ID: "dairy", Name: "Dairy"}) }},
+	{name: "GetStores", method: "GET", path: "/api/stores", statusCode: 200},
+	{name: "CreateStore", method: "POST", path: "/api/stores", statusCode: 201,
+		body: `{"store_id":"dayton-2","name":"Dayton"}`,
+		undo: func(db *DBObject) { db.removeStore("dayton-2") }},
+	{name: "GetStore", method: "GET", path: "/api/stores/columbus-1", statusCode: 200},
+	{name: "DeleteStore", method: "DELETE", path: "/api/stores/columbus-1", statusCode: 200,
+		undo: func(db *DBObject) { db.addStore(Store{ID: "columbus-1", Name: "Columbus"}) }},
+	{name: "GetStoreProduce", method: "GET", path: "/api/stores/columbus-1/produce", statusCode: 200},
+	{name: "GetStoreRules", method: "GET", path: "/api/stores/columbus-1/rules", statusCode: 200},
+	{name: "ReplaceStoreRules", method: "PUT", path: "/api/stores/columbus-1/rules", statusCode: 200,
+		body: `{"rules":[{"field":"unit_price","min":"$0.50"}]}`},
+	{name: "GetStoreProduceItem", method: "GET", path: "/api/stores/columbus-1/produce/" + benchmarkCode(0), statusCode: 200},
+	{name: "SetStoreOverride", method: "PUT", path: "/api/stores/columbus-1/produce/" + benchmarkCode(0), statusCode: 200,
+		body: `{"unit_price":"$1.39"}`},
+	{name: "DeleteStoreOverride", method: "DELETE", path: "/api/stores/columbus-1/produce/" + benchmarkCode(0), statusCode: 200,
+		undo: func(db *DBObject) {
+			price := "$1.49"
+			db.setStoreOverride(context.Background(), "columbus-1", StoreOverride{ProduceCode: benchmarkCode(0), UnitPrice: &price})
+		}},
+	{name: "GetWebhooks", method: "GET", path: "/api/webhooks", statusCode: 200},
+	{name: "CreateWebhook", method: "POST", path: "/api/webhooks", statusCode: 201,
+		body: `{"url":"http://127.0.0.1:1/hook","events":["update"]}`,
+		undo: func(db *DBObject) {
+			for _, sub := range db.getWebhooks()[1:] {
+				db.removeWebhook(sub.ID)
+			}
+		}},
+	{name: "GetWebhook", method: "GET", path: "/api/webhooks/bench-hook", statusCode: 200},
+	{name: "DeleteWebhook", method: "DELETE", path: "/api/webhooks/bench-hook", statusCode: 200,
+		undo: func(db *DBObject) {
+			fresh := benchmarkDB(1)
+			db.webhooks.subscriptions, db.webhooks.queues = fresh.webhooks.subscriptions, fresh.webhooks.queues
+		}},
+	{name: "GetDeadLetters", method: "GET", path: "/api/webhooks/deadletters", statusCode: 200},
+}
+
+//benchmark every handler through the router against a catalog of benchmarkCatalogSize items, e.g.
+//go test -run=^$ -bench=Handlers/GetProduceItem -benchmem ./api
+func BenchmarkHandlers(b *testing.B) {
+	defer func(allow bool) { WebhookAllowPrivateTargets = allow }(WebhookAllowPrivateTargets)
+	WebhookAllowPrivateTargets = true
+	for _, item := range handlerBenchmarks {
+		item := item
+		b.Run(item.name, func(b *testing.B) {
+			db := benchmarkDB(benchmarkCatalogSize)
+			router := handlersFor(db)
+			if item.setup != nil {
+				item.setup(db)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for index := 0; index < b.N; index++ {
+				request := httptest.NewRequest(item.method, item.path, strings.NewReader(item.body))
+				request.Header.Set("Authorization", "Bearer bench-admin")
+				if item.contentType != "" {
+					request.Header.Set("Content-Type", item.contentType)
+				}
+				response := httptest.NewRecorder()
+				router.ServeHTTP(response, request)
+				if response.Code != item.statusCode {
+					b.Fatalf("unexpected status %d for %s: %s", response.Code, item.name, response.Body)
+				}
+				if item.undo != nil {
+					b.StopTimer()
+					item.undo(db)
+					b.StartTimer()
+				}
+			}
+		})
+	}
+}
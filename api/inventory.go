@@ -0,0 +1,252 @@
+//contains on-hand stock tracking for produce items along with its handler functions
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//units of measure stock can be counted in
+var unitsOfMeasure = map[string]bool{"each": true, "lb": true, "kg": true, "bunch": true}
+
+//errors returned by stock operations
+var (
+	errProduceNotFound   = errors.New("produce code does not exist")
+	errInsufficientStock = errors.New("insufficient stock")
+	errUnitMismatch      = errors.New("unit does not match stock unit")
+)
+
+//type to store the on-hand quantity of a produce item. OnHand may only drop below zero when AllowBackorder is set.
+type StockLevel struct {
+	OnHand         float64 `json:"on_hand" xml:"on_hand"`
+	Unit           string  `json:"unit" xml:"unit"`
+	AllowBackorder bool    `json:"allow_backorder" xml:"allow_backorder"`
+}
+
+//type to store a stock movement sent to the receive, adjust and sale end points. Unit is optional, when given it
+//must match the unit the item is stocked in.
+type stockMovement struct {
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+}
+
+//returns the stock level of the item or, if the item has never been stocked, an empty level counted in the unit the
+//item is priced in so weighed items can be received by weight right away
+func (pItem *ProduceItem) stockLevel() StockLevel {
+	if pItem.Stock == nil {
+		return StockLevel{Unit: pItem.pricingUnit()}
+	}
+	return *pItem.Stock
+}
+
+//rounds a quantity to three decimals so repeated weighed movements don't accumulate float noise
+func roundQuantity(quantity float64) float64 {
+	return math.Round(quantity*1000) / 1000
+}
+
+//checks that a stock level has a known unit and is not negative unless backorders are allowed
+func (stock *StockLevel) validateStockLevel() url.Values {
+	errs := url.Values{}
+
+	if !unitsOfMeasure[stock.Unit] {
+		errs.Add("unit", "invalid unit of measure")
+	}
+	if stock.OnHand < 0 && !stock.AllowBackorder {
+		errs.Add("on_hand", "on hand quantity can not be negative unless backorders are allowed")
+	}
+	return errs
+}
+
+//returns the stock level of the item of the given produce code, errProduceNotFound is returned if the code does not
+//exist. The item is read from the current catalog so no lock is needed.
+func (db *DBObject) getStockLevel(ctx context.Context, pCode string) (StockLevel, error) {
+	if err := ctx.Err(); err != nil {
+		return StockLevel{}, err
+	}
+	current := db.catalog()
+	if index := current.index(strings.ToUpper(pCode)); index >= 0 {
+		return current.Data[index].stockLevel(), nil
+	}
+	return StockLevel{}, errProduceNotFound
+}
+
+//replaces the stock level of the item of the given produce code, used for stock takes and to change the unit or the
+//backorder setting. The new level is returned.
+func (db *DBObject) setStockLevel(ctx context.Context, pCode string, stock StockLevel) (StockLevel, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return StockLevel{}, err
+	}
+	current := db.catalog()
+
+	pCode = strings.ToUpper(pCode)
+	index := current.index(pCode)
+	if index < 0 {
+		return StockLevel{}, errProduceNotFound
+	}
+	stock.OnHand = roundQuantity(stock.OnHand)
+	item := current.Data[index]
+	item.Stock = &stock
+	db.storeCatalog(current.withItem(index, item))
+	db.feed.publish("update", pCode, item)
+	return stock, nil
+}
+
+//adds delta to the on-hand quantity of the item of the given produce code as a single step under the write lock. If
+//unit is given it must match the stock unit. The change is rejected with errInsufficientStock when it would leave
+//the item below zero and backorders are not allowed. The new level is returned. A new StockLevel is stored on every
+//change so items already handed to readers are never modified.
+func (db *DBObject) adjustStock(ctx context.Context, pCode string, delta float64, unit string) (StockLevel, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return StockLevel{}, err
+	}
+	current := db.catalog()
+
+	pCode = strings.ToUpper(pCode)
+	index := current.index(pCode)
+	if index < 0 {
+		return StockLevel{}, errProduceNotFound
+	}
+	item := current.Data[index]
+	stock := item.stockLevel()
+	if unit != "" && unit != stock.Unit {
+		return StockLevel{}, errUnitMismatch
+	}
+	stock.OnHand = roundQuantity(stock.OnHand + delta)
+	if stock.OnHand < 0 && !stock.AllowBackorder {
+		return StockLevel{}, errInsufficientStock
+	}
+	item.Stock = &stock
+	db.storeCatalog(current.withItem(index, item))
+	db.feed.publish("update", pCode, item)
+	return stock, nil
+}
+
+//This function returns the stock level of the produce code in the URL with a 200 status code. An invalid code
+//triggers a status 400 and an unknown code a status 404.
+func handleGetStock(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	stock, err := db.getStockLevel(r.Context(), params["produce_code"])
+	stockResponse(w, stock, err)
+}
+
+//This function replaces the stock level of the produce code in the URL with the JSON body, as done after a stock
+//take or to change the unit of measure or backorder setting. Invalid JSON or an invalid level triggers a status 400.
+func handleSetStock(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var stock StockLevel
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&stock) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	validErrs := stock.validateStockLevel()
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stock, err = db.setStockLevel(r.Context(), params["produce_code"], stock)
+	stockResponse(w, stock, err)
+}
+
+//This function adds received stock to the produce code in the URL. The quantity must be positive.
+func handleReceiveStock(w http.ResponseWriter, r *http.Request) {
+	handleStockMovement(w, r, 1, false)
+}
+
+//This function applies a manual correction to the stock of the produce code in the URL, e.g. for shrink or
+//spoilage. The quantity may be positive or negative but not zero.
+func handleAdjustStock(w http.ResponseWriter, r *http.Request) {
+	handleStockMovement(w, r, 1, true)
+}
+
+//This function takes sold stock off the produce code in the URL. The quantity must be positive.
+func handleSellStock(w http.ResponseWriter, r *http.Request) {
+	handleStockMovement(w, r, -1, false)
+}
+
+//This function decodes a stock movement from the JSON body and applies quantity*sign to the stock of the produce code
+//in the URL. A bad code, bad JSON or a bad quantity triggers a status 400, an unknown code a 404, a unit that does
+//not match the stock unit a 400 and a movement that would take stock below zero without backorders a 409. On success
+//the new stock level is returned with a 200 status code.
+func handleStockMovement(w http.ResponseWriter, r *http.Request, sign float64, allowNegative bool) {
+	db := tenantDB(r)
+	var movement stockMovement
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&movement) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	errs := url.Values{}
+	if movement.Quantity == 0 || (movement.Quantity < 0 && !allowNegative) {
+		errs.Add("quantity", "invalid quantity")
+	}
+	if movement.Unit != "" && !unitsOfMeasure[movement.Unit] {
+		errs.Add("unit", "invalid unit of measure")
+	}
+	if len(errs) > 0 {
+		err := ValidationError{errs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	stock, err := db.adjustStock(r.Context(), params["produce_code"], sign*movement.Quantity, movement.Unit)
+	stockResponse(w, stock, err)
+}
+
+//writes the result of a stock operation, mapping its error to a status code
+func stockResponse(w http.ResponseWriter, stock StockLevel, err error) {
+	switch err {
+	case nil:
+		jsonResponse(w, http.StatusOK, stock)
+	case errProduceNotFound:
+		http.Error(w, "error 404 - produce code does not exist", 404)
+	case errUnitMismatch:
+		http.Error(w, "error 400 - "+err.Error(), http.StatusBadRequest)
+	case errInsufficientStock:
+		http.Error(w, "error 409 - "+err.Error(), http.StatusConflict)
+	default:
+		modelErrorResponse(w, err)
+	}
+}
@@ -0,0 +1,102 @@
+//tests for inventory.go
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func TestHandleStockMovements(t *testing.T) {
+	t.Parallel()
+	var stockTests = []struct {
+		desc         string
+		method       string
+		path         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"get stock level", "GET", "A12T-4GH7-QPL9-3N4M/inventory", "",
+			200, `{"on_hand":10,"unit":"each","allow_backorder":false}`},
+		//
+		{"receive stock", "POST", "A12T-4GH7-QPL9-3N4M/inventory/receive", `{"quantity":5}`,
+			200, `{"on_hand":15,"unit":"each","allow_backorder":false}`},
+		//
+		{"sell stock", "POST", "A12T-4GH7-QPL9-3N4M/inventory/sale", `{"quantity":4}`,
+			200, `{"on_hand":6,"unit":"each","allow_backorder":false}`},
+		//
+		{"oversell without backorders", "POST", "A12T-4GH7-QPL9-3N4M/inventory/sale", `{"quantity":11}`,
+			409, "error 409 - insufficient stock\n"},
+		//
+		{"negative adjustment", "POST", "A12T-4GH7-QPL9-3N4M/inventory/adjust", `{"quantity":-2.5}`,
+			200, `{"on_hand":7.5,"unit":"each","allow_backorder":false}`},
+		//
+		{"negative sale quantity", "POST", "A12T-4GH7-QPL9-3N4M/inventory/sale", `{"quantity":-1}`,
+			400, `{"validationError":{"quantity":["invalid quantity"]}}`},
+		//
+		{"unit mismatch", "POST", "A12T-4GH7-QPL9-3N4M/inventory/receive", `{"quantity":1,"unit":"kg"}`,
+			400, "error 400 - unit does not match stock unit\n"},
+		//
+		{"set backorder level", "PUT", "A12T-4GH7-QPL9-3N4M/inventory", `{"on_hand":-3,"unit":"lb","allow_backorder":true}`,
+			200, `{"on_hand":-3,"unit":"lb","allow_backorder":true}`},
+		//
+		{"negative level without backorders", "PUT", "A12T-4GH7-QPL9-3N4M/inventory", `{"on_hand":-3,"unit":"lb"}`,
+			400, `{"validationError":{"on_hand":["on hand quantity can not be negative unless backorders are allowed"]}}`},
+		//
+		{"code does not exist", "POST", "A12T-4GH7-QPL9-0000/inventory/receive", `{"quantity":1}`,
+			404, "error 404 - produce code does not exist\n"},
+	}
+
+	for _, item := range stockTests {
+		f := newFixture(t, nil)
+		f.db.setStockLevel(context.Background(), "A12T-4GH7-QPL9-3N4M", StockLevel{OnHand: 10, Unit: "each"})
+
+		f.request(item.method, "/api/produce/"+item.path, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
+
+//test that an item that was never stocked is counted in the unit it is priced in
+func TestUnstockedItemUnit(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, []ProduceItem{
+		{ProduceCode: "B4N4-N4S0-0000-0000", Name: "Banana", UnitPrice: "$1.29", PriceUnit: "kg"},
+		{ProduceCode: "L1M3-0000-0000-0000", Name: "Lime", UnitPrice: "$0.39"},
+	})
+
+	f.request("GET", "/api/produce/B4N4-N4S0-0000-0000/inventory", "").assert(t, 200,
+		`{"on_hand":0,"unit":"kg","allow_backorder":false}`, "unstocked weighed item")
+	f.request("POST", "/api/produce/B4N4-N4S0-0000-0000/inventory/receive", `{"quantity":12.5,"unit":"kg"}`).assert(t, 200,
+		`{"on_hand":12.5,"unit":"kg","allow_backorder":false}`, "receive kg stock on unstocked weighed item")
+	f.request("POST", "/api/produce/L1M3-0000-0000-0000/inventory/receive", `{"quantity":20,"unit":"each"}`).assert(t, 200,
+		`{"on_hand":20,"unit":"each","allow_backorder":false}`, "receive stock on unstocked item priced per each")
+}
+
+//test that concurrent sales never oversell an item
+func TestAdjustStockConcurrent(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	ctx := context.Background()
+	db.setStockLevel(ctx, "E5T6-9UI3-TH15-QR88", StockLevel{OnHand: 50, Unit: "each"})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	sold := 0
+	for index := 0; index < 80; index++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := db.adjustStock(ctx, "E5T6-9UI3-TH15-QR88", -1, ""); err == nil {
+				mu.Lock()
+				sold++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stock, _ := db.getStockLevel(ctx, "E5T6-9UI3-TH15-QR88")
+	assert.Equal(t, 50, sold, "unexpected number of sales")
+	assert.Equal(t, float64(0), stock.OnHand, "stock went negative")
+}
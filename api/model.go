@@ -2,144 +2,409 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-//type to store a produce item
+//how long deleted items are kept in the trash before they are purged for good
+var TrashRetention = 30 * 24 * time.Hour
+
+//type to store a produce item. UnitPrice is the price per PriceUnit, which is each when left empty. TaxCategory picks
+//the rate from the tax table, the default category is used when left empty. Category is the id of the category the
+//item is grouped in and Barcodes the PLU, UPC-A and EAN-13 codes scanners read for it. Stock is nil until the item is
+//first stocked and is only changed through the inventory end points. The validate tags hold the rules the fields are
+//checked against, see FieldRule.
 type ProduceItem struct {
-	ProduceCode string `json:"produce_code"`
-	Name        string `json:"name"`
-	UnitPrice   string `json:"unit_price"`
+	ProduceCode string      `json:"produce_code" xml:"produce_code" validate:"required,format=produce_code" label:"produce"`
+	Name        string      `json:"name" xml:"name" validate:"required,format=name"`
+	UnitPrice   string      `json:"unit_price" xml:"unit_price" validate:"required,format=unit_price"`
+	PriceUnit   string      `json:"price_unit,omitempty" xml:"price_unit,omitempty" validate:"format=price_unit"`
+	TaxCategory string      `json:"tax_category,omitempty" xml:"tax_category,omitempty" validate:"format=tax_category"`
+	Category    string      `json:"category,omitempty" xml:"category,omitempty"`
+	Barcodes    []string    `json:"barcodes,omitempty" xml:"-"`
+	Stock       *StockLevel `json:"stock,omitempty" xml:"stock,omitempty"`
+}
+
+//type to store a deleted produce item along with the time it was deleted
+type TrashedItem struct {
+	ProduceItem
+	DeletedAt time.Time `json:"deleted_at"`
 }
 
-//type to represent a database with a mutex to assist in preventing race conditions
+//type to represent a database. The produce items and the trash are kept in a catalog that is never changed once
+//published, readers load the current catalog without locking while writers, serialized by mu, publish changed copies.
+//Deleted items are moved from Data into Trash until they are restored or purged. Every change to Data is published on
+//feed and delivered to the subscriptions in webhooks. Prices are discounted by the promotions. Data is the master
+//catalog the stores override. Items are grouped in the category tree in categories and checked against the validation
+//rules in rules.
 type DBObject struct {
-	mu   sync.RWMutex
-	Data []ProduceItem
-}
-
-//return all items from the database on a channel, used RLock since only reading done.
-func getAllProduceItems(allItemsChnl chan []ProduceItem) {
-	currentDB.mu.RLock()
-	defer currentDB.mu.RUnlock()
-	allItemsChnl <- currentDB.Data
-}
-
-//returns a single produce item on a channel based on the given produce code
-//if the item is not found an empty item is returned on the channel.
-//RLock is used since only read operations done here
-func getProduceItem(pCode string, pItemChnl chan ProduceItem) {
-	currentDB.mu.RLock()
-	defer currentDB.mu.RUnlock()
-	for _, item := range currentDB.Data {
-		if item.ProduceCode == pCode {
-			pItemChnl <- item
-			return
-		}
+	mu         sync.Mutex
+	current    atomic.Value //holds the current *catalog, see catalog()
+	feed       changeFeed
+	webhooks   webhookRegistry
+	promotions promotionBook
+	stores     storeRegistry
+	categories categoryTree
+	rules      ruleBook
+	codes      CodeScheme //scheme of the tenant, see codeScheme
+	taxes      *taxBook   //tax settings of the tenant, see taxBook
+	apiKey     string     //key requests for the tenant must carry, see resolveTenant
+	adminKey   string     //key admin writes of the tenant must carry, see adminAPIKey
+}
+
+//type of the error returned by a write when the item refers to data that changed after the handler validated it, a
+//category that was removed or a barcode or near duplicate name that was given to another item in the meantime. It is
+//also returned when an item as sold in a store breaks the rules of the store. Errs holds the messages by field like a
+//ValidationError.
+type referenceError struct {
+	errs url.Values
+}
+
+//returns the messages of the error by field, fields in alphabetical order
+func (err referenceError) Error() string {
+	fields := []string{}
+	for field := range err.errs {
+		fields = append(fields, field)
 	}
-	pItemChnl <- ProduceItem{}
+	sort.Strings(fields)
+	messages := []string{}
+	for _, field := range fields {
+		messages = append(messages, field+": "+strings.Join(err.errs[field], ", "))
+	}
+	return strings.Join(messages, "; ")
+}
+
+//errors returned by the model methods besides errProduceNotFound and the error of a canceled request context
+var (
+	errProduceCodeExists = errors.New("produce code already exists")
+	errCodesNotGenerated = errors.New("produce codes can not be generated")
+)
+
+//returns copies of all items from the database, so the caller may change them. The items come from the current
+//catalog so no lock is needed.
+func (db *DBObject) getAllProduceItems(ctx context.Context) ([]ProduceItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return db.catalog().items(), nil
+}
+
+//returns a copy of a single produce item based on the given produce code, errProduceNotFound is returned if the item
+//is not found. The item is read from the current catalog so no lock is needed.
+func (db *DBObject) getProduceItem(ctx context.Context, pCode string) (ProduceItem, error) {
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
+	if index := current.index(pCode); index >= 0 {
+		return current.Data[index].clone(), nil
+	}
+	return ProduceItem{}, errProduceNotFound
 }
 
 //creates a new produce item in the database by checking if the given produce code already exists.
-//if the code exists an empty item is returned to the channel. If the code does not exist the item is
-//appended to the database and returned on the channel
-func createProduceItem(pItem ProduceItem, pItemChnl chan ProduceItem) {
-	currentDB.mu.Lock()
-	defer currentDB.mu.Unlock()
+//if the code exists errProduceCodeExists is returned. If the code does not exist the item is
+//appended to the database and returned. New items start without stock. An item without a produce code is given an
+//unused code by the generator of the code scheme of the database, errCodesNotGenerated is returned if the scheme can
+//not generate codes or no unused code was found.
+//Nothing is changed if the context is done by the time the write lock is held. The references of the item are checked
+//again under the lock, see checkItemReferences.
+func (db *DBObject) createProduceItem(ctx context.Context, pItem ProduceItem) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
 
-	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
-	for _, item := range currentDB.Data {
-		if item.ProduceCode == pItem.ProduceCode {
-			pItemChnl <- ProduceItem{}
-			return
+	if pItem.ProduceCode == "" {
+		generator, ok := db.codeScheme().(CodeGenerator)
+		if !ok {
+			return ProduceItem{}, errCodesNotGenerated
+		}
+		code, err := current.unusedProduceCode(generator)
+		if err != nil {
+			return ProduceItem{}, err
 		}
+		pItem.ProduceCode = code
+	}
+	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
+	pItem.Stock = nil
+	if current.index(pItem.ProduceCode) >= 0 {
+		return ProduceItem{}, errProduceCodeExists
 	}
-	currentDB.Data = append(currentDB.Data, pItem)
-	pItemChnl <- pItem
+	if err := db.checkItemReferences(current, pItem.ProduceCode, &pItem); err != nil {
+		return ProduceItem{}, err
+	}
+	db.storeCatalog(current.withItem(len(current.Data), pItem.clone()))
+	db.feed.publish("create", pItem.ProduceCode, pItem)
+	return pItem, nil
 }
 
-//updates an item in the database of the given produce code. If the produce code given does not exist an empty item
-//is returned on the channel. If the code exists but the new code being updated already exists in the database a produce code
-// "0" is returned. If the item is able to be created the new contents overwrite the old ones at the given index and return
-//the new produce item on the channel.
-func updateProduceItem(pCode string, pItem ProduceItem, pItemChnl chan ProduceItem) {
-	currentDB.mu.Lock()
-	defer currentDB.mu.Unlock()
+//updates an item in the database of the given produce code. If the produce code given does not exist
+//errProduceNotFound is returned. If the code exists but the new code being updated already exists in the database
+//errProduceCodeExists is returned. Otherwise the new contents overwrite the old ones at the given index and the
+//stored item is returned. Store overrides follow the item when its code changes. A referenceError is returned if the
+//item refers to data that no longer exists.
+func (db *DBObject) updateProduceItem(ctx context.Context, pCode string, pItem ProduceItem) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	return db.applyUpdate(db.catalog(), strings.ToUpper(pCode), pItem)
+}
+
+//applies a partial update to the item of the given produce code. The patch body is applied according to its content
+//type, see patchProduceItem, to the stored item while the write lock is held, so no other write can change the item
+//between reading and storing it. The patched item is validated as a whole, a patchValidationError holds the errors if
+//it is not valid. The errors of updateProduceItem apply when it is stored and the stored item is returned.
+func (db *DBObject) patchStoredItem(ctx context.Context, pCode string, contentType string, body []byte) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
 
-	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
 	pCode = strings.ToUpper(pCode)
+	index := current.index(pCode)
+	if index < 0 {
+		return ProduceItem{}, errProduceNotFound
+	}
+	pItem, err := patchProduceItem(current.Data[index].clone(), contentType, body)
+	if err != nil {
+		return ProduceItem{}, err
+	}
 
-	for index, item := range currentDB.Data {
-		if item.ProduceCode == pCode {
-			for _, item := range currentDB.Data {
-				if item.ProduceCode == pItem.ProduceCode && pCode != pItem.ProduceCode {
-					pItemChnl <- ProduceItem{ProduceCode: "0", Name: "", UnitPrice: ""}
-					return
-				}
-			}
-			currentDB.Data[index].ProduceCode = pItem.ProduceCode
-			currentDB.Data[index].Name = pItem.Name
-			currentDB.Data[index].UnitPrice = pItem.UnitPrice
-			pItemChnl <- pItem
-			return
-		}
+	//only the patched item is validated
+	validErrs := pItem.validateProduceItem(db)
+	db.validateItemReferences(pCode, &pItem, validErrs)
+	if len(validErrs) > 0 {
+		return ProduceItem{}, patchValidationError{validErrs}
 	}
+	return db.applyUpdate(current, pCode, pItem)
+}
 
-	pItemChnl <- ProduceItem{}
+//stores the contents of pItem over the item stored under pCode in the given catalog and returns the stored item, see
+//updateProduceItem. The caller holds the write lock and current is the catalog it loaded.
+func (db *DBObject) applyUpdate(current *catalog, pCode string, pItem ProduceItem) (ProduceItem, error) {
+	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
+	index := current.index(pCode)
+	if index < 0 {
+		return ProduceItem{}, errProduceNotFound
+	}
+	if pCode != pItem.ProduceCode && current.index(pItem.ProduceCode) >= 0 {
+		return ProduceItem{}, errProduceCodeExists
+	}
+	if err := db.checkItemReferences(current, pCode, &pItem); err != nil {
+		return ProduceItem{}, err
+	}
+	updated := current.Data[index]
+	updated.ProduceCode = pItem.ProduceCode
+	updated.Name = pItem.Name
+	updated.UnitPrice = pItem.UnitPrice
+	updated.PriceUnit = pItem.PriceUnit
+	updated.TaxCategory = pItem.TaxCategory
+	updated.Category = pItem.Category
+	updated.Barcodes = pItem.clone().Barcodes
+	db.storeCatalog(current.withItem(index, updated))
+	if pCode != pItem.ProduceCode {
+		db.renameStoreOverrides(pCode, pItem.ProduceCode)
+		db.renamePromotionCodes(pCode, pItem.ProduceCode)
+	}
+	db.feed.publish("update", pCode, updated)
+	return updated.clone(), nil
+}
+
+//replaces the item stored under the produce code of the given item with the given item in full. If the produce code
+//does not exist yet the item is appended to the database instead. Stock is managed by the inventory end points so it
+//is kept from the existing item. The stored item is returned along with true when the item was created and false when
+//an existing item was replaced. A referenceError is returned if the item refers to data that no longer exists.
+func (db *DBObject) putProduceItem(ctx context.Context, pItem ProduceItem) (ProduceItem, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, false, err
+	}
+	current := db.catalog()
+
+	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
+	pItem.Stock = nil
+	if err := db.checkItemReferences(current, pItem.ProduceCode, &pItem); err != nil {
+		return ProduceItem{}, false, err
+	}
+	if index := current.index(pItem.ProduceCode); index >= 0 {
+		pItem.Stock = current.Data[index].Stock
+		db.storeCatalog(current.withItem(index, pItem.clone()))
+		db.feed.publish("update", pItem.ProduceCode, pItem)
+		return pItem.clone(), false, nil
+	}
+	db.storeCatalog(current.withItem(len(current.Data), pItem.clone()))
+	db.feed.publish("create", pItem.ProduceCode, pItem)
+	return pItem.clone(), true, nil
 }
 
-//deletes an item from the database based on the incoming produce code. If the produce code is not found an
-//empty item is returned. If the code is found it is then removed from the database.
-func deleteProduceItem(pCode string, pItemChnl chan ProduceItem) {
-	currentDB.mu.Lock()
-	defer currentDB.mu.Unlock()
-	var pItem ProduceItem
+//deletes an item from the database based on the incoming produce code. If the produce code is not found
+//errProduceNotFound is returned. If the code is found it is moved from the database into the trash with the current
+//time so it can be restored later and the deleted item is returned. An older trash entry with the same code is
+//replaced. The overrides stores had for the item are removed, a restored item starts from the master catalog.
+func (db *DBObject) deleteProduceItem(ctx context.Context, pCode string) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
 
-	for index, item := range currentDB.Data {
-		if item.ProduceCode == pCode {
-			currentDB.Data = append(currentDB.Data[:index], currentDB.Data[index+1:]...)
-			pItem.ProduceCode = item.ProduceCode
-			pItem.Name = item.Name
-			pItem.UnitPrice = item.UnitPrice
-			pItemChnl <- pItem
-			return
+	index := current.index(pCode)
+	if index < 0 {
+		return ProduceItem{}, errProduceNotFound
+	}
+	item := current.Data[index]
+	pItem := item.clone()
+	pItem.Stock = nil
+
+	trash, _ := current.trashWithout(item.ProduceCode)
+	db.storeCatalog(current.withoutItem(index).withTrash(append(trash, TrashedItem{item, time.Now()})))
+	db.removeItemOverrides(item.ProduceCode)
+	db.feed.publish("delete", pItem.ProduceCode, pItem)
+	return pItem, nil
+}
+
+//returns copies of all items in the trash that have not outlived the retention period. The trash comes from the current catalog
+//so no lock is needed. Items past the retention period are left for purgeTrash to remove.
+func (db *DBObject) getTrashedItems(ctx context.Context) ([]TrashedItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-TrashRetention)
+	trash := []TrashedItem{}
+	for _, item := range db.catalog().Trash {
+		if item.DeletedAt.After(cutoff) {
+			trash = append(trash, TrashedItem{item.ProduceItem.clone(), item.DeletedAt})
 		}
 	}
-	pItemChnl <- ProduceItem{}
+	return trash, nil
 }
 
-//checks that produce item fields are populated as intended and in the correct format.
-func (pItem *ProduceItem) validateProduceItem() url.Values {
-	errs := url.Values{} //store errors
+//moves an item of the given produce code from the trash back into the database. If the code is not in the trash
+//errProduceNotFound is returned. If an item with the same code has been created since the delete
+//errProduceCodeExists is returned and the trash is left untouched. The item is checked like a new one, a
+//referenceError is returned if it refers to data removed while it was in the trash, its category for example.
+//Otherwise the restored item is returned.
+func (db *DBObject) restoreProduceItem(ctx context.Context, pCode string) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
 
-	//all required fields exist
-	if pItem.ProduceCode == "" {
-		errs.Add("produce_code", "produce field is required")
+	pCode = strings.ToUpper(pCode)
+	cutoff := time.Now().Add(-TrashRetention)
+	for _, trashed := range current.Trash {
+		if trashed.ProduceCode == pCode && trashed.DeletedAt.After(cutoff) {
+			if current.index(pCode) >= 0 {
+				return ProduceItem{}, errProduceCodeExists
+			}
+			if err := db.checkItemReferences(current, pCode, &trashed.ProduceItem); err != nil {
+				return ProduceItem{}, err
+			}
+			trash, _ := current.trashWithout(pCode)
+			db.storeCatalog(current.withItem(len(current.Data), trashed.ProduceItem).withTrash(trash))
+			db.feed.publish("create", pCode, trashed.ProduceItem)
+			return trashed.ProduceItem.clone(), nil
+		}
 	}
+	return ProduceItem{}, errProduceNotFound
+}
 
-	if pItem.Name == "" {
-		errs.Add("name", "name field is required")
+//permanently removes an item of the given produce code from the trash and returns it. errProduceNotFound is returned
+//if the code is not in the trash.
+func (db *DBObject) purgeTrashedItem(ctx context.Context, pCode string) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
 	}
+	current := db.catalog()
 
-	if pItem.UnitPrice == "" {
-		errs.Add("unit_price", "unit price field is required")
+	trash, removed := current.trashWithout(strings.ToUpper(pCode))
+	if removed.ProduceCode == "" {
+		return ProduceItem{}, errProduceNotFound
 	}
+	db.storeCatalog(current.withTrash(trash))
+	return removed.ProduceItem.clone(), nil
+}
 
-	//are all values valid formats
-	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
-	if !isValidProduceCode(pItem.ProduceCode) {
-		errs.Add("produce_code", "invalid produce code format")
+//permanently removes all items deleted before the cutoff from the trash and returns the number of purged items.
+func (db *DBObject) purgeTrash(ctx context.Context, cutoff time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
+	current := db.catalog()
 
-	if !isValidName(pItem.Name) {
-		errs.Add("name", "invalid name format")
+	kept := []TrashedItem{}
+	for _, item := range current.Trash {
+		if item.DeletedAt.After(cutoff) {
+			kept = append(kept, item)
+		}
+	}
+	purged := len(current.Trash) - len(kept)
+	if purged > 0 {
+		db.storeCatalog(current.withTrash(kept))
 	}
+	return purged, nil
+}
 
-	if !isValidUnitPrice(pItem.UnitPrice) {
-		errs.Add("unit_price", "invalid unit price format")
+//purges items that have outlived TrashRetention from the trash of every tenant every interval. Runs until the program
+//exits.
+func runTrashJanitor(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, db := range allDatabases() {
+			db.purgeTrash(context.Background(), time.Now().Add(-TrashRetention))
+		}
 	}
+}
 
-	return errs
+//checks that produce item fields are populated as intended and in the correct format by checking them against the
+//rules in the validate tags of ProduceItem. The produce code is upper cased and the name normalized first. Formats that
+//depend on the tenant, the code scheme and the tax categories, are those of db.
+func (pItem *ProduceItem) validateProduceItem(db *DBObject) url.Values {
+	pItem.ProduceCode = strings.ToUpper(pItem.ProduceCode)
+	pItem.Name = normalizeName(pItem.Name)
+	return checkRules(db, pItem, produceItemRules)
+}
+
+//checks the item against the validation rules of the database and the fields of the item that refer to other data
+//in the database, its category, barcodes and how close its name is to other names, adding any errors to errs. pCode is
+//the code the item is currently stored under so it is not reported as clashing with itself.
+func (db *DBObject) validateItemReferences(pCode string, pItem *ProduceItem, errs url.Values) {
+	db.validateItemRules(pItem, errs)
+	validateItemName(db.catalog(), pCode, pItem, errs)
+	db.validateItemCategory(pItem, errs)
+	validateItemBarcodes(db.catalog(), pCode, pItem, errs)
+}
+
+//checks the fields of the item that refer to other data in the database against the given catalog and returns a
+//referenceError if any of them is no longer valid. The writers call it while they hold the write lock, the checks
+//the handlers make before taking the lock may be outdated by then. pCode is the code the item is stored under.
+func (db *DBObject) checkItemReferences(current *catalog, pCode string, pItem *ProduceItem) error {
+	errs := url.Values{}
+	validateItemName(current, pCode, pItem, errs)
+	db.validateItemCategory(pItem, errs)
+	validateItemBarcodes(current, pCode, pItem, errs)
+	if len(errs) > 0 {
+		return referenceError{errs}
+	}
+	return nil
 }
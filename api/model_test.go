@@ -2,23 +2,30 @@
 package api
 
 import (
+	"context"
+	"net/http/httptest"
+	"sync"
 	"github.com/stretchr/testify/assert"
 	"fmt"
+	"math/rand"
+	"net/url"
 	"testing"
 	"encoding/json"
+	"time"
 )
 
 //test get all produce items
 func TestGetAllProduceItems(t *testing.T) {
-	reinitTest()
-	pItemChnl := make(chan []ProduceItem)
-	go getAllProduceItems(pItemChnl)
-	allItems := <-pItemChnl
-	assert.Equal(t, currentDB.Data, allItems, "DB not returning correct values")
+	t.Parallel()
+	db := newTestDB(nil)
+	allItems, err := db.getAllProduceItems(context.Background())
+	assert.Nil(t, err, "unexpected error")
+	assert.Equal(t, db.catalog().Data, allItems, "DB not returning correct values")
 }
 
 //test getting a single produce item from server
 func TestGetProduceItem(t *testing.T) {
+	t.Parallel()
 	var getProduceItemTests = []struct {
 		desc           string
 		produceCode    string
@@ -28,75 +35,220 @@ func TestGetProduceItem(t *testing.T) {
 		{"produce code invalid", "aji-ewfi-23ijf", ""},
 		{"produce code does not exist", "1111-1111-1111-1111", ""},
 	}
+	db := newTestDB(nil)
 	for _, item := range getProduceItemTests {
-		pItemChnl := make(chan ProduceItem)
-		go getProduceItem(item.produceCode, pItemChnl)
-		pItem := <-pItemChnl
+		pItem, _ := db.getProduceItem(context.Background(), item.produceCode)
 		assert.Equal(t, item.expectedOutput, pItem.ProduceCode, fmt.Sprintf("unexpected output for %s", item.desc))
 	}
 }
 
 //test creating a produce item on the create end point
 func TestCreateProduceItem(t *testing.T) {
+	t.Parallel()
 	var createProduceItemTests = []struct {
 		desc           string
 		pItem          ProduceItem
 		expectedOutput ProduceItem
+		expectedErr    error
 	}{
-		{"valid produce item", ProduceItem{"1111-1111-1111-1111", "Bacon", "$1.23"}, ProduceItem{"1111-1111-1111-1111", "Bacon", "$1.23"}},
-		{"produce code already exists", ProduceItem{"2222-2222-2222-2222", "Bacon", "$1.23"}, ProduceItem{"", "", ""}},
+		{"valid produce item", ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"}, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"}, nil},
+		{"produce code already exists", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Bacon", UnitPrice: "$1.23"}, ProduceItem{}, errProduceCodeExists},
 	}
 	for _, item := range createProduceItemTests {
-		reinitTest()
-		pItemChanl := make(chan ProduceItem)
-		go createProduceItem(item.pItem, pItemChanl)
-		pItem := <-pItemChanl
+		db := newTestDB(nil)
+		pItem, err := db.createProduceItem(context.Background(), item.pItem)
 		assert.Equal(t, item.expectedOutput, pItem, fmt.Sprintf("unexpeted output for %s", item.desc))
+		assert.Equal(t, item.expectedErr, err, fmt.Sprintf("unexpected error for %s", item.desc))
 	}
 }
 
 //testing updating a produce item on the update end point
 func TestUpdateProduceItem(t *testing.T) {
+	t.Parallel()
 	var updateProduceItemTests = []struct {
 		desc           string
 		produceCode    string
 		pItem          ProduceItem
 		expectedOutput ProduceItem
+		expectedErr    error
 	}{
-		{"valid produce code", "2222-2222-2222-2222", ProduceItem{"1111-1111-1111-1111", "Bacon", "$1.23"}, ProduceItem{"1111-1111-1111-1111", "Bacon", "$1.23"}},
-		{"updated code exists", "2222-2222-2222-2222", ProduceItem{"A12T-4GH7-QPL9-3N4M", "Bacon", "$1.23"}, ProduceItem{"0", "", ""}},
-		{"produce code not found", "ABCD-2222-2222-2222", ProduceItem{"A12T-4GH7-QPL9-3N4M", "Bacon", "$1.23"}, ProduceItem{"", "", ""}},
+		{"valid produce code", "2222-2222-2222-2222", ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"}, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"}, nil},
+		{"updated code exists", "2222-2222-2222-2222", ProduceItem{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Bacon", UnitPrice: "$1.23"}, ProduceItem{}, errProduceCodeExists},
+		{"produce code not found", "ABCD-2222-2222-2222", ProduceItem{ProduceCode: "A12T-4GH7-QPL9-3N4M", Name: "Bacon", UnitPrice: "$1.23"}, ProduceItem{}, errProduceNotFound},
 	}
 
 	for _, item := range updateProduceItemTests {
-		reinitTest()
-		pItemChnl := make(chan ProduceItem)
-		go updateProduceItem(item.produceCode, item.pItem, pItemChnl)
-		pItem := <-pItemChnl
+		db := newTestDB(nil)
+		pItem, err := db.updateProduceItem(context.Background(), item.produceCode, item.pItem)
 		assert.Equal(t, item.expectedOutput, pItem, fmt.Sprintf("unexpeted output for %s", item.desc))
+		assert.Equal(t, item.expectedErr, err, fmt.Sprintf("unexpected error for %s", item.desc))
 	}
 }
 
 //test deleting an item from the database on the delete end point
 func TestDeleteProduceItem(t *testing.T) {
+	t.Parallel()
 	var deleteProduceItemTests = []struct {
 		desc           string
 		produceCode    string
 		expectedOutput ProduceItem
 	}{
-		{"valid produce code", "2222-2222-2222-2222", ProduceItem{"2222-2222-2222-2222", "Gala Apple", "$3.59"}},
-		{"code does not exist", "ABCD-2222-2222-2222", ProduceItem{"", "", ""}},
+		{"valid produce code", "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59"}},
+		{"code does not exist", "ABCD-2222-2222-2222", ProduceItem{}},
 	}
 	for _, item := range deleteProduceItemTests {
-		reinitTest()
-		pItemChnl := make(chan ProduceItem)
-		go deleteProduceItem(item.produceCode, pItemChnl)
-		pItem := <-pItemChnl
+		db := newTestDB(nil)
+		pItem, _ := db.deleteProduceItem(context.Background(), item.produceCode)
 		assert.Equal(t, item.expectedOutput, pItem, fmt.Sprintf("unexpeted output for %s", item.desc))
+		if item.expectedOutput.ProduceCode != "" {
+			assert.Equal(t, item.expectedOutput, db.catalog().Trash[0].ProduceItem, fmt.Sprintf("item not in trash for %s", item.desc))
+		}
 	}
 }
 
+//test restoring a deleted item from the trash
+func TestRestoreProduceItem(t *testing.T) {
+	t.Parallel()
+	var restoreProduceItemTests = []struct {
+		desc           string
+		produceCode    string
+		recreate       bool
+		expectedOutput ProduceItem
+		expectedErr    error
+	}{
+		{"restore deleted item", "2222-2222-2222-2222", false, ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59"}, nil},
+		{"code reused since delete", "2222-2222-2222-2222", true, ProduceItem{}, errProduceCodeExists},
+		{"code not in trash", "ABCD-2222-2222-2222", false, ProduceItem{}, errProduceNotFound},
+	}
+	ctx := context.Background()
+	for _, item := range restoreProduceItemTests {
+		db := newTestDB(nil)
+		db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+		if item.recreate {
+			db.createProduceItem(ctx, ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Bacon", UnitPrice: "$1.23"})
+		}
+		pItem, err := db.restoreProduceItem(ctx, item.produceCode)
+		assert.Equal(t, item.expectedOutput, pItem, fmt.Sprintf("unexpeted output for %s", item.desc))
+		assert.Equal(t, item.expectedErr, err, fmt.Sprintf("unexpected error for %s", item.desc))
+	}
+}
+
+//test purging items that have outlived the retention period
+func TestPurgeTrash(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	now := time.Now()
+	trash := []TrashedItem{
+		{ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"}, now.Add(-TrashRetention - time.Hour)},
+		{ProduceItem{ProduceCode: "3333-3333-3333-3333", Name: "Eggs", UnitPrice: "$2.00"}, now.Add(-time.Hour)},
+	}
+	db.storeCatalog(db.catalog().withTrash(trash))
+
+	listed, _ := db.getTrashedItems(context.Background())
+	assert.Equal(t, trash[1:], listed, "expired item listed in trash")
+
+	purged, _ := db.purgeTrash(context.Background(), now.Add(-TrashRetention))
+	assert.Equal(t, 1, purged, "unexpected number of purged items")
+	assert.Equal(t, "3333-3333-3333-3333", db.catalog().Trash[0].ProduceCode, "wrong item purged")
+}
+
+//test that listings stay consistent while items are deleted and restored concurrently. Listers change the items they
+//get back, which must not reach the database or other listers. Run with -race to check that nothing is shared.
+func TestConcurrentListAndDelete(t *testing.T) {
+	t.Parallel()
+	seed := seedItems()
+	seed[0].Barcodes = []string{"4011"}
+	seed[0].Stock = &StockLevel{OnHand: 5, Unit: "each"}
+	db := newTestDB(seed)
+	handler := handlersFor(db)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for lister := 0; lister < 4; lister++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for step := 0; step < 200; step++ {
+				items, _ := db.getAllProduceItems(ctx)
+				for index := range items {
+					items[index].Name = "Changed"
+					if items[index].Stock != nil {
+						items[index].Barcodes[0] = "0000"
+						items[index].Stock.OnHand = -1
+					}
+				}
+				trash, _ := db.getTrashedItems(ctx)
+				for index := range trash {
+					trash[index].Name = "Changed"
+				}
+
+				response := httptest.NewRecorder()
+				handler.ServeHTTP(response, httptest.NewRequest("GET", "/api/produce?include_deleted=true", nil))
+				var listing []ProduceItem
+				if err := json.Unmarshal(response.Body.Bytes(), &listing); err != nil {
+					t.Errorf("invalid listing %q: %v", response.Body, err)
+					return
+				}
+				for _, item := range listing {
+					if item.Name == "Changed" || (item.Stock != nil && item.Stock.OnHand != 5) {
+						t.Errorf("change of a lister leaked into listing: %+v", item)
+						return
+					}
+				}
+			}
+		}()
+	}
+	for _, item := range seed {
+		wg.Add(1)
+		go func(pCode string) {
+			defer wg.Done()
+			for step := 0; step < 200; step++ {
+				deleted, _ := db.deleteProduceItem(ctx, pCode)
+				deleted.Name = "Changed"
+				restored, _ := db.restoreProduceItem(ctx, pCode)
+				restored.Name = "Changed"
+			}
+		}(item.ProduceCode)
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, seed, db.catalog().Data, "database changed by listers")
+	assert.Empty(t, db.catalog().Trash, "items left in the trash")
+}
+
+//test that model methods called with a done context return its error and leave the database unchanged
+func TestModelStopsOnDoneContext(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	before := db.catalog()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithTimeout(context.Background(), -time.Second)
+	defer cancelExpired()
+
+	for _, ctx := range []context.Context{canceled, expired} {
+		_, err := db.getAllProduceItems(ctx)
+		assert.Equal(t, ctx.Err(), err, "list not stopped")
+		_, err = db.getProduceItem(ctx, "2222-2222-2222-2222")
+		assert.Equal(t, ctx.Err(), err, "get not stopped")
+		_, err = db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"})
+		assert.Equal(t, ctx.Err(), err, "create not stopped")
+		_, err = db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Bacon", UnitPrice: "$1.23"})
+		assert.Equal(t, ctx.Err(), err, "update not stopped")
+		_, _, err = db.putProduceItem(ctx, ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Bacon", UnitPrice: "$1.23"})
+		assert.Equal(t, ctx.Err(), err, "replace not stopped")
+		_, err = db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+		assert.Equal(t, ctx.Err(), err, "delete not stopped")
+		_, err = db.adjustStock(ctx, "2222-2222-2222-2222", 1, "")
+		assert.Equal(t, ctx.Err(), err, "stock change not stopped")
+		_, err = db.priceCart(ctx, []CartItem{{ProduceCode: "2222-2222-2222-2222"}}, "")
+		assert.Equal(t, ctx.Err(), err, "cart pricing not stopped")
+	}
+	assert.True(t, before == db.catalog(), "catalog changed by stopped calls")
+}
+
 func TestValidateProduceItem(t *testing.T) {
+	t.Parallel()
 	var validateProduceItemTests = []struct {
 		desc           string
 		produceCode    string
@@ -118,10 +270,269 @@ func TestValidateProduceItem(t *testing.T) {
 		pItem.ProduceCode = item.produceCode
 		pItem.Name = item.name
 		pItem.UnitPrice = item.unitPrice
-		validErrs := pItem.validateProduceItem()
-		err := map[string]interface{}{"validationError": validErrs}
+		validErrs := pItem.validateProduceItem(&DBObject{})
+		err := ValidationError{validErrs}
 		response, _ := json.Marshal(err)
 		assert.Equal(t, item.expectedOutput, string(response), fmt.Sprintf("unexpected output for %s", item.desc))
 	}
 
-}
\ No newline at end of file
+}
+//type to store the model TestStoreMatchesModel checks the store against, the live items and the trash keyed by code
+type storeModel struct {
+	live  map[string]ProduceItem
+	trash map[string]ProduceItem
+}
+
+//checks that the store holds the items of the model and each code only once
+func (model storeModel) matches(t *testing.T, db *DBObject, desc string) bool {
+	live, trash := map[string]ProduceItem{}, map[string]ProduceItem{}
+	current := db.catalog()
+	for _, item := range current.Data {
+		if _, duplicate := live[item.ProduceCode]; duplicate {
+			t.Errorf("code %s stored twice after %s", item.ProduceCode, desc)
+			return false
+		}
+		live[item.ProduceCode] = item
+	}
+	for _, item := range current.Trash {
+		if _, duplicate := trash[item.ProduceCode]; duplicate {
+			t.Errorf("code %s trashed twice after %s", item.ProduceCode, desc)
+			return false
+		}
+		trash[item.ProduceCode] = item.ProduceItem
+	}
+	return assert.Equal(t, model.live, live, fmt.Sprintf("unexpected items after %s", desc)) &&
+		assert.Equal(t, model.trash, trash, fmt.Sprintf("unexpected trash after %s", desc))
+}
+
+//applies count random creates, updates, replaces, deletes and restores on a few codes to the store and the model,
+//stopping at the first step where the store and the model differ. Every step names its item differently and too
+//shortly to be a near duplicate of another name, so the model does not need to know about names.
+func runStoreModel(t *testing.T, seed int64, count int) {
+	codes := []string{"A12T-4GH7-QPL9-3N4M", "E5T6-9UI3-TH15-QR88", "1111-1111-1111-1111", "2222-2222-2222-2222", "3333-3333-3333-3333"}
+	random := rand.New(rand.NewSource(seed))
+	db := newTestDB(seedItems()[:2])
+	model := storeModel{live: map[string]ProduceItem{}, trash: map[string]ProduceItem{}}
+	for _, item := range db.catalog().Data {
+		model.live[item.ProduceCode] = item
+	}
+
+	ctx := context.Background()
+	for step := 0; step < count; step++ {
+		code := codes[random.Intn(len(codes))]
+		pItem := ProduceItem{ProduceCode: codes[random.Intn(len(codes))], Name: fmt.Sprintf("N%d", step), UnitPrice: "$1.00"}
+		_, exists := model.live[code]
+		_, clashes := model.live[pItem.ProduceCode]
+		var desc string
+		var result, expected interface{}
+		var err, expectedErr error
+
+		switch random.Intn(5) {
+		case 0:
+			desc = fmt.Sprintf("step %d of seed %d, create %s", step, seed, pItem.ProduceCode)
+			result, err = db.createProduceItem(ctx, pItem)
+			expected, expectedErr = ProduceItem{}, errProduceCodeExists
+			if !clashes {
+				model.live[pItem.ProduceCode], expected, expectedErr = pItem, pItem, nil
+			}
+		case 1:
+			desc = fmt.Sprintf("step %d of seed %d, update %s to %s", step, seed, code, pItem.ProduceCode)
+			result, err = db.updateProduceItem(ctx, code, pItem)
+			expected, expectedErr = ProduceItem{}, errProduceNotFound
+			if exists && clashes && code != pItem.ProduceCode {
+				expectedErr = errProduceCodeExists
+			} else if exists {
+				delete(model.live, code)
+				model.live[pItem.ProduceCode], expected, expectedErr = pItem, pItem, nil
+			}
+		case 2:
+			desc = fmt.Sprintf("step %d of seed %d, replace %s", step, seed, pItem.ProduceCode)
+			_, result, err = db.putProduceItem(ctx, pItem)
+			expected = !clashes
+			model.live[pItem.ProduceCode] = pItem
+		case 3:
+			desc = fmt.Sprintf("step %d of seed %d, delete %s", step, seed, code)
+			result, err = db.deleteProduceItem(ctx, code)
+			expected, expectedErr = ProduceItem{}, errProduceNotFound
+			if exists {
+				model.trash[code], expected, expectedErr = model.live[code], model.live[code], nil
+				delete(model.live, code)
+			}
+		case 4:
+			desc = fmt.Sprintf("step %d of seed %d, restore %s", step, seed, code)
+			result, err = db.restoreProduceItem(ctx, code)
+			expected, expectedErr = ProduceItem{}, errProduceNotFound
+			if trashed, ok := model.trash[code]; ok && exists {
+				expectedErr = errProduceCodeExists
+			} else if ok {
+				delete(model.trash, code)
+				model.live[code], expected, expectedErr = trashed, trashed, nil
+			}
+		}
+
+		if !assert.Equal(t, expected, result, fmt.Sprintf("unexpected result of %s", desc)) ||
+			!assert.Equal(t, expectedErr, err, fmt.Sprintf("unexpected error of %s", desc)) || !model.matches(t, db, desc) {
+			return
+		}
+	}
+}
+
+//test random sequences of changes against a model of the store, codes must stay unique and items only leave the store
+//and the trash when the model says so
+func TestStoreMatchesModel(t *testing.T) {
+	t.Parallel()
+	for seed := int64(1); seed <= 50; seed++ {
+		runStoreModel(t, seed, 200)
+	}
+}
+
+//benchmarks looking up an item the way handlers used to, through a goroutine that hands the item back on an unbuffered
+//channel, against calling the model directly
+func BenchmarkModelCall(b *testing.B) {
+	db := benchmarkDB(benchmarkCatalogSize)
+	ctx := context.Background()
+	pCode := benchmarkCode(benchmarkCatalogSize / 2)
+
+	b.Run("channel", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			pItemChnl := make(chan ProduceItem)
+			go func() {
+				pItem, _ := db.getProduceItem(ctx, pCode)
+				pItemChnl <- pItem
+			}()
+			<-pItemChnl
+		}
+	})
+	b.Run("direct", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			db.getProduceItem(ctx, pCode)
+		}
+	})
+}
+
+//test that concurrent patches never work on an outdated item. Every patch tests the price it read and raises it by a
+//dollar, a patch whose test fails reads the item again, so no raise may be lost.
+func TestPatchStoredItemConcurrent(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	ctx := context.Background()
+	db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$100"})
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for raises := 0; raises < 25; {
+				pItem, _ := db.getProduceItem(ctx, "2222-2222-2222-2222")
+				var price int
+				fmt.Sscanf(pItem.UnitPrice, "$%d", &price)
+				patch := fmt.Sprintf(`[{"op":"test","path":"/unit_price","value":%q},{"op":"replace","path":"/unit_price","value":"$%d"}]`,
+					pItem.UnitPrice, price+1)
+				_, err := db.patchStoredItem(ctx, "2222-2222-2222-2222", jsonPatchType, []byte(patch))
+				if _, conflict := err.(patchConflictError); conflict {
+					continue
+				}
+				if !assert.NoError(t, err, "patch failed") {
+					return
+				}
+				raises++
+			}
+		}()
+	}
+	wg.Wait()
+
+	pItem, _ := db.getProduceItem(ctx, "2222-2222-2222-2222")
+	assert.Equal(t, "$200", pItem.UnitPrice, "raise lost")
+}
+
+//test that the writers check the references of an item again under the write lock, so an item validated by a handler
+//before the data it refers to was removed is not stored
+func TestWritesCheckReferences(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var writeTests = []struct {
+		desc        string
+		write       func(db *DBObject) error
+		expectedErr error
+	}{
+		{"create with removed category", func(db *DBObject) error {
+			_, err := db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23", Category: "fruit"})
+			return err
+		}, referenceError{url.Values{"category": {"unknown category"}}}},
+		//
+		{"update with removed category", func(db *DBObject) error {
+			_, err := db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Category: "fruit"})
+			return err
+		}, referenceError{url.Values{"category": {"unknown category"}}}},
+		//
+		{"put with removed category", func(db *DBObject) error {
+			_, _, err := db.putProduceItem(ctx, ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Category: "fruit"})
+			return err
+		}, referenceError{url.Values{"category": {"unknown category"}}}},
+		//
+		{"restore with removed category", func(db *DBObject) error {
+			db.addCategory(Category{ID: "fruit", Name: "Fruit"})
+			db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Category: "fruit"})
+			db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+			db.removeCategory("fruit")
+			_, err := db.restoreProduceItem(ctx, "2222-2222-2222-2222")
+			return err
+		}, referenceError{url.Values{"category": {"unknown category"}}}},
+		//
+		{"create with taken barcode", func(db *DBObject) error {
+			db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Barcodes: []string{"4133"}})
+			_, err := db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23", Barcodes: []string{"4133"}})
+			return err
+		}, referenceError{url.Values{"barcodes": {`barcode "4133" already belongs to 2222-2222-2222-2222`}}}},
+		//
+		{"put with taken barcode", func(db *DBObject) error {
+			db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23", Barcodes: []string{"4133"}})
+			_, _, err := db.putProduceItem(ctx, ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Barcodes: []string{"4133"}})
+			return err
+		}, referenceError{url.Values{"barcodes": {`barcode "4133" already belongs to 1111-1111-1111-1111`}}}},
+		//
+		{"restore with barcode taken while trashed", func(db *DBObject) error {
+			db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Barcodes: []string{"4133"}})
+			db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+			db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23", Barcodes: []string{"4133"}})
+			_, err := db.restoreProduceItem(ctx, "2222-2222-2222-2222")
+			return err
+		}, referenceError{url.Values{"barcodes": {`barcode "4133" already belongs to 1111-1111-1111-1111`}}}},
+		//
+		{"update keeps own barcode", func(db *DBObject) error {
+			db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Barcodes: []string{"4133"}})
+			_, err := db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.99", Barcodes: []string{"4133"}})
+			return err
+		}, nil},
+		//
+		{"create with near duplicate name", func(db *DBObject) error {
+			_, err := db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Gala Apples", UnitPrice: "$1.23"})
+			return err
+		}, referenceError{url.Values{"name": {`name is too similar to "Gala Apple" of 2222-2222-2222-2222`}}}},
+		//
+		{"restore with name taken while trashed", func(db *DBObject) error {
+			db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+			db.createProduceItem(ctx, ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Gala Apples", UnitPrice: "$1.23"})
+			_, err := db.restoreProduceItem(ctx, "2222-2222-2222-2222")
+			return err
+		}, referenceError{url.Values{"name": {`name is too similar to "Gala Apples" of 1111-1111-1111-1111`}}}},
+		//
+		{"update keeps own name", func(db *DBObject) error {
+			_, err := db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.99"})
+			return err
+		}, nil},
+		//
+		{"update with existing category", func(db *DBObject) error {
+			db.addCategory(Category{ID: "fruit", Name: "Fruit"})
+			_, err := db.updateProduceItem(ctx, "2222-2222-2222-2222", ProduceItem{ProduceCode: "2222-2222-2222-2222", Name: "Gala Apple", UnitPrice: "$3.59", Category: "fruit"})
+			return err
+		}, nil},
+	}
+	for _, test := range writeTests {
+		db := newTestDB(nil)
+		assert.Equal(t, test.expectedErr, test.write(db), fmt.Sprintf("unexpected error for %s", test.desc))
+	}
+}
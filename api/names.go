@@ -0,0 +1,84 @@
+//contains normalization of produce item names and the detection of names that are near duplicates of each other
+package api
+
+import (
+	"fmt"
+	"golang.org/x/text/unicode/norm"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+//a word of a name, Unicode letters and digits with their combining marks, optionally followed by apostrophe parts
+//such as the 's of "Granny Smith's"
+const nameWord = `[\p{L}\p{N}][\p{L}\p{M}\p{N}]*(?:['’][\p{L}\p{M}\p{N}]*)*`
+
+//words of a name are separated by a single space or a hyphen
+var namePattern = regexp.MustCompile(`^` + nameWord + `(?:[ -]` + nameWord + `)*$`)
+
+//names whose folded forms are at least this long are also near duplicates when one letter apart
+const typoNameLength = 6
+
+//returns the name in NFC with leading and trailing whitespace removed and every run of whitespace inside it replaced
+//by a single space, so names typed or pasted differently are stored the same way
+func normalizeName(name string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(name)), " ")
+}
+
+//returns the form names are compared by for near duplicates: lower case, accents removed and everything but letters
+//and digits dropped, so "Jalapeño", "jalapeno" and "Jala-peño" share a form
+func foldName(name string) string {
+	var folded []rune
+	for _, char := range norm.NFD.String(name) {
+		if unicode.IsLetter(char) || unicode.IsDigit(char) {
+			folded = append(folded, unicode.ToLower(char))
+		}
+	}
+	return string(folded)
+}
+
+//checks if two names are near duplicates: their folded forms are equal or, for longer names, one edit apart
+func similarNames(first string, second string) bool {
+	a, b := []rune(foldName(first)), []rune(foldName(second))
+	if string(a) == string(b) {
+		return true
+	}
+	if len(a) < typoNameLength || len(b) < typoNameLength {
+		return false
+	}
+	return editDistance(a, b) <= 1
+}
+
+//returns the Levenshtein distance between a and b, the number of single letter insertions, deletions and
+//substitutions that turn one into the other
+func editDistance(a []rune, b []rune) int {
+	previous := make([]int, len(b)+1)
+	for index := range previous {
+		previous[index] = index
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+//adds an error to errs if the name of the item is a near duplicate of the name of another item of the catalog than
+//the one stored under pCode
+func validateItemName(current *catalog, pCode string, pItem *ProduceItem, errs url.Values) {
+	for _, item := range current.Data {
+		if item.ProduceCode != pCode && similarNames(item.Name, pItem.Name) {
+			errs.Add("name", fmt.Sprintf("name is too similar to %q of %s", item.Name, item.ProduceCode))
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+//tests for names.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNormalizeName(t *testing.T) {
+	t.Parallel()
+	var nameTests = []struct {
+		value    string
+		expected string
+	}{
+		{"Lettuce", "Lettuce"},
+		{"  Green   Pepper \t", "Green Pepper"},
+		{"Jalapen\u0303o", "Jalape\u00f1o"},
+		{"Bok\u00a0Choy", "Bok Choy"},
+		{"   ", ""},
+	}
+	for _, item := range nameTests {
+		assert.Equal(t, item.expected, normalizeName(item.value), fmt.Sprintf("On Name: `%s`", item.value))
+	}
+}
+
+func TestSimilarNames(t *testing.T) {
+	t.Parallel()
+	var nameTests = []struct {
+		first   string
+		second  string
+		similar bool
+	}{
+		{"Jalapeño", "jalapeno", true},
+		{"Bok Choy", "Bok-Choy", true},
+		{"Granny Smith's", "Granny Smiths", true},
+		{"Gala Apple", "Gala Apples", true},
+		{"Green Pepper", "Green Peper", true},
+		{"Pear", "Peas", false},
+		{"Lime", "Lima", false},
+		{"Gala Apple", "Fuji Apple", false},
+		{"Green Pepper", "Red Pepper", false},
+	}
+	for _, item := range nameTests {
+		assert.Equal(t, item.similar, similarNames(item.first, item.second), fmt.Sprintf("On Names: `%s`, `%s`", item.first, item.second))
+	}
+}
+
+func TestHandleNormalizedNames(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+
+	var nameTests = []struct {
+		desc         string
+		method       string
+		path         string
+		statusCode   int
+		pItemJSON    string
+		expectedBody string
+	}{
+		{"name normalized", "POST", "/api/produce", 201, "{\"produce_code\":\"1111-1111-1111-1111\",\"name\":\"  Jalapen\u0303o   Pepper \",\"unit_price\":\"$0.25\"}",
+			"{\"produce_code\":\"1111-1111-1111-1111\",\"name\":\"Jalapeño Pepper\",\"unit_price\":\"$0.25\"}"},
+		//
+		{"near duplicate without accent", "POST", "/api/produce", 400, `{"produce_code":"2222-1111-1111-1111","name":"jalapeno pepper","unit_price":"$0.25"}`,
+			"{\"validationError\":{\"name\":[\"name is too similar to \\\"Jalapeño Pepper\\\" of 1111-1111-1111-1111\"]}}"},
+		//
+		{"near duplicate plural", "POST", "/api/produce", 400, `{"produce_code":"2222-1111-1111-1111","name":"Gala Apples","unit_price":"$3.59"}`,
+			`{"validationError":{"name":["name is too similar to \"Gala Apple\" of 2222-2222-2222-2222"]}}`},
+		//
+		{"item keeps own name", "POST", "/api/produce/2222-2222-2222-2222", 200, `{"produce_code":"2222-2222-2222-2222","name":"Gala  Apple","unit_price":"$3.99"}`,
+			`{"produce_code":"2222-2222-2222-2222","name":"Gala Apple","unit_price":"$3.99"}`},
+		//
+		{"hyphen and apostrophe", "PUT", "/api/produce/3333-1111-1111-1111", 201, `{"name":"Granny Smith's Bok-Choy","unit_price":"$1.00"}`,
+			`{"produce_code":"3333-1111-1111-1111","name":"Granny Smith's Bok-Choy","unit_price":"$1.00"}`},
+	}
+
+	for _, item := range nameTests {
+		f.request(item.method, item.path, item.pItemJSON).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
@@ -0,0 +1,159 @@
+//contains the negotiation of response representations through the Accept and Accept-Encoding headers and the gzip
+//compression of responses
+package api
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//responses shorter than this are not worth compressing
+const gzipMinLength = 1024
+
+//gzip writers reused across responses, a new writer allocates its compression tables
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+//type to store an entry of an Accept or Accept-Encoding header along with its quality
+type acceptEntry struct {
+	value   string
+	quality float64
+}
+
+//parses the comma separated entries of an Accept or Accept-Encoding header. Values are lower cased, parameters other
+//than q are dropped and a quality that is missing or invalid counts as 1.
+func parseAccept(header string) []acceptEntry {
+	var entries []acceptEntry
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		entry := acceptEntry{value: strings.ToLower(strings.TrimSpace(params[0])), quality: 1}
+		if entry.value == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) != "q" {
+				continue
+			}
+			if quality, err := strconv.ParseFloat(value, 64); err == nil && quality >= 0 && quality <= 1 {
+				entry.quality = quality
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+//returns how specifically the entry matches the offer, 0 if it does not. A full match beats type/* which beats a
+//bare wildcard.
+func matchSpecificity(entry string, offer string) int {
+	switch {
+	case entry == offer:
+		return 3
+	case strings.HasSuffix(entry, "/*") && strings.HasPrefix(offer, strings.TrimSuffix(entry, "*")):
+		return 2
+	case entry == "*/*" || entry == "*":
+		return 1
+	}
+	return 0
+}
+
+//returns the offer the header gives the highest quality, the earliest offer wins ties. Each offer takes the quality of
+//the most specific entry that matches it. ok is false if the header accepts none of the offers. An empty header
+//accepts the first offer.
+func negotiate(header string, offers []string) (string, bool) {
+	entries := parseAccept(header)
+	if len(entries) == 0 {
+		return offers[0], true
+	}
+
+	best, bestQuality := "", 0.0
+	for _, offer := range offers {
+		quality, specificity := 0.0, 0
+		for _, entry := range entries {
+			if match := matchSpecificity(entry.value, offer); match > specificity {
+				quality, specificity = entry.quality, match
+			}
+		}
+		if quality > bestQuality {
+			best, bestQuality = offer, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+//compresses responses with gzip for clients whose Accept-Encoding accepts it. Only responses that declare a
+//Content-Length of at least gzipMinLength are compressed, so short error messages and streamed responses such as
+//Server-Sent Events are sent as they are and WebSocket upgrades are passed straight through. Brotli is not offered, the
+//standard library has no encoder for it, so clients that only accept br get uncompressed responses.
+func compressResponses(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if _, ok := negotiate(r.Header.Get("Accept-Encoding"), []string{"gzip"}); !ok || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+//type to decide whether to compress a response once its headers are written and compress its body if so
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+//compresses the response if its Content-Length is known and at least gzipMinLength and it is not encoded yet, then
+//writes the headers
+func (gw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	header := gw.Header()
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err == nil && length >= gzipMinLength && header.Get("Content-Encoding") == "" {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(statusCode)
+}
+
+//writes the body, compressed if WriteHeader decided to compress
+func (gw *gzipResponseWriter) Write(body []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(body)
+	}
+	return gw.ResponseWriter.Write(body)
+}
+
+//sends everything written so far to the client
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//finishes the compressed body and returns the gzip writer to the pool
+func (gw *gzipResponseWriter) close() {
+	if gw.gz != nil {
+		gw.gz.Close()
+		gzipWriters.Put(gw.gz)
+		gw.gz = nil
+	}
+}
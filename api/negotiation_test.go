@@ -0,0 +1,94 @@
+//tests for negotiation.go
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"testing"
+)
+
+//test that the offer preferred by the header is picked
+func TestNegotiate(t *testing.T) {
+	t.Parallel()
+	offers := []string{"application/json", "text/csv", "application/xml"}
+	tests := []struct {
+		header   string
+		expected string
+		ok       bool
+	}{
+		{"", "application/json", true},
+		{"text/csv", "text/csv", true},
+		{"TEXT/CSV", "text/csv", true},
+		{"*/*", "application/json", true},
+		{"application/*", "application/json", true},
+		{"text/csv;q=0.5, application/xml", "application/xml", true},
+		{"application/xml;q=0.2, text/*;q=0.8", "text/csv", true},
+		{"application/*;q=0.9, application/json;q=0.1", "application/xml", true},
+		{"*/*;q=0.1, text/csv;q=0", "application/json", true},
+		{"text/csv, application/xml", "text/csv", true},
+		{"text/csv;charset=utf-8;q=0.7, application/xml;q=0.6", "text/csv", true},
+		{"text/csv;q=abc", "text/csv", true},
+		{"text/html", "", false},
+		{"text/csv;q=0, application/*;q=0", "", false},
+		{" , ", "application/json", true},
+	}
+
+	for _, test := range tests {
+		offer, ok := negotiate(test.header, offers)
+		assert.Equal(t, test.ok, ok, fmt.Sprintf("unexpected result for %q", test.header))
+		assert.Equal(t, test.expected, offer, fmt.Sprintf("unexpected offer for %q", test.header))
+	}
+}
+
+//test that large responses are gzipped for clients that accept it and that other responses are sent as they are
+func TestCompressResponses(t *testing.T) {
+	t.Parallel()
+	seed := []ProduceItem{}
+	for index := 0; index < 30; index++ {
+		seed = append(seed, ProduceItem{ProduceCode: benchmarkCode(index), Name: "Item", UnitPrice: "$1.99"})
+	}
+	f := newFixture(t, seed)
+	plain := f.request("GET", "/api/produce", "")
+	assert.True(t, len(plain.Body) >= gzipMinLength, "listing too short to be compressed")
+
+	tests := []struct {
+		path           string
+		acceptEncoding string
+		compressed     bool
+	}{
+		{"/api/produce", "gzip", true},
+		{"/api/produce", "br;q=1.0, gzip;q=0.5", true},
+		{"/api/produce", "*", true},
+		{"/api/produce", "br", false},
+		{"/api/produce", "gzip;q=0", false},
+		{"/api/produce", "identity", false},
+		{"/api/produce/" + benchmarkCode(0), "gzip", false},
+		{"/api/produce/0000-0000-0000-0000", "gzip", false},
+	}
+
+	for _, test := range tests {
+		desc := fmt.Sprintf("%s with Accept-Encoding %q", test.path, test.acceptEncoding)
+		response := f.request("GET", test.path, "", "Accept-Encoding", test.acceptEncoding)
+		assert.Contains(t, response.Header.Values("Vary"), "Accept-Encoding", fmt.Sprintf("missing Vary for %s", desc))
+		if !test.compressed {
+			assert.Empty(t, response.Header.Get("Content-Encoding"), fmt.Sprintf("compressed %s", desc))
+			assert.Equal(t, fmt.Sprint(len(response.Body)), response.Header.Get("Content-Length"),
+				fmt.Sprintf("unexpected Content-Length for %s", desc))
+			continue
+		}
+
+		assert.Equal(t, "gzip", response.Header.Get("Content-Encoding"), fmt.Sprintf("not compressed %s", desc))
+		assert.NotEqual(t, fmt.Sprint(len(plain.Body)), response.Header.Get("Content-Length"),
+			fmt.Sprintf("uncompressed Content-Length kept for %s", desc))
+		reader, err := gzip.NewReader(bytes.NewReader([]byte(response.Body)))
+		if !assert.NoError(t, err, fmt.Sprintf("invalid gzip for %s", desc)) {
+			continue
+		}
+		body, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err, fmt.Sprintf("invalid gzip for %s", desc))
+		assert.Equal(t, plain.Body, string(body), fmt.Sprintf("unexpected body for %s", desc))
+	}
+}
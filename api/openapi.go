@@ -0,0 +1,462 @@
+//contains the OpenAPI 3 document of the API served at /openapi.json. The operations are listed here while the JSON
+//Schemas of the bodies are derived from the Go types by reflection, so a change to a type shows up in the document
+//without being written down twice. Along with the handler function that serves the document.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//version of the API reported in the OpenAPI document
+const apiVersion = "1.0.0"
+
+//type of the body of an error response written by http.Error, the value is an example of the message
+type plainText string
+
+//type to store a documented response of an operation. Body is a value of the Go type of the JSON body, a plainText
+//for a plain text body, a string for another content type of the given name or nil for a response without a body.
+type apiResponse struct {
+	status int
+	body   interface{}
+}
+
+//type to store a documented query parameter
+type apiParameter struct {
+	name        string
+	description string
+}
+
+//type to store a documented operation. Request is a value of the Go type of the JSON body or nil if there is none,
+//requestTypes are the content types the body is accepted in, application/json when left empty.
+type apiOperation struct {
+	method       string
+	path         string
+	summary      string
+	query        []apiParameter
+	request      interface{}
+	requestTypes []string
+	responses    []apiResponse
+}
+
+//descriptions of the path parameters, keyed by the name used in the route
+var pathParameters = map[string]string{
+	"produce_code": "produce code of the item",
+	"barcode":      "PLU, UPC-A or EAN-13 barcode of the item",
+	"category_id":  "id of the category",
+	"store_id":     "id of the store",
+	"id":           "id of the promotion, webhook or dead letter",
+}
+
+//responses shared by many operations
+var (
+	invalidCode    = apiResponse{400, plainText("error 400 - invalid produce code format")}
+	invalidJSON    = apiResponse{400, plainText("error 400 - invalid JSON syntax")}
+	invalidFields  = apiResponse{400, ValidationError{}}
+	unknownCode    = apiResponse{404, plainText("error 404 - produce code does not exist")}
+	unknownStore   = apiResponse{404, plainText("error 404 - store does not exist")}
+	unknownTenant  = apiResponse{404, plainText("error 404 - tenant does not exist")}
+	tenantMismatch = apiResponse{400, plainText("error 400 - tenant header does not match host")}
+	tenantKey      = apiResponse{401, plainText("error 401 - missing or invalid tenant api key")}
+	adminKey       = apiResponse{401, plainText("error 401 - missing or invalid admin api key")}
+)
+
+//every operation of the API, each route registered in registerRoutes has an entry here
+var apiOperations = []apiOperation{
+	{method: "GET", path: "/openapi.json", summary: "Returns this document",
+		responses: []apiResponse{{200, map[string]interface{}{}}}},
+	{method: "GET", path: "/api/produce", summary: "Lists the produce items",
+		query: []apiParameter{
+			{"category", "only list items in this category or a category below it"},
+			{"include_deleted", "true to also list the items in the trash, each with its deleted_at time"}},
+		responses: []apiResponse{{200, []ProduceItem{}}, {200, "text/csv"}, {200, "application/xml"}, {200, "application/msgpack"},
+			{404, plainText("error 404 - category does not exist")},
+			{406, plainText("error 406 - produce can only be listed as " + strings.Join(listingFormats, ", "))}}},
+	{method: "POST", path: "/api/produce", summary: "Creates a produce item, a code is generated when it is left out",
+		request:   ProduceItem{},
+		responses: []apiResponse{{201, ProduceItem{}}, invalidJSON, invalidFields, {409, plainText("error 409 - produce code already exists")}}},
+	{method: "GET", path: "/api/produce/trash", summary: "Lists the deleted items that can still be restored",
+		responses: []apiResponse{{200, []TrashedItem{}}}},
+	{method: "POST", path: "/api/produce/trash/{produce_code}/restore", summary: "Restores a deleted item",
+		responses: []apiResponse{{200, ProduceItem{}}, invalidCode, {404, plainText("error 404 - produce code not found in trash")},
+			{409, plainText("error 409 - produce code already exists")}}},
+	{method: "DELETE", path: "/api/produce/trash/{produce_code}", summary: "Purges a deleted item for good",
+		responses: []apiResponse{{200, ProduceItem{}}, invalidCode, {404, plainText("error 404 - produce code not found in trash")}}},
+	{method: "GET", path: "/api/produce/events", summary: "Streams catalog changes as Server-Sent Events",
+		query:     []apiParameter{{"since", "sequence number of the last event received, Last-Event-ID takes precedence"}},
+		responses: []apiResponse{{200, "text/event-stream"}, {400, plainText("error 400 - invalid event sequence number")}}},
+	{method: "GET", path: "/api/produce/events/ws", summary: "Streams catalog changes over a WebSocket",
+		query: []apiParameter{{"since", "sequence number of the last event received, Last-Event-ID takes precedence"}},
+		responses: []apiResponse{{101, nil}, {400, plainText("error 400 - websocket upgrade required")},
+			{426, plainText("error 426 - unsupported websocket version")}}},
+	{method: "GET", path: "/api/produce/{produce_code}", summary: "Returns a produce item with its promotional price",
+		responses: []apiResponse{{200, pricedItem{}}, invalidCode, unknownCode}},
+	{method: "POST", path: "/api/produce/{produce_code}", summary: "Updates a produce item, its code may be changed",
+		request: ProduceItem{},
+		responses: []apiResponse{{200, ProduceItem{}}, invalidCode, invalidJSON, invalidFields, unknownCode,
+			{409, plainText("error 409 - updated produce code value already exists")}}},
+	{method: "PUT", path: "/api/produce/{produce_code}", summary: "Replaces or creates the produce item of the code",
+		request: ProduceItem{},
+		responses: []apiResponse{{200, ProduceItem{}}, {201, ProduceItem{}}, invalidCode, invalidJSON, invalidFields,
+			{400, plainText("error 400 - produce code in body does not match URL")}}},
+	{method: "PATCH", path: "/api/produce/{produce_code}", summary: "Patches a produce item with a merge patch or JSON Patch",
+		request: ProduceItem{}, requestTypes: []string{mergePatchType, "application/json"},
+		responses: []apiResponse{{200, ProduceItem{}}, invalidCode, invalidFields, {400, plainText("error 400 - unable to read request body")},
+			unknownCode, {409, plainText("error 409 - updated produce code value already exists")},
+			{415, plainText("error 415 - " + errUnsupportedPatchType.Error())}}},
+	{method: "DELETE", path: "/api/produce/{produce_code}", summary: "Moves a produce item into the trash",
+		responses: []apiResponse{{200, ProduceItem{}}, invalidCode, {404, plainText("error 404 - produce code not found.")}}},
+	{method: "GET", path: "/api/produce/{produce_code}/price", summary: "Prices a quantity of a produce item",
+		query:     []apiParameter{{"quantity", "quantity to price, 1 when left out"}, {"unit", "unit of the quantity, the price unit when left out"}},
+		responses: []apiResponse{{200, PriceQuote{}}, invalidCode, {400, plainText("error 400 - " + errInvalidQuantity.Error())}, unknownCode}},
+	{method: "GET", path: "/api/produce/{produce_code}/inventory", summary: "Returns the stock level of a produce item",
+		responses: []apiResponse{{200, StockLevel{}}, invalidCode, unknownCode}},
+	{method: "PUT", path: "/api/produce/{produce_code}/inventory", summary: "Replaces the stock level of a produce item",
+		request:   StockLevel{},
+		responses: []apiResponse{{200, StockLevel{}}, invalidCode, invalidJSON, invalidFields, unknownCode}},
+	{method: "POST", path: "/api/produce/{produce_code}/inventory/receive", summary: "Adds received stock",
+		request: stockMovement{},
+		responses: []apiResponse{{200, StockLevel{}}, invalidCode, invalidJSON, invalidFields,
+			{400, plainText("error 400 - " + errUnitMismatch.Error())}, unknownCode}},
+	{method: "POST", path: "/api/produce/{produce_code}/inventory/adjust", summary: "Corrects the stock by a positive or negative quantity",
+		request: stockMovement{},
+		responses: []apiResponse{{200, StockLevel{}}, invalidCode, invalidJSON, invalidFields,
+			{400, plainText("error 400 - " + errUnitMismatch.Error())}, unknownCode,
+			{409, plainText("error 409 - " + errInsufficientStock.Error())}}},
+	{method: "POST", path: "/api/produce/{produce_code}/inventory/sale", summary: "Removes sold stock",
+		request: stockMovement{},
+		responses: []apiResponse{{200, StockLevel{}}, invalidCode, invalidJSON, invalidFields,
+			{400, plainText("error 400 - " + errUnitMismatch.Error())}, unknownCode,
+			{409, plainText("error 409 - " + errInsufficientStock.Error())}}},
+	{method: "GET", path: "/api/barcodes/{barcode}", summary: "Looks up the produce item of a barcode",
+		responses: []apiResponse{{200, pricedItem{}}, {400, plainText("error 400 - invalid barcode")},
+			{404, plainText("error 404 - barcode does not exist")}}},
+	{method: "POST", path: "/api/cart", summary: "Prices a cart of items with promotions and tax",
+		request:   CartRequest{},
+		responses: []apiResponse{{200, CartTotals{}}, invalidJSON, {400, plainText("error 400 - cart has no items")}}},
+	{method: "GET", path: "/api/tax", summary: "Returns the tax table",
+		query:     []apiParameter{{"jurisdiction", "jurisdiction of the table, the active table when left out"}},
+		responses: []apiResponse{{200, TaxTable{}}, {404, plainText("error 404 - jurisdiction has no tax table")}}},
+	{method: "GET", path: "/api/rules", summary: "Returns the validation rules of the tenant",
+		responses: []apiResponse{{200, RuleSet{}}}},
+	{method: "PUT", path: "/api/rules", summary: "Replaces the validation rules of the tenant",
+		request:   RuleSet{},
+		responses: []apiResponse{{200, RuleSet{}}, {400, plainText(`error 400 - unknown field "colour"`)}, adminKey}},
+	{method: "GET", path: "/api/promotions", summary: "Lists the promotions",
+		query:     []apiParameter{{"active", "true to only list the promotions running now"}},
+		responses: []apiResponse{{200, []Promotion{}}}},
+	{method: "POST", path: "/api/promotions", summary: "Creates a promotion",
+		request:   Promotion{},
+		responses: []apiResponse{{201, Promotion{}}, invalidJSON, invalidFields}},
+	{method: "GET", path: "/api/promotions/{id}", summary: "Returns a promotion",
+		responses: []apiResponse{{200, Promotion{}}, {404, plainText("error 404 - promotion does not exist")}}},
+	{method: "PUT", path: "/api/promotions/{id}", summary: "Replaces a promotion",
+		request:   Promotion{},
+		responses: []apiResponse{{200, Promotion{}}, invalidJSON, invalidFields, {404, plainText("error 404 - promotion does not exist")}}},
+	{method: "DELETE", path: "/api/promotions/{id}", summary: "Deletes a promotion",
+		responses: []apiResponse{{200, Promotion{}}, {404, plainText("error 404 - promotion does not exist")}}},
+	{method: "GET", path: "/api/categories", summary: "Lists the categories",
+		responses: []apiResponse{{200, []Category{}}}},
+	{method: "POST", path: "/api/categories", summary: "Creates a category",
+		request: Category{},
+		responses: []apiResponse{{201, Category{}}, invalidJSON, invalidFields,
+			{409, plainText("error 409 - " + errCategoryExists.Error())}}},
+	{method: "GET", path: "/api/categories/{category_id}", summary: "Returns a category",
+		responses: []apiResponse{{200, Category{}}, {404, plainText("error 404 - " + errCategoryNotFound.Error())}}},
+	{method: "PUT", path: "/api/categories/{category_id}", summary: "Renames or moves a category",
+		request: Category{},
+		responses: []apiResponse{{200, Category{}}, invalidJSON, invalidFields,
+			{400, plainText("error 400 - category id in body does not match URL")},
+			{404, plainText("error 404 - " + errCategoryNotFound.Error())}}},
+	{method: "DELETE", path: "/api/categories/{category_id}", summary: "Deletes a category without subcategories or items",
+		responses: []apiResponse{{200, Category{}}, {404, plainText("error 404 - " + errCategoryNotFound.Error())},
+			{409, plainText("error 409 - " + errCategoryHasChild.Error())}}},
+	{method: "GET", path: "/api/stores", summary: "Lists the stores",
+		responses: []apiResponse{{200, []Store{}}}},
+	{method: "POST", path: "/api/stores", summary: "Creates a store",
+		request:   Store{},
+		responses: []apiResponse{{201, Store{}}, invalidJSON, invalidFields, {409, plainText("error 409 - store id already exists")}}},
+	{method: "GET", path: "/api/stores/{store_id}", summary: "Returns a store",
+		responses: []apiResponse{{200, Store{}}, unknownStore}},
+	{method: "DELETE", path: "/api/stores/{store_id}", summary: "Deletes a store along with its overrides",
+		responses: []apiResponse{{200, Store{}}, unknownStore}},
+	{method: "GET", path: "/api/stores/{store_id}/produce", summary: "Lists the produce items as priced in a store",
+		query:     []apiParameter{{"available", "true to only list the items the store sells"}},
+		responses: []apiResponse{{200, []StoreItem{}}, unknownStore}},
+	{method: "POST", path: "/api/stores/{store_id}/cart", summary: "Prices a cart of items as sold in a store",
+		request:   CartRequest{},
+		responses: []apiResponse{{200, CartTotals{}}, invalidJSON, {400, plainText("error 400 - cart has no items")}, unknownStore}},
+	{method: "GET", path: "/api/stores/{store_id}/rules", summary: "Returns the validation rules of a store",
+		responses: []apiResponse{{200, RuleSet{}}, unknownStore}},
+	{method: "PUT", path: "/api/stores/{store_id}/rules", summary: "Replaces the validation rules of a store",
+		request:   RuleSet{},
+		responses: []apiResponse{{200, RuleSet{}}, {400, plainText(`error 400 - unknown field "colour"`)}, unknownStore, adminKey}},
+	{method: "GET", path: "/api/stores/{store_id}/produce/{produce_code}", summary: "Returns a produce item as priced in a store",
+		responses: []apiResponse{{200, StoreItem{}}, invalidCode, unknownStore, unknownCode}},
+	{method: "PUT", path: "/api/stores/{store_id}/produce/{produce_code}", summary: "Overrides the price or availability of an item in a store",
+		request:   StoreOverride{},
+		responses: []apiResponse{{200, StoreItem{}}, invalidCode, invalidJSON, invalidFields, unknownStore, unknownCode}},
+	{method: "DELETE", path: "/api/stores/{store_id}/produce/{produce_code}", summary: "Removes the override of an item in a store",
+		responses: []apiResponse{{200, StoreOverride{}}, {404, plainText("error 404 - store override does not exist")}}},
+	{method: "GET", path: "/api/webhooks", summary: "Lists the webhook subscriptions",
+		responses: []apiResponse{{200, []WebhookSubscription{}}}},
+	{method: "POST", path: "/api/webhooks", summary: "Subscribes a URL to catalog changes",
+		request:   WebhookSubscription{},
+		responses: []apiResponse{{201, WebhookSubscription{}}, invalidJSON, invalidFields}},
+	{method: "GET", path: "/api/webhooks/deadletters", summary: "Lists the deliveries that failed for good",
+		responses: []apiResponse{{200, []DeadLetter{}}}},
+	{method: "POST", path: "/api/webhooks/deadletters/{id}/retry", summary: "Queues a failed delivery again",
+		responses: []apiResponse{{202, DeadLetter{}}, {404, plainText("error 404 - dead letter does not exist")}}},
+	{method: "GET", path: "/api/webhooks/{id}", summary: "Returns a webhook subscription",
+		responses: []apiResponse{{200, WebhookSubscription{}}, {404, plainText("error 404 - webhook does not exist")}}},
+	{method: "DELETE", path: "/api/webhooks/{id}", summary: "Removes a webhook subscription",
+		responses: []apiResponse{{200, WebhookSubscription{}}, {404, plainText("error 404 - webhook does not exist")}}},
+}
+
+//type to build the JSON Schemas of Go types. Named struct types are added to schemas once and referred to by $ref.
+type schemaBuilder struct {
+	schemas map[string]interface{}
+}
+
+//returns the JSON Schema of values of the type as encoding/json writes and reads them
+func (builder *schemaBuilder) schemaOf(goType reflect.Type) map[string]interface{} {
+	switch goType {
+	case reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.Number("")):
+		return map[string]interface{}{"type": []string{"number", "string"}}
+	case reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+
+	switch goType.Kind() {
+	case reflect.Ptr:
+		return builder.schemaOf(goType.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": builder.schemaOf(goType.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": builder.schemaOf(goType.Elem())}
+	case reflect.Struct:
+		if goType.Name() == "" {
+			return builder.structSchema(goType)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + builder.define(goType)}
+	}
+	return map[string]interface{}{}
+}
+
+//adds the schema of the named struct type to the components if it is not there yet and returns its name, which is
+//the name of the type starting with an upper case letter
+func (builder *schemaBuilder) define(goType reflect.Type) string {
+	name := strings.ToUpper(goType.Name()[:1]) + goType.Name()[1:]
+	if _, ok := builder.schemas[name]; !ok {
+		builder.schemas[name] = nil //reserve the name so recursive types end
+		builder.schemas[name] = builder.structSchema(goType)
+	}
+	return name
+}
+
+//returns the schema of an object of the struct type
+func (builder *schemaBuilder) structSchema(goType reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	builder.addProperties(goType, properties, &required)
+
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+//adds a property for every field encoding/json writes of the struct type, the fields of embedded structs included.
+//The validate tags of the fields become constraints and a field is required if its tag says so. A slice, map or
+//pointer field without omitempty may be null.
+func (builder *schemaBuilder) addProperties(goType reflect.Type, properties map[string]interface{}, required *[]string) {
+	rules := map[string]FieldRule{}
+	for _, rule := range mustTagRules(goType) {
+		rules[rule.Field] = rule
+	}
+
+	for index := 0; index < goType.NumField(); index++ {
+		field := goType.Field(index)
+		options := strings.Split(field.Tag.Get("json"), ",")
+		name := options[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			builder.addProperties(field.Type, properties, required)
+			continue
+		}
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := builder.schemaOf(field.Type)
+		if kind := field.Type.Kind(); !containsAll(options[1:], []string{"omitempty"}) &&
+			(kind == reflect.Slice || kind == reflect.Map || kind == reflect.Ptr) {
+			schema = map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+		}
+		if rule, ok := rules[name]; ok {
+			addRuleConstraints(schema, rule)
+			if rule.Required {
+				*required = append(*required, name)
+			}
+		}
+		properties[name] = schema
+	}
+}
+
+//adds the checks of the rule that JSON Schema can express to the schema of its field. A format is named after the
+//format of the rule, lengths of a list limit its number of items and the other checks apply to the items.
+func addRuleConstraints(schema map[string]interface{}, rule FieldRule) {
+	values := schema
+	minKey, maxKey := "minLength", "maxLength"
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		values = items
+		minKey, maxKey = "minItems", "maxItems"
+	}
+
+	if rule.MinLength > 0 {
+		schema[minKey] = rule.MinLength
+	} else if rule.Required {
+		schema[minKey] = 1
+	}
+	if rule.MaxLength > 0 {
+		schema[maxKey] = rule.MaxLength
+	}
+	if rule.Format != "" {
+		values["format"] = rule.Format
+	}
+	if rule.Pattern != "" {
+		values["pattern"] = rule.Pattern
+	}
+	if len(rule.Enum) > 0 {
+		values["enum"] = rule.Enum
+	}
+}
+
+//returns the OpenAPI operation object of the operation, adding the schemas it refers to to the builder
+func (builder *schemaBuilder) operation(op apiOperation) map[string]interface{} {
+	parameters := []interface{}{map[string]interface{}{"$ref": "#/components/parameters/tenant"}}
+	for _, segment := range strings.Split(op.path, "/") {
+		if strings.HasPrefix(segment, "{") {
+			name := strings.Trim(segment, "{}")
+			parameters = append(parameters, map[string]interface{}{"name": name, "in": "path", "required": true,
+				"description": pathParameters[name], "schema": map[string]interface{}{"type": "string"}})
+		}
+	}
+	for _, param := range op.query {
+		parameters = append(parameters, map[string]interface{}{"name": param.name, "in": "query",
+			"description": param.description, "schema": map[string]interface{}{"type": "string"}})
+	}
+
+	responses := map[string]interface{}{}
+	for _, response := range append(append([]apiResponse{}, op.responses...), tenantMismatch, tenantKey, unknownTenant) {
+		builder.addResponse(responses, response)
+	}
+
+	operation := map[string]interface{}{"summary": op.summary, "parameters": parameters, "responses": responses}
+	if op.request != nil {
+		requestTypes := op.requestTypes
+		if len(requestTypes) == 0 {
+			requestTypes = []string{"application/json"}
+		}
+		content := map[string]interface{}{}
+		for _, contentType := range requestTypes {
+			content[contentType] = map[string]interface{}{"schema": builder.schemaOf(reflect.TypeOf(op.request))}
+		}
+		if op.method == "PATCH" {
+			content[jsonPatchType] = map[string]interface{}{"schema": builder.schemaOf(reflect.TypeOf([]patchOperation{}))}
+		}
+		operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	}
+	return operation
+}
+
+//adds the response to the response objects of an operation, keyed by status code. Responses of the same status are
+//merged, the messages of plain text errors are collected as examples.
+func (builder *schemaBuilder) addResponse(responses map[string]interface{}, response apiResponse) {
+	key := strconv.Itoa(response.status)
+	entry, ok := responses[key].(map[string]interface{})
+	if !ok {
+		entry = map[string]interface{}{"description": http.StatusText(response.status)}
+		responses[key] = entry
+	}
+	if response.body == nil {
+		return
+	}
+	content, ok := entry["content"].(map[string]interface{})
+	if !ok {
+		content = map[string]interface{}{}
+		entry["content"] = content
+	}
+
+	switch body := response.body.(type) {
+	case plainText:
+		media, ok := content["text/plain"].(map[string]interface{})
+		if !ok {
+			prefix := fmt.Sprintf("error %d - ", response.status)
+			media = map[string]interface{}{"schema": map[string]interface{}{"type": "string", "pattern": "^" + prefix}}
+			content["text/plain"] = media
+		}
+		schema := media["schema"].(map[string]interface{})
+		examples, _ := schema["examples"].([]string)
+		schema["examples"] = append(examples, string(body))
+	case string:
+		content[body] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+	default:
+		content["application/json"] = map[string]interface{}{"schema": builder.schemaOf(reflect.TypeOf(body))}
+	}
+}
+
+//returns the OpenAPI document of the API
+func openAPIDocument() map[string]interface{} {
+	builder := schemaBuilder{schemas: map[string]interface{}{}}
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		pathItem, ok := paths[op.path].(map[string]interface{})
+		if !ok {
+			pathItem = map[string]interface{}{}
+			paths[op.path] = pathItem
+		}
+		pathItem[strings.ToLower(op.method)] = builder.operation(op)
+	}
+	builder.schemaOf(reflect.TypeOf(ValidationError{}))
+
+	tenant := map[string]interface{}{"name": tenantHeader, "in": "header", "schema": map[string]interface{}{"type": "string"},
+		"description": "tenant whose data is used, the default data when left out or taken from the subdomain. Requests " +
+			"for a tenant must carry its api key as a bearer token."}
+	return map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{"title": "Supermarket REST API", "version": apiVersion,
+			"description": "API to manage the produce of a grocery store"},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":    builder.schemas,
+			"parameters": map[string]interface{}{"tenant": tenant},
+		},
+	}
+}
+
+//This function returns the OpenAPI 3 document of the API with a 200 status code. The JSON Schemas of the bodies in
+//components/schemas are derived from the Go types, ProduceItem and ValidationError among them.
+func handleGetOpenAPI(w http.ResponseWriter, _ *http.Request) {
+	jsonResponse(w, http.StatusOK, openAPIDocument())
+}
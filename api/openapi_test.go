@@ -0,0 +1,315 @@
+//tests for openapi.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"mime"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+//fetches the OpenAPI document from the fixture server
+func fetchOpenAPI(f *testFixture) map[string]interface{} {
+	var doc map[string]interface{}
+	f.request("GET", "/openapi.json", "").decode(f.t, &doc)
+	return doc
+}
+
+//returns the errors of value against the JSON Schema, resolving $ref in doc. Supports the keywords the document uses.
+func schemaErrors(doc map[string]interface{}, schema map[string]interface{}, value interface{}, at string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		target, ok := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unresolved $ref %s", at, ref)}
+		}
+		return schemaErrors(doc, target, value, at)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		for _, option := range anyOf {
+			if len(schemaErrors(doc, option.(map[string]interface{}), value, at)) == 0 {
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("%s: matches no schema of anyOf", at)}
+	}
+
+	var errs []string
+	if types, ok := schema["type"]; ok {
+		var allowed []interface{}
+		if list, ok := types.([]interface{}); ok {
+			allowed = list
+		} else {
+			allowed = []interface{}{types}
+		}
+		matched := false
+		for _, name := range allowed {
+			matched = matched || isJSONType(name.(string), value)
+		}
+		if !matched {
+			return []string{fmt.Sprintf("%s: %v is not of type %v", at, value, types)}
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: missing required property %s", at, name))
+			}
+		}
+		for name, property := range value {
+			if propertySchema, ok := properties[name].(map[string]interface{}); ok {
+				errs = append(errs, schemaErrors(doc, propertySchema, property, at+"/"+name)...)
+			} else if additional, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				errs = append(errs, schemaErrors(doc, additional, property, at+"/"+name)...)
+			}
+		}
+	case []interface{}:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(value)) < minItems {
+			errs = append(errs, fmt.Sprintf("%s: fewer than %v items", at, minItems))
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(value)) > maxItems {
+			errs = append(errs, fmt.Sprintf("%s: more than %v items", at, maxItems))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for index, item := range value {
+				errs = append(errs, schemaErrors(doc, items, item, at+"/"+strconv.Itoa(index))...)
+			}
+		}
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(utf8.RuneCountInString(value)) < minLength {
+			errs = append(errs, fmt.Sprintf("%s: %q is shorter than %v", at, value, minLength))
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && float64(utf8.RuneCountInString(value)) > maxLength {
+			errs = append(errs, fmt.Sprintf("%s: %q is longer than %v", at, value, maxLength))
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(value) {
+			errs = append(errs, fmt.Sprintf("%s: %q does not match %s", at, value, pattern))
+		}
+		if format, ok := schema["format"].(string); ok && !matchesFormat(format, value) {
+			errs = append(errs, fmt.Sprintf("%s: %q is not a %s", at, value, format))
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+			errs = append(errs, fmt.Sprintf("%s: %v is below %v", at, value, minimum))
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			found = found || option == value
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+	return errs
+}
+
+//checks if a decoded JSON value is of the JSON Schema type
+func isJSONType(name string, value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case float64:
+		return name == "number" || (name == "integer" && value == math.Trunc(value))
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+//checks a string against a format of the document, the named formats of the validation rules included
+func matchesFormat(format string, value string) bool {
+	if format == "date-time" {
+		_, err := time.Parse(time.RFC3339, value)
+		return err == nil
+	}
+	if named, ok := fieldFormats[format]; ok {
+		return named.check(&DBObject{}, value)
+	}
+	return true
+}
+
+//test that every registered route is documented and that nothing else is
+func TestOpenAPIRoutes(t *testing.T) {
+	t.Parallel()
+	doc := fetchOpenAPI(newFixture(t, nil))
+
+	var documented []string
+	for path, pathItem := range doc["paths"].(map[string]interface{}) {
+		for method := range pathItem.(map[string]interface{}) {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var registered []string
+	Handlers().Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, _ := route.GetPathTemplate()
+		methods, _ := route.GetMethods()
+		for _, method := range methods {
+			registered = append(registered, method+" "+path)
+		}
+		return nil
+	})
+
+	sort.Strings(documented)
+	sort.Strings(registered)
+	assert.Equal(t, registered, documented, "documented operations do not match the routes")
+	assert.Equal(t, "3.1.0", doc["openapi"], "unexpected OpenAPI version")
+}
+
+//test that the schemas follow the Go types and their validate tags
+func TestOpenAPISchemas(t *testing.T) {
+	t.Parallel()
+	schemas := fetchOpenAPI(newFixture(t, nil))["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+
+	produceItem := schemas["ProduceItem"].(map[string]interface{})
+	properties := produceItem["properties"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"produce_code", "name", "unit_price"}, produceItem["required"], "unexpected required fields")
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "produce_code", "minLength": 1.0}, properties["produce_code"],
+		"unexpected produce code schema")
+	assert.Equal(t, map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}, properties["barcodes"],
+		"unexpected barcodes schema")
+	assert.Equal(t, map[string]interface{}{"$ref": "#/components/schemas/StockLevel"}, properties["stock"], "unexpected stock schema")
+
+	trashedItem := schemas["TrashedItem"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, trashedItem, "name", "embedded fields not flattened")
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time"}, trashedItem["deleted_at"], "unexpected time schema")
+
+	validationError := schemas["ValidationError"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"anyOf": []interface{}{
+		map[string]interface{}{"type": "object", "additionalProperties": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}}},
+		map[string]interface{}{"type": "null"}}}, validationError["validationError"], "unexpected validation error schema")
+}
+
+//test that live responses match the documented status codes, content types and schemas
+func TestOpenAPIResponses(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+	f.db.adminKey = "admin-key"
+	doc := fetchOpenAPI(f)
+
+	var responseTests = []struct {
+		method      string
+		path        string
+		url         string
+		contentType string
+		body        string
+		statusCode  int
+	}{
+		{"GET", "/openapi.json", "/openapi.json", "", "", 200},
+		{"GET", "/api/produce", "/api/produce", "", "", 200},
+		{"GET", "/api/produce", "/api/produce?category=none", "", "", 404},
+		{"GET", "/api/produce/{produce_code}", "/api/produce/A12T-4GH7-QPL9-3N4M", "", "", 200},
+		{"GET", "/api/produce/{produce_code}", "/api/produce/a12t", "", "", 400},
+		{"GET", "/api/produce/{produce_code}", "/api/produce/1234-1234-1234-1234", "", "", 404},
+		{"POST", "/api/produce", "/api/produce", "application/json", `{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$1.99","barcodes":["4627"]}`, 201},
+		{"POST", "/api/produce", "/api/produce", "application/json", `{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$1.99"}`, 409},
+		{"POST", "/api/produce", "/api/produce", "application/json", `{"produce_code":"1111-1111-1111","name":"K@le","unit_price":"1.999"}`, 400},
+		{"POST", "/api/produce", "/api/produce", "application/json", `{"name":`, 400},
+		{"PUT", "/api/produce/{produce_code}", "/api/produce/3333-3333-3333-3333", "application/json", `{"name":"Bok Choy","unit_price":"$2.49"}`, 201},
+		{"PATCH", "/api/produce/{produce_code}", "/api/produce/3333-3333-3333-3333", mergePatchType, `{"price_unit":"lb"}`, 200},
+		{"PATCH", "/api/produce/{produce_code}", "/api/produce/3333-3333-3333-3333", "text/plain", `{}`, 415},
+		{"DELETE", "/api/produce/{produce_code}", "/api/produce/3333-3333-3333-3333", "", "", 200},
+		{"GET", "/api/produce", "/api/produce?include_deleted=true", "", "", 200},
+		{"GET", "/api/produce/trash", "/api/produce/trash", "", "", 200},
+		{"POST", "/api/produce/trash/{produce_code}/restore", "/api/produce/trash/3333-3333-3333-3333/restore", "", "", 200},
+		{"GET", "/api/produce/{produce_code}/price", "/api/produce/3333-3333-3333-3333/price?quantity=2&unit=kg", "", "", 200},
+		{"POST", "/api/produce/{produce_code}/inventory/receive", "/api/produce/1111-1111-1111-1111/inventory/receive", "application/json", `{"quantity":12}`, 200},
+		{"POST", "/api/produce/{produce_code}/inventory/sale", "/api/produce/1111-1111-1111-1111/inventory/sale", "application/json", `{"quantity":20}`, 409},
+		{"GET", "/api/produce/{produce_code}/inventory", "/api/produce/1111-1111-1111-1111/inventory", "", "", 200},
+		{"GET", "/api/barcodes/{barcode}", "/api/barcodes/4627", "", "", 200},
+		{"POST", "/api/cart", "/api/cart", "application/json", `{"items":[{"produce_code":"1111-1111-1111-1111","quantity":2},{"produce_code":"0000-0000-0000-0000"}]}`, 200},
+		{"GET", "/api/tax", "/api/tax", "", "", 200},
+		{"PUT", "/api/rules", "/api/rules", "application/json", `{"rules":[{"field":"name","max_length":40,"when":{"field":"category"}}]}`, 200},
+		{"GET", "/api/rules", "/api/rules", "", "", 200},
+		{"GET", "/api/stores/{store_id}/rules", "/api/stores/none/rules", "", "", 404},
+		{"PUT", "/api/stores/{store_id}/rules", "/api/stores/none/rules", "application/json", `{"rules":[]}`, 404},
+		{"GET", "/api/promotions", "/api/promotions", "", "", 200},
+		{"GET", "/api/categories", "/api/categories", "", "", 200},
+		{"GET", "/api/categories/{category_id}", "/api/categories/none", "", "", 404},
+		{"GET", "/api/stores", "/api/stores", "", "", 200},
+		{"GET", "/api/stores/{store_id}/produce", "/api/stores/none/produce", "", "", 404},
+		{"GET", "/api/webhooks", "/api/webhooks", "", "", 200},
+		{"GET", "/api/webhooks/deadletters", "/api/webhooks/deadletters", "", "", 200},
+	}
+
+	for _, item := range responseTests {
+		desc := item.method + " " + item.url
+		headers := []string{"Authorization", "Bearer admin-key"}
+		if item.contentType != "" {
+			headers = append(headers, "Content-Type", item.contentType)
+		}
+		response := f.request(item.method, item.url, item.body, headers...)
+		if !assert.Equal(t, item.statusCode, response.StatusCode, fmt.Sprintf("unexpected status code for %s: %s", desc, response.Body)) {
+			continue
+		}
+
+		operation, _ := doc["paths"].(map[string]interface{})[item.path].(map[string]interface{})[strings.ToLower(item.method)].(map[string]interface{})
+		if !assert.NotNil(t, operation, fmt.Sprintf("operation not documented for %s", desc)) {
+			continue
+		}
+		documented, _ := operation["responses"].(map[string]interface{})[strconv.Itoa(response.StatusCode)].(map[string]interface{})
+		if !assert.NotNil(t, documented, fmt.Sprintf("status code not documented for %s", desc)) {
+			continue
+		}
+		mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+		media, _ := documented["content"].(map[string]interface{})[mediaType].(map[string]interface{})
+		if !assert.NotNil(t, media, fmt.Sprintf("content type %s not documented for %s", mediaType, desc)) {
+			continue
+		}
+
+		var value interface{} = response.Body
+		if mediaType == "application/json" {
+			if err := json.Unmarshal([]byte(response.Body), &value); err != nil {
+				t.Errorf("invalid JSON for %s: %v", desc, err)
+				continue
+			}
+		}
+		assert.Empty(t, schemaErrors(doc, media["schema"].(map[string]interface{}), value, "#"), fmt.Sprintf("response does not match schema for %s", desc))
+	}
+}
+
+//test that the schema checks of the tests catch responses that do not match
+func TestSchemaErrors(t *testing.T) {
+	t.Parallel()
+	doc := fetchOpenAPI(newFixture(t, nil))
+	produceItem := map[string]interface{}{"$ref": "#/components/schemas/ProduceItem"}
+
+	var schemaTests = []struct {
+		desc  string
+		value string
+		valid bool
+	}{
+		{"valid item", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","stock":{"on_hand":2,"unit":"each","allow_backorder":false}}`, true},
+		{"missing name", `{"produce_code":"A12T-4GH7-QPL9-3N4M","unit_price":"$3.46"}`, false},
+		{"wrong type", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":3.46}`, false},
+		{"bad format", `{"produce_code":"A12T","name":"Lettuce","unit_price":"$3.46"}`, false},
+		{"bad nested type", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","stock":{"on_hand":"2"}}`, false},
+	}
+
+	for _, item := range schemaTests {
+		var value interface{}
+		json.Unmarshal([]byte(item.value), &value)
+		assert.Equal(t, item.valid, len(schemaErrors(doc, produceItem, value, "#")) == 0, fmt.Sprintf("unexpected result for %s", item.desc))
+	}
+}
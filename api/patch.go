@@ -0,0 +1,364 @@
+//contains JSON Merge Patch (RFC 7386) and JSON Patch (RFC 6902) helpers used by the PATCH end point
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+//media types accepted by the PATCH end point
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+//returned when the Content-Type of a PATCH request is not a supported patch format
+var errUnsupportedPatchType = errors.New("unsupported patch content type")
+
+//returned when the patch document itself is malformed
+type patchSyntaxError struct {
+	msg string
+}
+
+func (e patchSyntaxError) Error() string {
+	return e.msg
+}
+
+//returned when a well formed patch can not be applied to the current item, e.g. a missing path or a failed test op
+type patchConflictError struct {
+	msg string
+}
+
+func (e patchConflictError) Error() string {
+	return e.msg
+}
+
+//returned when the patched item is not a valid produce item, errs holds the errors by field like a ValidationError
+type patchValidationError struct {
+	errs url.Values
+}
+
+func (e patchValidationError) Error() string {
+	return "patched item is not valid"
+}
+
+//type to store a single JSON Patch operation. Value is kept raw so a missing value can be told apart from null.
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+//applies the patch body to the given produce item according to the content type of the request and returns the
+//resulting item. A missing content type or plain application/json is treated as a merge patch. The result is not
+//validated here, only the merged item as a whole is validated by the caller.
+func patchProduceItem(pItem ProduceItem, contentType string, body []byte) (ProduceItem, error) {
+	mediaType := mergePatchType
+	if contentType != "" {
+		parsed, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return ProduceItem{}, errUnsupportedPatchType
+		}
+		mediaType = parsed
+	}
+
+	current, err := json.Marshal(pItem)
+	if err != nil {
+		return ProduceItem{}, err
+	}
+	var doc interface{}
+	json.Unmarshal(current, &doc)
+
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var patch interface{}
+		if err := json.Unmarshal(body, &patch); err != nil {
+			return ProduceItem{}, patchSyntaxError{"invalid JSON syntax"}
+		}
+		if _, ok := patch.(map[string]interface{}); !ok {
+			return ProduceItem{}, patchSyntaxError{"merge patch must be a JSON object"}
+		}
+		doc = applyMergePatch(doc, patch)
+	case jsonPatchType:
+		var ops []patchOperation
+		if err := json.Unmarshal(body, &ops); err != nil {
+			return ProduceItem{}, patchSyntaxError{"invalid JSON syntax"}
+		}
+		doc, err = applyJSONPatch(doc, ops)
+		if err != nil {
+			return ProduceItem{}, err
+		}
+	default:
+		return ProduceItem{}, errUnsupportedPatchType
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		return ProduceItem{}, err
+	}
+	//a value of the wrong type, e.g. a number as the unit price, is a bad request body and not a conflict
+	var result ProduceItem
+	if err := json.Unmarshal(patched, &result); err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return ProduceItem{}, patchValidationError{url.Values{typeErr.Field: {"invalid value type"}}}
+		}
+		return ProduceItem{}, patchSyntaxError{"patched document is not a valid produce item"}
+	}
+	return result, nil
+}
+
+//merges patch into target following RFC 7386. Null members of the patch remove the member from the target, objects
+//are merged recursively and any other value replaces the target outright.
+func applyMergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = applyMergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+//applies a list of RFC 6902 operations to the document in order. If any operation fails the error is returned and
+//the caller should discard the document so the patch is applied atomically.
+func applyJSONPatch(doc interface{}, ops []patchOperation) (interface{}, error) {
+	for index, op := range ops {
+		path, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return nil, patchSyntaxError{fmt.Sprintf("operation %d (%s) is missing a value", index, op.Op)}
+			}
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, patchSyntaxError{fmt.Sprintf("operation %d has an invalid value", index)}
+			}
+		}
+
+		switch op.Op {
+		case "add":
+			doc, err = addValue(doc, path, value)
+		case "remove":
+			doc, _, err = removeValue(doc, path)
+		case "replace":
+			if _, err = getValue(doc, path); err == nil {
+				doc, _, err = removeValue(doc, path)
+			}
+			if err == nil {
+				doc, err = addValue(doc, path, value)
+			}
+		case "move", "copy":
+			var from []string
+			if from, err = parsePointer(op.From); err != nil {
+				return nil, err
+			}
+			if op.Op == "move" {
+				if strings.HasPrefix(op.Path+"/", op.From+"/") && op.Path != op.From {
+					return nil, patchConflictError{fmt.Sprintf("operation %d moves %s into itself", index, op.From)}
+				}
+				doc, value, err = removeValue(doc, from)
+			} else {
+				if value, err = getValue(doc, from); err == nil {
+					value = deepCopy(value)
+				}
+			}
+			if err == nil {
+				doc, err = addValue(doc, path, value)
+			}
+		case "test":
+			var current interface{}
+			if current, err = getValue(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+				err = patchConflictError{fmt.Sprintf("test failed for %s", op.Path)}
+			}
+		default:
+			return nil, patchSyntaxError{fmt.Sprintf("operation %d has unknown op %q", index, op.Op)}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+//splits a JSON pointer (RFC 6901) into its unescaped reference tokens. The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, patchSyntaxError{fmt.Sprintf("invalid JSON pointer %q", pointer)}
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for index, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		tokens[index] = strings.Replace(token, "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+//converts an array reference token into an index. When allowEnd is true the index one past the last element and the
+//"-" token are accepted, as used when adding to an array.
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, patchConflictError{fmt.Sprintf("invalid array index %q", token)}
+	}
+	if index > length || (index == length && !allowEnd) {
+		return 0, patchConflictError{fmt.Sprintf("array index %d out of range", index)}
+	}
+	return index, nil
+}
+
+//returns the value found at path in the document
+func getValue(doc interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, patchConflictError{fmt.Sprintf("path member %q does not exist", token)}
+			}
+			doc = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = node[index]
+		default:
+			return nil, patchConflictError{fmt.Sprintf("path member %q does not exist", token)}
+		}
+	}
+	return doc, nil
+}
+
+//adds value at path and returns the updated document. Object members are set or overwritten and array elements are
+//inserted before the given index.
+func addValue(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		if len(path) == 1 {
+			node[path[0]] = value
+			return node, nil
+		}
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, patchConflictError{fmt.Sprintf("path member %q does not exist", path[0])}
+		}
+		child, err := addValue(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+	case []interface{}:
+		index, err := arrayIndex(path[0], len(node), len(path) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) == 1 {
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+			return node, nil
+		}
+		child, err := addValue(node[index], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[index] = child
+		return node, nil
+	}
+	return nil, patchConflictError{fmt.Sprintf("path member %q does not exist", path[0])}
+}
+
+//removes the value at path and returns the updated document along with the removed value
+func removeValue(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, nil, patchConflictError{"the whole document can not be removed"}
+	}
+
+	switch node := doc.(type) {
+	case map[string]interface{}:
+		child, ok := node[path[0]]
+		if !ok {
+			return nil, nil, patchConflictError{fmt.Sprintf("path member %q does not exist", path[0])}
+		}
+		if len(path) == 1 {
+			delete(node, path[0])
+			return node, child, nil
+		}
+		child, removed, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[path[0]] = child
+		return node, removed, nil
+	case []interface{}:
+		index, err := arrayIndex(path[0], len(node), false)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(path) == 1 {
+			removed := node[index]
+			return append(node[:index], node[index+1:]...), removed, nil
+		}
+		child, removed, err := removeValue(node[index], path[1:])
+		if err != nil {
+			return nil, nil, err
+		}
+		node[index] = child
+		return node, removed, nil
+	}
+	return nil, nil, patchConflictError{fmt.Sprintf("path member %q does not exist", path[0])}
+}
+
+//returns a copy of a decoded JSON value that shares no maps or slices with the original
+func deepCopy(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for key, child := range node {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for index, child := range node {
+			copied[index] = deepCopy(child)
+		}
+		return copied
+	}
+	return value
+}
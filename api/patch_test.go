@@ -0,0 +1,73 @@
+//tests for patch.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+//test RFC 7386 merge patch behaviour on nested documents
+func TestApplyMergePatch(t *testing.T) {
+	var mergePatchTests = []struct {
+		desc     string
+		target   string
+		patch    string
+		expected string
+	}{
+		{"replace member", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add member", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"remove member", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"arrays replaced whole", `{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{"nested merge", `{"a":{"b":"c","d":"e"}}`, `{"a":{"d":null,"f":"g"}}`, `{"a":{"b":"c","f":"g"}}`},
+		{"non object target", `{"a":"b"}`, `{"a":{"c":"d"}}`, `{"a":{"c":"d"}}`},
+	}
+
+	for _, item := range mergePatchTests {
+		var target, patch interface{}
+		json.Unmarshal([]byte(item.target), &target)
+		json.Unmarshal([]byte(item.patch), &patch)
+		result, _ := json.Marshal(applyMergePatch(target, patch))
+		assert.Equal(t, item.expected, string(result), fmt.Sprintf("unexpected output for %s", item.desc))
+	}
+}
+
+//test RFC 6902 operations including pointer escaping and array handling
+func TestApplyJSONPatch(t *testing.T) {
+	var jsonPatchTests = []struct {
+		desc     string
+		doc      string
+		ops      string
+		expected string
+		isError  bool
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, false},
+		{"insert into array", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, false},
+		{"append to array", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, false},
+		{"remove array element", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/0"}]`, `{"a":[2,3]}`, false},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`, false},
+		{"move member", `{"a":1}`, `[{"op":"move","from":"/a","path":"/b"}]`, `{"b":1}`, false},
+		{"copy member", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"},{"op":"replace","path":"/c/b","value":2}]`, `{"a":{"b":1},"c":{"b":2}}`, false},
+		{"replace missing member", `{"a":1}`, `[{"op":"replace","path":"/b","value":2}]`, ``, true},
+		{"index out of range", `{"a":[1]}`, `[{"op":"add","path":"/a/2","value":2}]`, ``, true},
+		{"leading zero index", `{"a":[1,2]}`, `[{"op":"remove","path":"/a/01"}]`, ``, true},
+		{"move into child", `{"a":{"b":1}}`, `[{"op":"move","from":"/a","path":"/a/c"}]`, ``, true},
+		{"bad pointer", `{"a":1}`, `[{"op":"remove","path":"a"}]`, ``, true},
+	}
+
+	for _, item := range jsonPatchTests {
+		var doc interface{}
+		var ops []patchOperation
+		json.Unmarshal([]byte(item.doc), &doc)
+		json.Unmarshal([]byte(item.ops), &ops)
+		result, err := applyJSONPatch(doc, ops)
+		if item.isError {
+			assert.NotNil(t, err, fmt.Sprintf("expected error for %s", item.desc))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("unexpected error for %s", item.desc))
+		output, _ := json.Marshal(result)
+		assert.Equal(t, item.expected, string(output), fmt.Sprintf("unexpected output for %s", item.desc))
+	}
+}
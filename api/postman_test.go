@@ -0,0 +1,199 @@
+//replays the Postman test run exported from Postman against the handlers. The export holds the URLs, status codes
+//and assertions of the run but not the methods and bodies of its requests, those are written by hand in
+//postmanRequests. This is therefore not a check against the Postman collection itself, a request that changes in the
+//collection is only covered once postmanRequests and the export are updated along with it.
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//files exported from Postman, relative to the api directory
+const (
+	postmanRunFile         = "../supermarket_tests.postman_test_run.json"
+	postmanEnvironmentFile = "../supermakert_tests.postman_environment.json"
+)
+
+//type to read the parts of a Postman test run export the contract tests use
+type postmanRun struct {
+	Collection struct {
+		Order []string `json:"order"`
+	} `json:"collection"`
+	Results []postmanResult `json:"results"`
+}
+
+//type to read the result of one request of a Postman test run, the names of its test assertions are the keys of
+//TestPassFailCounts
+type postmanResult struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	ResponseCode struct {
+		Code int `json:"code"`
+	} `json:"responseCode"`
+	TestPassFailCounts map[string]struct {
+		Pass int `json:"pass"`
+		Fail int `json:"fail"`
+	} `json:"testPassFailCounts"`
+}
+
+//type to read a Postman environment
+type postmanEnvironment struct {
+	Values []struct {
+		Key     string `json:"key"`
+		Value   string `json:"value"`
+		Enabled bool   `json:"enabled"`
+	} `json:"values"`
+}
+
+//type to store what a test run export leaves out of a request, its method and body
+type postmanRequest struct {
+	method string
+	body   string
+}
+
+//the method and body of every request of the Postman test run, keyed by request name, written by hand after the
+//collection. A test run export only records the URL, so a request added to the run needs an entry here or
+//TestPostmanRunReplay fails naming it.
+var postmanRequests = map[string]postmanRequest{
+	"Get All Produce Items":               {"GET", ""},
+	"Get One Item":                        {"GET", ""},
+	"Get Item Doesn't Exist":              {"GET", ""},
+	"Get Invalid Code":                    {"GET", ""},
+	"Create New Item":                     {"POST", `{"produce_code":"1234-5678-9ABC-DEF0","name":"Cucumber","unit_price":"$1.25"}`},
+	"Create item exists":                  {"POST", `{"produce_code":"1234-5678-9ABC-DEF0","name":"Cucumber","unit_price":"$1.25"}`},
+	"Update an Item":                      {"POST", `{"produce_code":"E5T6-9UI3-TH15-QR88","name":"White Peach","unit_price":"$3.25"}`},
+	"Update Item Code Doesn't Exist":      {"POST", `{"produce_code":"E5T6-9UI3-TH15-1111","name":"Plum","unit_price":"$1.00"}`},
+	"Update Item New Code Already Exists": {"POST", `{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"White Peach","unit_price":"$3.25"}`},
+	"Update bad json format":              {"POST", `{"produce_code":"1234-5678-9ABC-DEF1","name":"Plum"`},
+	"Update Item bad name and code":       {"POST", `{"produce_code":"1234-5678","name":"Pl#m","unit_price":"$1.00"}`},
+	"Delete an Item":                      {"DELETE", ""},
+	"Delete an Item that doesnt exist":    {"DELETE", ""},
+}
+
+//environment variables holding the JSON Schemas the schema assertions check against, keyed by the name used in the
+//assertion
+var postmanSchemas = map[string]string{
+	"single item":     "produceSchema",
+	"all":             "produceAllSchema",
+	"validationError": "validateSchema",
+}
+
+var (
+	statusAssertion = regexp.MustCompile(`^Status (\d{3})$`)
+	schemaAssertion = regexp.MustCompile(`^Schema for (.+?)(?: schema)? is valid$`)
+	postmanVariable = regexp.MustCompile(`{{(\w+)}}`)
+)
+
+//reads a JSON file exported from Postman into value
+func readPostmanFile(t *testing.T, path string, value interface{}) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
+
+//returns the errors of the assertion of a Postman test on the response. The schema of an item is checked against
+//every item when the body is a list.
+func postmanAssertion(assertion string, response testResponse, variables map[string]string) []string {
+	if assertion == "Content-Type is present" {
+		if response.Header.Get("Content-Type") == "" {
+			return []string{"Content-Type header missing"}
+		}
+		return nil
+	}
+	if match := statusAssertion.FindStringSubmatch(assertion); match != nil {
+		if code, _ := strconv.Atoi(match[1]); response.StatusCode != code {
+			return []string{fmt.Sprintf("status %d, expected %d", response.StatusCode, code)}
+		}
+		return nil
+	}
+	if match := schemaAssertion.FindStringSubmatch(assertion); match != nil {
+		var schema map[string]interface{}
+		if err := json.Unmarshal([]byte(variables[postmanSchemas[match[1]]]), &schema); err != nil {
+			return []string{fmt.Sprintf("no schema for %q: %v", match[1], err)}
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(response.Body), &value); err != nil {
+			return []string{fmt.Sprintf("invalid JSON body: %v", err)}
+		}
+		items, isList := value.([]interface{})
+		if !isList || schema["type"] == "array" {
+			items = []interface{}{value}
+		}
+		var errs []string
+		for _, item := range items {
+			errs = append(errs, schemaErrors(nil, schema, item, "#")...)
+		}
+		return errs
+	}
+	return []string{"unsupported assertion"}
+}
+
+//test the handlers against the status codes and assertions recorded in the Postman test run, replaying its requests
+//in the order of the run with the methods and bodies of postmanRequests
+func TestPostmanRunReplay(t *testing.T) {
+	t.Parallel()
+	var run postmanRun
+	var environment postmanEnvironment
+	readPostmanFile(t, postmanRunFile, &run)
+	readPostmanFile(t, postmanEnvironmentFile, &environment)
+	f := newFixture(t, nil)
+
+	variables := map[string]string{}
+	for _, variable := range environment.Values {
+		if variable.Enabled {
+			variables[variable.Key] = variable.Value
+		}
+	}
+	expand := func(text string) string {
+		return postmanVariable.ReplaceAllStringFunc(text, func(name string) string {
+			return variables[strings.Trim(name, "{}")]
+		})
+	}
+
+	results := map[string]postmanResult{}
+	for _, result := range run.Results {
+		results[result.ID] = result
+	}
+	assert.NotEmpty(t, run.Collection.Order, "no requests in the Postman test run")
+
+	for _, id := range run.Collection.Order {
+		//the requests change the catalog the later ones see, so the run can not go on without one of them
+		result, ok := results[id]
+		if !ok {
+			t.Fatalf("request %s of the run order has no result in %s, export the test run again", id, postmanRunFile)
+		}
+		request, ok := postmanRequests[result.Name]
+		if !ok {
+			t.Fatalf("request %q of the test run has no entry in postmanRequests, the test run export does not record "+
+				"the method and body of a request so they have to be added there by hand", result.Name)
+		}
+
+		path := expand(result.URL)
+		path = path[strings.Index(path, "/"):] //drop the host Postman ran against
+		response := f.request(request.method, path, expand(request.body))
+
+		assert.Equal(t, result.ResponseCode.Code, response.StatusCode, fmt.Sprintf("unexpected status code for %q", result.Name))
+		var assertions []string
+		for assertion := range result.TestPassFailCounts {
+			assertions = append(assertions, assertion)
+		}
+		sort.Strings(assertions)
+		for _, assertion := range assertions {
+			assert.Empty(t, postmanAssertion(assertion, response, variables),
+				fmt.Sprintf("assertion %q failed for %q", assertion, result.Name))
+		}
+	}
+}
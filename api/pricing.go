@@ -0,0 +1,215 @@
+//contains money helpers and per unit of measure pricing of produce items along with its handler function
+package api
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+//kilograms in one pound, exact by definition
+var kilogramsPerPound = big.NewRat(45359237, 100000000)
+
+//price units that are weighed rather than counted
+var weightUnits = map[string]bool{"lb": true, "kg": true}
+
+//largest amount in cents prices, taxes and totals may come to, ten trillion dollars. Well below the range of int64 so
+//sums of many amounts still fit.
+const maxAmountCents = 1e15
+
+//errors returned when computing an extended price
+var (
+	errInvalidQuantity = errors.New("invalid quantity")
+	errWholeQuantity   = errors.New("quantity must be a whole number for count priced items")
+	errAmountTooLarge  = errors.New("amount is too large to be priced")
+)
+
+//type to store the price of a quantity of a produce item. Quantity is echoed as given so no precision is lost.
+type PriceQuote struct {
+	ProduceCode   string `json:"produce_code"`
+	UnitPrice     string `json:"unit_price"`
+	PriceUnit     string `json:"price_unit"`
+	Quantity      string `json:"quantity"`
+	Unit          string `json:"unit"`
+	ExtendedPrice string `json:"extended_price"`
+}
+
+//returns the unit the item is priced per, items without a price unit are priced per each
+func (pItem *ProduceItem) pricingUnit() string {
+	if pItem.PriceUnit == "" {
+		return "each"
+	}
+	return pItem.PriceUnit
+}
+
+//converts a unit price string such as "$4,000.93" into an exact number of dollars
+func parsePrice(price string) (*big.Rat, error) {
+	if !isValidUnitPrice(price) {
+		return nil, fmt.Errorf("invalid unit price %q", price)
+	}
+	amount, ok := new(big.Rat).SetString(strings.Replace(price[1:], ",", "", -1))
+	if !ok {
+		return nil, fmt.Errorf("invalid unit price %q", price)
+	}
+	return amount, nil
+}
+
+//parses a positive decimal quantity such as "2" or "1.375" exactly
+func parseQuantity(quantity string) (*big.Rat, error) {
+	if match, _ := regexp.MatchString(`^\d+(\.\d+)?$`, quantity); !match {
+		return nil, errInvalidQuantity
+	}
+	amount, _ := new(big.Rat).SetString(quantity)
+	if amount.Sign() <= 0 {
+		return nil, errInvalidQuantity
+	}
+	return amount, nil
+}
+
+//rounds an exact dollar amount to whole cents, halves are rounded away from zero. errAmountTooLarge is returned if the
+//amount is beyond maxAmountCents either way.
+func roundToCents(dollars *big.Rat) (int64, error) {
+	cents := new(big.Rat).Mul(dollars, big.NewRat(100, 1))
+	quotient, remainder := new(big.Int).QuoRem(cents.Num(), cents.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(cents.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(cents.Sign())))
+	}
+	return centsInRange(quotient)
+}
+
+//returns whole cents as an int64, errAmountTooLarge is returned if they are beyond maxAmountCents either way
+func centsInRange(cents *big.Int) (int64, error) {
+	if new(big.Int).Abs(cents).Cmp(big.NewInt(maxAmountCents)) > 0 {
+		return 0, errAmountTooLarge
+	}
+	return cents.Int64(), nil
+}
+
+//formats whole cents as a price string in the same format unit prices are given in, e.g. "$1,234.50"
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+
+	dollars := fmt.Sprint(cents / 100)
+	for index := len(dollars) - 3; index > 0; index -= 3 {
+		dollars = dollars[:index] + "," + dollars[index:]
+	}
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+//converts a quantity given in unit into the price unit of the item. Weights convert between lb and kg, counted units
+//only match themselves.
+func convertQuantity(quantity *big.Rat, unit string, priceUnit string) (*big.Rat, error) {
+	if unit == priceUnit {
+		return quantity, nil
+	}
+	if !weightUnits[unit] || !weightUnits[priceUnit] {
+		return nil, fmt.Errorf("can not convert %s to %s", unit, priceUnit)
+	}
+	if unit == "lb" {
+		return new(big.Rat).Mul(quantity, kilogramsPerPound), nil
+	}
+	return new(big.Rat).Quo(quantity, kilogramsPerPound), nil
+}
+
+//returns quantity given in unit converted into the price unit of the item. An empty unit means the price unit of the
+//item. Counted items must be given in whole numbers.
+func quantityInPriceUnit(pItem ProduceItem, quantity *big.Rat, unit string) (*big.Rat, error) {
+	priceUnit := pItem.pricingUnit()
+	if unit == "" {
+		unit = priceUnit
+	}
+	if !unitsOfMeasure[unit] {
+		return nil, fmt.Errorf("invalid unit of measure %q", unit)
+	}
+	if !weightUnits[priceUnit] && !quantity.IsInt() {
+		return nil, errWholeQuantity
+	}
+	return convertQuantity(quantity, unit, priceUnit)
+}
+
+//returns the exact price of quantity of the item given in unit, before any rounding
+func exactPrice(pItem ProduceItem, quantity *big.Rat, unit string) (*big.Rat, error) {
+	converted, err := quantityInPriceUnit(pItem, quantity, unit)
+	if err != nil {
+		return nil, err
+	}
+	unitPrice, err := parsePrice(pItem.UnitPrice)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Mul(unitPrice, converted), nil
+}
+
+//returns the price of quantity of the item given in unit in whole cents. The exact price is rounded once, half away
+//from zero, so converting between lb and kg never compounds rounding errors. errAmountTooLarge is returned if the
+//price is beyond maxAmountCents.
+func extendedPrice(pItem ProduceItem, quantity *big.Rat, unit string) (int64, error) {
+	price, err := exactPrice(pItem, quantity, unit)
+	if err != nil {
+		return 0, err
+	}
+	return roundToCents(price)
+}
+
+//This function prices a quantity of the produce code in the URL. The quantity query parameter defaults to 1 and the
+//unit query parameter to the price unit of the item, weights are converted between lb and kg. An invalid code,
+//quantity or unit, a fractional count or a price too large to compute, triggers a status 400 and an unknown code a
+//status 404. Otherwise the quote is returned with a 200 status code.
+func handleGetPrice(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	quantityParam := r.URL.Query().Get("quantity")
+	if quantityParam == "" {
+		quantityParam = "1"
+	}
+	quantity, err := parseQuantity(quantityParam)
+	if err != nil {
+		http.Error(w, "error 400 - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pItem, err := db.getProduceItem(r.Context(), params["produce_code"]) // get item of corresponding code from DB
+
+	//if produce code not found
+	if err == errProduceNotFound {
+		http.Error(w, "error 404 - produce code does not exist", 404)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+
+	unit := r.URL.Query().Get("unit")
+	if unit == "" {
+		unit = pItem.pricingUnit()
+	}
+	cents, err := extendedPrice(pItem, quantity, unit)
+	if err != nil {
+		http.Error(w, "error 400 - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, PriceQuote{
+		ProduceCode:   pItem.ProduceCode,
+		UnitPrice:     pItem.UnitPrice,
+		PriceUnit:     pItem.pricingUnit(),
+		Quantity:      quantityParam,
+		Unit:          unit,
+		ExtendedPrice: formatCents(cents),
+	})
+}
@@ -0,0 +1,107 @@
+//tests for pricing.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+//test rounding exact amounts to cents and formatting them back into price strings
+func TestRoundAndFormatCents(t *testing.T) {
+	t.Parallel()
+	var centsTests = []struct {
+		dollars  string
+		expected string
+	}{
+		{"1.005", "$1.01"},
+		{"1.0049", "$1.00"},
+		{"0.125", "$0.13"},
+		{"2.99", "$2.99"},
+		{"1234567.891", "$1,234,567.89"},
+		{"-1.005", "-$1.01"},
+		{"0", "$0.00"},
+	}
+
+	for _, item := range centsTests {
+		dollars, _ := new(big.Rat).SetString(item.dollars)
+		cents, err := roundToCents(dollars)
+		assert.Nil(t, err, fmt.Sprintf("unexpected error on amount: `%s`", item.dollars))
+		assert.Equal(t, item.expected, formatCents(cents), fmt.Sprintf("On amount: `%s`", item.dollars))
+	}
+
+	for _, amount := range []string{"10000000000000.01", "-10000000000000.01", "92233720368547758.08", "1e30"} {
+		dollars, _ := new(big.Rat).SetString(amount)
+		_, err := roundToCents(dollars)
+		assert.Equal(t, errAmountTooLarge, err, fmt.Sprintf("out of range amount `%s` rounded", amount))
+	}
+}
+
+//test extended prices across price units and conversions
+func TestExtendedPrice(t *testing.T) {
+	t.Parallel()
+	var extendedPriceTests = []struct {
+		desc      string
+		unitPrice string
+		priceUnit string
+		quantity  string
+		unit      string
+		expected  string
+		isError   bool
+	}{
+		{"each default", "$0.79", "", "3", "", "$2.37", false},
+		{"per lb in lb", "$2.99", "lb", "1.5", "lb", "$4.49", false},
+		{"per lb in kg", "$2.99", "lb", "1", "kg", "$6.59", false},
+		{"per kg in lb", "$6.59", "kg", "2.2", "lb", "$6.58", false},
+		{"per bunch", "$1.50", "bunch", "2", "bunch", "$3.00", false},
+		{"fractional each", "$0.79", "each", "1.5", "each", "", true},
+		{"weight for counted item", "$0.79", "each", "1", "lb", "", true},
+		{"unknown unit", "$0.79", "lb", "1", "stone", "", true},
+		{"price beyond int64", "$9,999.99", "each", "99999999999999999999", "each", "", true},
+		{"largest price", "$10,000.00", "each", "1000000000", "each", "$10,000,000,000,000.00", false},
+	}
+
+	for _, item := range extendedPriceTests {
+		quantity, _ := parseQuantity(item.quantity)
+		cents, err := extendedPrice(ProduceItem{UnitPrice: item.unitPrice, PriceUnit: item.priceUnit}, quantity, item.unit)
+		if item.isError {
+			assert.NotNil(t, err, fmt.Sprintf("expected error for %s", item.desc))
+			continue
+		}
+		assert.Nil(t, err, fmt.Sprintf("unexpected error for %s", item.desc))
+		assert.Equal(t, item.expected, formatCents(cents), fmt.Sprintf("unexpected price for %s", item.desc))
+	}
+}
+
+func TestHandleGetPrice(t *testing.T) {
+	t.Parallel()
+	var priceTests = []struct {
+		desc         string
+		path         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"default quantity", "YRT6-72AS-K736-L4AR/price", 200,
+			`{"produce_code":"YRT6-72AS-K736-L4AR","unit_price":"$0.79","price_unit":"each","quantity":"1","unit":"each","extended_price":"$0.79"}`},
+		//
+		{"weighed in kg", "E5T6-9UI3-TH15-QR88/price?quantity=0.5&unit=kg", 200,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","unit_price":"$2.99","price_unit":"lb","quantity":"0.5","unit":"kg","extended_price":"$3.30"}`},
+		//
+		{"invalid quantity", "E5T6-9UI3-TH15-QR88/price?quantity=-1", 400, "error 400 - invalid quantity\n"},
+		//
+		{"unconvertible unit", "YRT6-72AS-K736-L4AR/price?unit=kg", 400, "error 400 - can not convert kg to each\n"},
+		//
+		{"code does not exist", "YRT6-72AS-K736-0000/price", 404, "error 404 - produce code does not exist\n"},
+		//
+		{"price too large", "YRT6-72AS-K736-L4AR/price?quantity=99999999999999999999999", 400,
+			"error 400 - amount is too large to be priced\n"},
+	}
+
+	for _, item := range priceTests {
+		seed := seedItems()
+		seed[1].PriceUnit = "lb"
+		f := newFixture(t, seed)
+		f.request("GET", "/api/produce/"+item.path, "").assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
@@ -0,0 +1,382 @@
+//contains the promotions subsystem that discounts produce items by rule type, date range and priority, along with its
+//handler functions
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//promotion rule types
+const (
+	promoPercentOff = "percent_off" //Percent off the price
+	promoAmountOff  = "amount_off"  //Amount off every price unit
+	promoBOGO       = "bogo"        //for every BuyQuantity bought GetQuantity more are free
+	promoMultiBuy   = "multi_buy"   //Amount off every group of BuyQuantity
+)
+
+//type to store a promotion. The promotion is active from StartsAt until EndsAt, a zero time leaves that end open.
+//When several promotions apply to an item the one with the highest Priority is applied first, further promotions are
+//only applied while every promotion applied so far and the next one are Stackable.
+type Promotion struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	ProduceCodes []string  `json:"produce_codes"`
+	Percent      string    `json:"percent,omitempty"`
+	Amount       string    `json:"amount,omitempty"`
+	BuyQuantity  int       `json:"buy_quantity,omitempty"`
+	GetQuantity  int       `json:"get_quantity,omitempty"`
+	StartsAt     time.Time `json:"starts_at"`
+	EndsAt       time.Time `json:"ends_at"`
+	Priority     int       `json:"priority"`
+	Stackable    bool      `json:"stackable"`
+}
+
+//type to hold the promotions of a database
+type promotionBook struct {
+	mu         sync.RWMutex
+	promotions []Promotion
+}
+
+//checks that the promotion has a name, a known type with the settings it needs, valid produce codes and a date range
+//that ends after it starts. Produce codes are checked against the scheme of db.
+func (promo *Promotion) validatePromotion(db *DBObject) url.Values {
+	errs := url.Values{}
+
+	if promo.Name == "" {
+		errs.Add("name", "name field is required")
+	}
+
+	if len(promo.ProduceCodes) == 0 {
+		errs.Add("produce_codes", "produce codes field is required")
+	}
+	for index, pCode := range promo.ProduceCodes {
+		promo.ProduceCodes[index] = strings.ToUpper(pCode)
+		if !db.isValidProduceCode(promo.ProduceCodes[index]) {
+			errs.Add("produce_codes", fmt.Sprintf("invalid produce code format %q", pCode))
+		}
+	}
+
+	switch promo.Type {
+	case promoPercentOff:
+		percent, ok := new(big.Rat).SetString(promo.Percent)
+		if !ok || percent.Sign() <= 0 || percent.Cmp(big.NewRat(100, 1)) > 0 {
+			errs.Add("percent", "percent must be greater than 0 and at most 100")
+		}
+	case promoAmountOff, promoMultiBuy:
+		if !isValidUnitPrice(promo.Amount) {
+			errs.Add("amount", "invalid amount format")
+		}
+		if promo.Type == promoMultiBuy && promo.BuyQuantity < 1 {
+			errs.Add("buy_quantity", "buy quantity must be at least 1")
+		}
+	case promoBOGO:
+		if promo.BuyQuantity < 1 {
+			errs.Add("buy_quantity", "buy quantity must be at least 1")
+		}
+		if promo.GetQuantity < 1 {
+			errs.Add("get_quantity", "get quantity must be at least 1")
+		}
+	default:
+		errs.Add("type", "type must be one of percent_off, amount_off, bogo, multi_buy")
+	}
+
+	if !promo.EndsAt.IsZero() && !promo.EndsAt.After(promo.StartsAt) {
+		errs.Add("ends_at", "ends at must be after starts at")
+	}
+	return errs
+}
+
+//reports whether the promotion is running at the given time
+func (promo *Promotion) isActive(now time.Time) bool {
+	return !now.Before(promo.StartsAt) && (promo.EndsAt.IsZero() || now.Before(promo.EndsAt))
+}
+
+//reports whether the promotion covers the given produce code
+func (promo *Promotion) covers(pCode string) bool {
+	for _, code := range promo.ProduceCodes {
+		if code == pCode {
+			return true
+		}
+	}
+	return false
+}
+
+//returns the discount the promotion gives on quantity price units of an item at unitPrice, given the amount still
+//payable after promotions applied before it. The discount never exceeds the remaining amount.
+func (promo *Promotion) discount(unitPrice *big.Rat, quantity *big.Rat, remaining *big.Rat) *big.Rat {
+	discount := new(big.Rat)
+	switch promo.Type {
+	case promoPercentOff:
+		percent, _ := new(big.Rat).SetString(promo.Percent)
+		discount.Mul(remaining, percent.Quo(percent, big.NewRat(100, 1)))
+	case promoAmountOff:
+		amount, _ := parsePrice(promo.Amount)
+		discount.Mul(amount, quantity)
+	case promoBOGO:
+		groups := wholeGroups(quantity, int64(promo.BuyQuantity+promo.GetQuantity))
+		discount.Mul(unitPrice, new(big.Rat).SetInt64(groups*int64(promo.GetQuantity)))
+	case promoMultiBuy:
+		amount, _ := parsePrice(promo.Amount)
+		discount.Mul(amount, new(big.Rat).SetInt64(wholeGroups(quantity, int64(promo.BuyQuantity))))
+	}
+
+	if discount.Cmp(remaining) > 0 {
+		return new(big.Rat).Set(remaining)
+	}
+	return discount
+}
+
+//returns how many whole groups of size fit in quantity
+func wholeGroups(quantity *big.Rat, size int64) int64 {
+	whole := new(big.Int).Quo(quantity.Num(), quantity.Denom())
+	return whole.Int64() / size
+}
+
+//returns the promotions that apply to the produce code at the given time after the priority and stacking rules. The
+//highest priority promotion always applies, the following ones only while every promotion so far is stackable.
+//Promotions of equal priority keep their creation order.
+func (db *DBObject) applicablePromotions(pCode string, now time.Time) []Promotion {
+	db.promotions.mu.RLock()
+	defer db.promotions.mu.RUnlock()
+
+	var candidates []Promotion
+	for _, promo := range db.promotions.promotions {
+		if promo.isActive(now) && promo.covers(pCode) {
+			candidates = append(candidates, promo)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Priority > candidates[j].Priority
+	})
+
+	var applied []Promotion
+	for _, promo := range candidates {
+		if len(applied) > 0 && (!promo.Stackable || !applied[len(applied)-1].Stackable) {
+			break
+		}
+		applied = append(applied, promo)
+	}
+	return applied
+}
+
+//returns the total exact discount of the applicable promotions on quantity price units of the item along with the ids
+//of the promotions applied. A promotion that discounts nothing, e.g. a buy one get one on a single unit, is left out
+//of the ids.
+func (db *DBObject) promotionDiscount(pItem ProduceItem, quantity *big.Rat, now time.Time) (*big.Rat, []string) {
+	total := new(big.Rat)
+	unitPrice, err := parsePrice(pItem.UnitPrice)
+	if err != nil {
+		return total, nil
+	}
+
+	remaining := new(big.Rat).Mul(unitPrice, quantity)
+	var ids []string
+	for _, promo := range db.applicablePromotions(pItem.ProduceCode, now) {
+		discount := promo.discount(unitPrice, quantity, remaining)
+		remaining.Sub(remaining, discount)
+		total.Add(total, discount)
+		if discount.Sign() > 0 {
+			ids = append(ids, promo.ID)
+		}
+	}
+	return total, ids
+}
+
+//type to store a produce item along with its price after active promotions. EffectivePrice is only set when it
+//differs from the unit price.
+type pricedItem struct {
+	ProduceItem
+	EffectivePrice string   `json:"effective_price,omitempty"`
+	Promotions     []string `json:"promotions,omitempty"`
+}
+
+//returns the item with the price of one price unit after the promotions active now and the ids of the promotions
+//that lowered it
+func (db *DBObject) withEffectivePrice(pItem ProduceItem) pricedItem {
+	priced := pricedItem{ProduceItem: pItem}
+	unitPrice, err := parsePrice(pItem.UnitPrice)
+	if err != nil {
+		return priced
+	}
+
+	//promotions are only listed when they lower the price once it is rounded to cents
+	discount, ids := db.promotionDiscount(pItem, big.NewRat(1, 1), time.Now())
+	if discount.Sign() > 0 {
+		cents, err := roundToCents(new(big.Rat).Sub(unitPrice, discount))
+		listed, listedErr := roundToCents(unitPrice)
+		if err == nil && listedErr == nil && cents < listed {
+			priced.EffectivePrice = formatCents(cents)
+			priced.Promotions = ids
+		}
+	}
+	return priced
+}
+
+//stores a new promotion with a generated id
+func (db *DBObject) addPromotion(promo Promotion) Promotion {
+	db.promotions.mu.Lock()
+	defer db.promotions.mu.Unlock()
+
+	promo.ID = randomID(8)
+	db.promotions.promotions = append(db.promotions.promotions, promo)
+	return promo
+}
+
+//returns the promotions, only those running at now when activeOnly is set
+func (db *DBObject) getPromotions(activeOnly bool, now time.Time) []Promotion {
+	db.promotions.mu.RLock()
+	defer db.promotions.mu.RUnlock()
+
+	promos := []Promotion{}
+	for _, promo := range db.promotions.promotions {
+		if !activeOnly || promo.isActive(now) {
+			promos = append(promos, promo)
+		}
+	}
+	return promos
+}
+
+//returns the promotion of the given id or false if it does not exist
+func (db *DBObject) getPromotion(id string) (Promotion, bool) {
+	for _, promo := range db.getPromotions(false, time.Time{}) {
+		if promo.ID == id {
+			return promo, true
+		}
+	}
+	return Promotion{}, false
+}
+
+//replaces the promotion of the given id, keeping the id, or returns false if it does not exist
+func (db *DBObject) replacePromotion(id string, promo Promotion) (Promotion, bool) {
+	db.promotions.mu.Lock()
+	defer db.promotions.mu.Unlock()
+
+	for index, existing := range db.promotions.promotions {
+		if existing.ID == id {
+			promo.ID = id
+			db.promotions.promotions[index] = promo
+			return promo, true
+		}
+	}
+	return Promotion{}, false
+}
+
+//removes the promotion of the given id and returns it, or returns false if it does not exist
+func (db *DBObject) removePromotion(id string) (Promotion, bool) {
+	db.promotions.mu.Lock()
+	defer db.promotions.mu.Unlock()
+
+	for index, promo := range db.promotions.promotions {
+		if promo.ID == id {
+			db.promotions.promotions = append(db.promotions.promotions[:index], db.promotions.promotions[index+1:]...)
+			return promo, true
+		}
+	}
+	return Promotion{}, false
+}
+
+//moves the promotions that apply to one produce code to another after the item was renamed
+func (db *DBObject) renamePromotionCodes(oldCode string, newCode string) {
+	db.promotions.mu.Lock()
+	defer db.promotions.mu.Unlock()
+
+	//readers may still hold the old slices, so the codes are copied rather than changed in place
+	for index, promo := range db.promotions.promotions {
+		codes := make([]string, len(promo.ProduceCodes))
+		for codeIndex, code := range promo.ProduceCodes {
+			codes[codeIndex] = code
+			if code == oldCode {
+				codes[codeIndex] = newCode
+			}
+		}
+		db.promotions.promotions[index].ProduceCodes = codes
+	}
+}
+
+//decodes and validates a promotion from the request body. On failure the error response is written and false is
+//returned.
+func decodePromotion(w http.ResponseWriter, r *http.Request) (Promotion, bool) {
+	var promo Promotion
+
+	err := json.NewDecoder(r.Body).Decode(&promo) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return promo, false
+	}
+
+	validErrs := promo.validatePromotion(tenantDB(r))
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return promo, false
+	}
+	return promo, true
+}
+
+//This function lists the promotions with a 200 status code, only those running now when active=true is given.
+func handleGetPromotions(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	activeOnly := r.URL.Query().Get("active") == "true"
+	jsonResponse(w, http.StatusOK, db.getPromotions(activeOnly, time.Now()))
+}
+
+//This function creates a promotion from the JSON body and returns it with a 201 status code. Invalid JSON or a
+//promotion that fails validation triggers a status 400.
+func handleCreatePromotion(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	promo, ok := decodePromotion(w, r)
+	if !ok {
+		return
+	}
+	jsonResponse(w, http.StatusCreated, db.addPromotion(promo))
+}
+
+//This function returns the promotion of the id in the URL with a 200 status code or triggers a 404.
+func handleGetPromotion(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	promo, ok := db.getPromotion(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "error 404 - promotion does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, promo)
+}
+
+//This function replaces the promotion of the id in the URL with the JSON body and returns it with a 200 status code.
+//Invalid JSON or a promotion that fails validation triggers a status 400 and an unknown id a 404.
+func handleReplacePromotion(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	promo, ok := decodePromotion(w, r)
+	if !ok {
+		return
+	}
+	promo, ok = db.replacePromotion(mux.Vars(r)["id"], promo)
+	if !ok {
+		http.Error(w, "error 404 - promotion does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, promo)
+}
+
+//This function removes the promotion of the id in the URL and returns it with a 200 status code or triggers a 404.
+func handleDeletePromotion(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	promo, ok := db.removePromotion(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "error 404 - promotion does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, promo)
+}
@@ -0,0 +1,141 @@
+//tests for promotions.go
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+	"time"
+)
+
+//test the discount of every rule type on a line
+func TestPromotionDiscount(t *testing.T) {
+	t.Parallel()
+	var discountTests = []struct {
+		desc     string
+		promo    Promotion
+		quantity int64
+		expected string
+	}{
+		{"20 percent off", Promotion{Type: promoPercentOff, Percent: "20"}, 2, "$1.20"},
+		{"amount off per unit", Promotion{Type: promoAmountOff, Amount: "$0.50"}, 3, "$1.50"},
+		{"amount off capped at price", Promotion{Type: promoAmountOff, Amount: "$5.00"}, 1, "$3.00"},
+		{"bogo", Promotion{Type: promoBOGO, BuyQuantity: 1, GetQuantity: 1}, 5, "$6.00"},
+		{"buy 2 get 1", Promotion{Type: promoBOGO, BuyQuantity: 2, GetQuantity: 1}, 5, "$3.00"},
+		{"1 off when buying 3", Promotion{Type: promoMultiBuy, Amount: "$1", BuyQuantity: 3}, 7, "$2.00"},
+	}
+
+	unitPrice := big.NewRat(3, 1)
+	for _, item := range discountTests {
+		quantity := big.NewRat(item.quantity, 1)
+		remaining := new(big.Rat).Mul(unitPrice, quantity)
+		discount := item.promo.discount(unitPrice, quantity, remaining)
+		cents, _ := roundToCents(discount)
+		assert.Equal(t, item.expected, formatCents(cents), fmt.Sprintf("unexpected discount for %s", item.desc))
+	}
+}
+
+//test date ranges and the priority and stacking rules
+func TestApplicablePromotions(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	var db DBObject
+	db.addPromotion(Promotion{Name: "expired", ProduceCodes: []string{"1111-1111-1111-1111"}, Priority: 9, Stackable: true, EndsAt: now.Add(-time.Hour)})
+	db.addPromotion(Promotion{Name: "future", ProduceCodes: []string{"1111-1111-1111-1111"}, Priority: 9, Stackable: true, StartsAt: now.Add(time.Hour)})
+	db.addPromotion(Promotion{Name: "low stackable", ProduceCodes: []string{"1111-1111-1111-1111"}, Priority: 1, Stackable: true})
+	db.addPromotion(Promotion{Name: "high stackable", ProduceCodes: []string{"1111-1111-1111-1111"}, Priority: 5, Stackable: true})
+	db.addPromotion(Promotion{Name: "exclusive", ProduceCodes: []string{"2222-2222-2222-2222"}, Priority: 5})
+	db.addPromotion(Promotion{Name: "stackable", ProduceCodes: []string{"2222-2222-2222-2222"}, Priority: 1, Stackable: true})
+
+	names := func(promos []Promotion) []string {
+		var result []string
+		for _, promo := range promos {
+			result = append(result, promo.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"high stackable", "low stackable"}, names(db.applicablePromotions("1111-1111-1111-1111", now)), "stackable promotions not combined")
+	assert.Equal(t, []string{"exclusive"}, names(db.applicablePromotions("2222-2222-2222-2222", now)), "exclusive promotion stacked")
+}
+
+//test that promotions follow an item to its new produce code
+func TestPromotionRename(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	promo := db.addPromotion(Promotion{Name: "apples", ProduceCodes: []string{"1111-1111-1111-1111", "2222-2222-2222-2222"}})
+
+	db.updateProduceItem(context.Background(), "2222-2222-2222-2222", ProduceItem{ProduceCode: "3333-3333-3333-3333", Name: "Gala Apple", UnitPrice: "$3.59"})
+
+	renamed, _ := db.getPromotion(promo.ID)
+	assert.Equal(t, []string{"1111-1111-1111-1111", "3333-3333-3333-3333"}, renamed.ProduceCodes, "promotion not moved to new code")
+	assert.Equal(t, []string{"1111-1111-1111-1111", "2222-2222-2222-2222"}, promo.ProduceCodes, "returned promotion changed")
+}
+
+func TestHandlePromotions(t *testing.T) {
+	t.Parallel()
+	promotionUrl := "/api/promotions"
+	f := newFixture(t, nil)
+
+	var createTests = []struct {
+		desc         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"missing fields", `{"type":"percent_off","percent":"120","produce_codes":["abc"]}`, 400,
+			`{"validationError":{"name":["name field is required"],"percent":["percent must be greater than 0 and at most 100"],"produce_codes":["invalid produce code format \"abc\""]}}`},
+		//
+		{"unknown type", `{"name":"Special","type":"free","produce_codes":["2222-2222-2222-2222"]}`, 400,
+			`{"validationError":{"type":["type must be one of percent_off, amount_off, bogo, multi_buy"]}}`},
+		//
+		{"bad date range", `{"name":"Special","type":"bogo","buy_quantity":1,"get_quantity":1,"produce_codes":["2222-2222-2222-2222"],"starts_at":"2018-06-02T00:00:00Z","ends_at":"2018-06-01T00:00:00Z"}`, 400,
+			`{"validationError":{"ends_at":["ends at must be after starts at"]}}`},
+	}
+
+	for _, item := range createTests {
+		f.request("POST", promotionUrl, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+
+	//20% off green peppers shows up on item GETs and in carts
+	response := f.request("POST", promotionUrl, `{"name":"Pepper Special","type":"percent_off","percent":"20","produce_codes":["yrt6-72as-k736-l4ar"]}`)
+	var promo Promotion
+	response.decode(t, &promo)
+	assert.Equal(t, 201, response.StatusCode, "promotion not created")
+
+	f.request("GET", "/api/produce/YRT6-72AS-K736-L4AR", "").assert(t, 200,
+		fmt.Sprintf(`{"produce_code":"YRT6-72AS-K736-L4AR","name":"Green Pepper","unit_price":"$0.79","effective_price":"$0.63","promotions":["%s"]}`, promo.ID),
+		"effective price")
+
+	var totals CartTotals
+	f.request("POST", "/api/cart", `{"items":[{"produce_code":"YRT6-72AS-K736-L4AR","quantity":5}]}`).decode(t, &totals)
+	assert.Equal(t, "$0.79", totals.Lines[0].Discount, "cart discount not applied")
+	assert.Equal(t, "$3.16", totals.Total, "unexpected cart total")
+
+	response = f.request("DELETE", fmt.Sprintf("%s/%s", promotionUrl, promo.ID), "")
+	assert.Equal(t, 200, response.StatusCode, "promotion not deleted")
+
+	response = f.request("GET", fmt.Sprintf("%s/%s", promotionUrl, promo.ID), "")
+	assert.Equal(t, 404, response.StatusCode, "deleted promotion still found")
+}
+
+//test that promotions are only listed where they lower the price
+func TestPromotionsWithoutDiscount(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+	var bogo Promotion
+	f.request("POST", "/api/promotions", `{"name":"Peach Bogo","type":"bogo","buy_quantity":1,"get_quantity":1,"produce_codes":["E5T6-9UI3-TH15-QR88"]}`).decode(t, &bogo)
+	response := f.request("POST", "/api/promotions", `{"name":"Tiny","type":"percent_off","percent":"0.1","produce_codes":["YRT6-72AS-K736-L4AR"]}`)
+	assert.Equal(t, 201, response.StatusCode, "promotion not created")
+
+	f.request("GET", "/api/produce/E5T6-9UI3-TH15-QR88", "").assert(t, 200,
+		`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99"}`, "bogo on a single unit")
+	f.request("GET", "/api/produce/YRT6-72AS-K736-L4AR", "").assert(t, 200,
+		`{"produce_code":"YRT6-72AS-K736-L4AR","name":"Green Pepper","unit_price":"$0.79"}`, "discount below a cent")
+
+	var totals CartTotals
+	f.request("POST", "/api/cart", `{"items":[{"produce_code":"E5T6-9UI3-TH15-QR88","quantity":1},{"produce_code":"E5T6-9UI3-TH15-QR88","quantity":2}]}`).decode(t, &totals)
+	assert.Empty(t, totals.Lines[0].Promotions, "bogo listed on a cart line of one unit")
+	assert.Equal(t, []string{bogo.ID}, totals.Lines[1].Promotions, "bogo not listed on a cart line of two units")
+}
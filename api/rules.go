@@ -0,0 +1,467 @@
+//contains the declarative validation rules produce items are checked against. The built in rules are read from the
+//validate struct tags of ProduceItem, every tenant and store can add rules of their own from a JSON schema, along with
+//the handler functions that manage them
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+//type to store a named check a rule can refer to with format, along with the error it reports. Check is given the
+//database the item is saved to so formats can follow the settings of the tenant.
+type fieldFormat struct {
+	check   func(db *DBObject, value string) bool
+	message string
+}
+
+//named formats rules can require, shared by struct tags and schema files
+var fieldFormats = map[string]fieldFormat{
+	"produce_code": {(*DBObject).isValidProduceCode, "invalid produce code format"},
+	"name":         {func(_ *DBObject, value string) bool { return isValidName(value) }, "invalid name format"},
+	"unit_price":   {func(_ *DBObject, value string) bool { return isValidUnitPrice(value) }, "invalid unit price format"},
+	"price_unit":   {func(_ *DBObject, value string) bool { return unitsOfMeasure[value] }, "invalid price unit"},
+	"tax_category": {func(db *DBObject, value string) bool { return db.taxBook().knownCategory(value) }, "unknown tax category"},
+}
+
+//type to store a validation rule for one field, named by its JSON name. Every check that is set must pass. Checks on a
+//field that is not required are skipped while it is empty. MinLength and MaxLength count characters of a string and
+//entries of a list, the other checks apply to every entry of a list. Min and Max are decimal numbers, a value may
+//carry a leading $ and thousands separators. Message replaces the error of every check of the rule.
+type FieldRule struct {
+	Field     string         `json:"field"`
+	Label     string         `json:"label,omitempty"`
+	Required  bool           `json:"required,omitempty"`
+	MinLength int            `json:"min_length,omitempty"`
+	MaxLength int            `json:"max_length,omitempty"`
+	Pattern   string         `json:"pattern,omitempty"`
+	Format    string         `json:"format,omitempty"`
+	Min       string         `json:"min,omitempty"`
+	Max       string         `json:"max,omitempty"`
+	Enum      []string       `json:"enum,omitempty"`
+	When      *RuleCondition `json:"when,omitempty"`
+	Message   string         `json:"message,omitempty"`
+	pattern   *regexp.Regexp
+	min       *big.Rat
+	max       *big.Rat
+}
+
+//type to store the condition of a cross field rule. The rule only applies when Field is one of In or, if In is empty,
+//when Field is not empty.
+type RuleCondition struct {
+	Field string   `json:"field"`
+	In    []string `json:"in,omitempty"`
+}
+
+//type to store the rules a tenant adds on top of the built in rules
+type RuleSet struct {
+	Rules []FieldRule `json:"rules"`
+}
+
+//type to hold the rules of a database and the rules of its stores keyed by store id
+type ruleBook struct {
+	mu     sync.RWMutex
+	rules  RuleSet
+	stores map[string]RuleSet
+}
+
+//built in rules of produce items, read from the validate struct tags
+var produceItemRules = mustTagRules(reflect.TypeOf(ProduceItem{}))
+
+//returns the index of every field of the struct type that rules can check, keyed by its JSON name. Strings and lists
+//of strings can be checked.
+func ruleFields(structType reflect.Type) map[string]int {
+	fields := map[string]int{}
+	for index := 0; index < structType.NumField(); index++ {
+		field := structType.Field(index)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		kind := field.Type.Kind()
+		if name == "" || name == "-" {
+			continue
+		}
+		if kind == reflect.String || (kind == reflect.Slice && field.Type.Elem().Kind() == reflect.String) {
+			fields[name] = index
+		}
+	}
+	return fields
+}
+
+//reads the rules of the validate struct tags of the type, e.g. `validate:"required,format=name,max_length=60"`, and
+//panics if a tag is invalid since tags are fixed at compile time. The label tag names the field in errors.
+func mustTagRules(structType reflect.Type) []FieldRule {
+	var rules []FieldRule
+	for index := 0; index < structType.NumField(); index++ {
+		field := structType.Field(index)
+		tag, ok := field.Tag.Lookup("validate")
+		if !ok {
+			continue
+		}
+
+		rule := FieldRule{Field: strings.Split(field.Tag.Get("json"), ",")[0], Label: field.Tag.Get("label")}
+		for _, option := range strings.Split(tag, ",") {
+			key, value := option, ""
+			if split := strings.Index(option, "="); split >= 0 {
+				key, value = option[:split], option[split+1:]
+			}
+			var err error
+			switch key {
+			case "required":
+				rule.Required = true
+			case "min_length":
+				rule.MinLength, err = strconv.Atoi(value)
+			case "max_length":
+				rule.MaxLength, err = strconv.Atoi(value)
+			case "pattern":
+				rule.Pattern = value
+			case "format":
+				rule.Format = value
+			case "min":
+				rule.Min = value
+			case "max":
+				rule.Max = value
+			case "enum":
+				rule.Enum = strings.Split(value, "|")
+			default:
+				err = fmt.Errorf("unknown option %q", key)
+			}
+			if err != nil {
+				panic(fmt.Sprintf("validate tag of %s.%s: %v", structType.Name(), field.Name, err))
+			}
+		}
+		if err := rule.compile(ruleFields(structType)); err != nil {
+			panic(fmt.Sprintf("validate tag of %s.%s: %v", structType.Name(), field.Name, err))
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+//checks that the rule refers to known fields and formats and prepares its pattern and range
+func (rule *FieldRule) compile(fields map[string]int) error {
+	if _, ok := fields[rule.Field]; !ok {
+		return fmt.Errorf("unknown field %q", rule.Field)
+	}
+	if rule.When != nil {
+		if _, ok := fields[rule.When.Field]; !ok {
+			return fmt.Errorf("unknown condition field %q", rule.When.Field)
+		}
+	}
+	if _, ok := fieldFormats[rule.Format]; rule.Format != "" && !ok {
+		return fmt.Errorf("unknown format %q", rule.Format)
+	}
+	if rule.MinLength < 0 || rule.MaxLength < 0 {
+		return fmt.Errorf("negative length for %q", rule.Field)
+	}
+
+	var err error
+	if rule.Pattern != "" {
+		if rule.pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern for %q: %v", rule.Field, err)
+		}
+	}
+	for _, bound := range []struct {
+		text   string
+		parsed **big.Rat
+	}{{rule.Min, &rule.min}, {rule.Max, &rule.max}} {
+		if bound.text == "" {
+			continue
+		}
+		if *bound.parsed = parseRuleNumber(bound.text); *bound.parsed == nil {
+			return fmt.Errorf("invalid number %q for %q", bound.text, rule.Field)
+		}
+	}
+	return nil
+}
+
+//parses a decimal number, allowing a leading $ and thousands separators so prices can be compared. nil is returned
+//if it is not a number.
+func parseRuleNumber(value string) *big.Rat {
+	number, ok := new(big.Rat).SetString(strings.Replace(strings.TrimPrefix(value, "$"), ",", "", -1))
+	if !ok {
+		return nil
+	}
+	return number
+}
+
+//returns the values of the field of item as a list, a string is a list of one
+func ruleValues(item reflect.Value, index int) []string {
+	field := item.Field(index)
+	if field.Kind() == reflect.String {
+		return []string{field.String()}
+	}
+	values := make([]string, field.Len())
+	for entry := range values {
+		values[entry] = field.Index(entry).String()
+	}
+	return values
+}
+
+//checks if a value counts as left out
+func isEmptyValue(values []string) bool {
+	return len(values) == 0 || (len(values) == 1 && values[0] == "")
+}
+
+//adds the errors of the rule for item, to be saved to db, to errs
+func (rule *FieldRule) check(db *DBObject, item reflect.Value, fields map[string]int, errs url.Values) {
+	if rule.When != nil {
+		values := ruleValues(item, fields[rule.When.Field])
+		if isEmptyValue(values) {
+			return
+		}
+		if len(rule.When.In) > 0 && !containsAll(rule.When.In, values) {
+			return
+		}
+	}
+
+	label := rule.Label
+	if label == "" {
+		label = strings.Replace(rule.Field, "_", " ", -1)
+	}
+	fail := func(message string, args ...interface{}) {
+		if rule.Message != "" {
+			errs.Add(rule.Field, rule.Message)
+			return
+		}
+		errs.Add(rule.Field, fmt.Sprintf(message, args...))
+	}
+
+	values := ruleValues(item, fields[rule.Field])
+	if isEmptyValue(values) {
+		if !rule.Required {
+			return
+		}
+		fail("%s field is required", label)
+	}
+
+	length := len(values)
+	if item.Field(fields[rule.Field]).Kind() == reflect.String {
+		length = utf8.RuneCountInString(values[0])
+	}
+	if rule.MinLength > 0 && length < rule.MinLength {
+		fail("%s must be at least %d long", label, rule.MinLength)
+	}
+	if rule.MaxLength > 0 && length > rule.MaxLength {
+		fail("%s must be at most %d long", label, rule.MaxLength)
+	}
+
+	for _, value := range values {
+		if rule.Format != "" && !fieldFormats[rule.Format].check(db, value) {
+			fail("%s", fieldFormats[rule.Format].message)
+		}
+		if rule.pattern != nil && !rule.pattern.MatchString(value) {
+			fail("invalid %s format", label)
+		}
+		if len(rule.Enum) > 0 && !containsAll(rule.Enum, []string{value}) {
+			fail("%s must be one of %s", label, strings.Join(rule.Enum, ", "))
+		}
+		if rule.min != nil || rule.max != nil {
+			number := parseRuleNumber(value)
+			switch {
+			case number == nil:
+				fail("%s must be a number", label)
+			case rule.min != nil && number.Cmp(rule.min) < 0:
+				fail("%s must be at least %s", label, rule.Min)
+			case rule.max != nil && number.Cmp(rule.max) > 0:
+				fail("%s must be at most %s", label, rule.Max)
+			}
+		}
+	}
+}
+
+//checks that every value is one of allowed
+func containsAll(allowed []string, values []string) bool {
+	for _, value := range values {
+		found := false
+		for _, option := range allowed {
+			found = found || option == value
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//checks item, a pointer to a struct to be saved to db, against the rules and returns the errors keyed by field
+func checkRules(db *DBObject, item interface{}, rules []FieldRule) url.Values {
+	errs := url.Values{}
+	value := reflect.Indirect(reflect.ValueOf(item))
+	fields := ruleFields(value.Type())
+	for index := range rules {
+		rules[index].check(db, value, fields, errs)
+	}
+	return errs
+}
+
+//decodes and checks a rule set for produce items
+func parseRuleSet(data []byte) (RuleSet, error) {
+	var set RuleSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return RuleSet{}, fmt.Errorf("invalid JSON syntax: %v", err)
+	}
+	if set.Rules == nil {
+		set.Rules = []FieldRule{}
+	}
+
+	fields := ruleFields(reflect.TypeOf(ProduceItem{}))
+	for index := range set.Rules {
+		if err := set.Rules[index].compile(fields); err != nil {
+			return RuleSet{}, err
+		}
+	}
+	return set, nil
+}
+
+//reads a rule set from a JSON file and makes it the rule set of the default database. The file is checked in full
+//before it replaces the current rules.
+func LoadValidationRules(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	set, err := parseRuleSet(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	currentDB.setRules(set)
+	return nil
+}
+
+//replaces the rules of the database
+func (db *DBObject) setRules(set RuleSet) {
+	db.rules.mu.Lock()
+	defer db.rules.mu.Unlock()
+	db.rules.rules = set
+}
+
+//returns the rules of the database
+func (db *DBObject) getRules() RuleSet {
+	db.rules.mu.RLock()
+	defer db.rules.mu.RUnlock()
+	return RuleSet{Rules: append([]FieldRule{}, db.rules.rules.Rules...)}
+}
+
+//replaces the rules of a store, returning false if the store does not exist
+func (db *DBObject) setStoreRules(id string, set RuleSet) bool {
+	db.stores.mu.RLock()
+	defer db.stores.mu.RUnlock()
+	if _, ok := db.stores.overrides[id]; !ok {
+		return false
+	}
+
+	db.rules.mu.Lock()
+	defer db.rules.mu.Unlock()
+	if db.rules.stores == nil {
+		db.rules.stores = map[string]RuleSet{}
+	}
+	db.rules.stores[id] = set
+	return true
+}
+
+//returns the rules of a store, false if the store does not exist
+func (db *DBObject) getStoreRules(id string) (RuleSet, bool) {
+	db.stores.mu.RLock()
+	defer db.stores.mu.RUnlock()
+	if _, ok := db.stores.overrides[id]; !ok {
+		return RuleSet{}, false
+	}
+	return db.storeRules(id), true
+}
+
+//returns the rules of a store without checking that it exists, callers hold the stores lock so the store can not be
+//removed meanwhile
+func (db *DBObject) storeRules(id string) RuleSet {
+	db.rules.mu.RLock()
+	defer db.rules.mu.RUnlock()
+	return RuleSet{Rules: append([]FieldRule{}, db.rules.stores[id].Rules...)}
+}
+
+//removes the rules of a store after it was removed, callers hold the stores lock
+func (db *DBObject) removeStoreRules(id string) {
+	db.rules.mu.Lock()
+	defer db.rules.mu.Unlock()
+	delete(db.rules.stores, id)
+}
+
+//adds the errors of the rules of the database for the item to errs
+func (db *DBObject) validateItemRules(pItem *ProduceItem, errs url.Values) {
+	for field, messages := range checkRules(db, pItem, db.getRules().Rules) {
+		for _, message := range messages {
+			errs.Add(field, message)
+		}
+	}
+}
+
+//This function returns the validation rules of the tenant, which apply on top of the built in rules, with a 200
+//status code.
+func handleGetRules(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	jsonResponse(w, http.StatusOK, db.getRules())
+}
+
+//This function replaces the validation rules of the tenant with the rule set in the JSON body and returns them with a
+//200 status code. A body that is not a valid rule set triggers a status 400. The rules apply to items saved from then
+//on, items already stored are not checked again.
+func handleReplaceRules(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error 400 - unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	set, err := parseRuleSet(body)
+	if err != nil {
+		http.Error(w, "error 400 - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	db.setRules(set)
+	jsonResponse(w, http.StatusOK, set)
+}
+
+//This function returns the validation rules of the store in the URL, which apply on top of the rules of the tenant to
+//the items as sold in the store, with a 200 status code. An unknown store triggers a status 404.
+func handleGetStoreRules(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	set, ok := db.getStoreRules(mux.Vars(r)["store_id"])
+	if !ok {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, set)
+}
+
+//This function replaces the validation rules of the store in the URL with the rule set in the JSON body and returns
+//them with a 200 status code. A body that is not a valid rule set triggers a status 400 and an unknown store a status
+//404. The rules are checked against the item as sold in the store whenever an override of the store is set, overrides
+//already set are not checked again.
+func handleReplaceStoreRules(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "error 400 - unable to read request body", http.StatusBadRequest)
+		return
+	}
+
+	set, err := parseRuleSet(body)
+	if err != nil {
+		http.Error(w, "error 400 - "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !db.setStoreRules(mux.Vars(r)["store_id"], set) {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, set)
+}
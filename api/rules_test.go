@@ -0,0 +1,222 @@
+//tests for rules.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCheckRules(t *testing.T) {
+	t.Parallel()
+	var ruleTests = []struct {
+		desc     string
+		rule     FieldRule
+		pItem    ProduceItem
+		expected url.Values
+	}{
+		{"required", FieldRule{Field: "category", Required: true}, ProduceItem{},
+			url.Values{"category": {"category field is required"}}},
+		//
+		{"optional empty field skipped", FieldRule{Field: "category", Pattern: `^x$`}, ProduceItem{}, url.Values{}},
+		//
+		{"min length", FieldRule{Field: "name", MinLength: 3}, ProduceItem{Name: "Fig"}, url.Values{}},
+		//
+		{"min length counts characters", FieldRule{Field: "name", MinLength: 4}, ProduceItem{Name: "Pi\u00f1"},
+			url.Values{"name": {"name must be at least 4 long"}}},
+		//
+		{"max length", FieldRule{Field: "name", MaxLength: 4}, ProduceItem{Name: "Peach"},
+			url.Values{"name": {"name must be at most 4 long"}}},
+		//
+		{"pattern", FieldRule{Field: "produce_code", Pattern: `^ORG-`, Label: "organic code"}, ProduceItem{ProduceCode: "A12T-4GH7-QPL9-3N4M"},
+			url.Values{"produce_code": {"invalid organic code format"}}},
+		//
+		{"enum", FieldRule{Field: "price_unit", Enum: []string{"lb", "kg"}}, ProduceItem{PriceUnit: "each"},
+			url.Values{"price_unit": {"price unit must be one of lb, kg"}}},
+		//
+		{"price below min", FieldRule{Field: "unit_price", Min: "0.10"}, ProduceItem{UnitPrice: "$0.09"},
+			url.Values{"unit_price": {"unit price must be at least 0.10"}}},
+		//
+		{"price above max", FieldRule{Field: "unit_price", Max: "$1,000"}, ProduceItem{UnitPrice: "$1,000.01"},
+			url.Values{"unit_price": {"unit price must be at most $1,000"}}},
+		//
+		{"not a number", FieldRule{Field: "name", Max: "10"}, ProduceItem{Name: "Kale"},
+			url.Values{"name": {"name must be a number"}}},
+		//
+		{"condition holds", FieldRule{Field: "unit_price", Max: "20", When: &RuleCondition{Field: "price_unit", In: []string{"lb", "kg"}}},
+			ProduceItem{UnitPrice: "$25.00", PriceUnit: "kg"}, url.Values{"unit_price": {"unit price must be at most 20"}}},
+		//
+		{"condition does not hold", FieldRule{Field: "unit_price", Max: "20", When: &RuleCondition{Field: "price_unit", In: []string{"lb", "kg"}}},
+			ProduceItem{UnitPrice: "$25.00", PriceUnit: "each"}, url.Values{}},
+		//
+		{"condition on present field", FieldRule{Field: "barcodes", Required: true, When: &RuleCondition{Field: "category"}},
+			ProduceItem{Category: "fruit"}, url.Values{"barcodes": {"barcodes field is required"}}},
+		//
+		{"list length counts entries", FieldRule{Field: "barcodes", MaxLength: 1}, ProduceItem{Barcodes: []string{"4011", "94011"}},
+			url.Values{"barcodes": {"barcodes must be at most 1 long"}}},
+		//
+		{"list entries checked", FieldRule{Field: "barcodes", Pattern: `^9`, Message: "only organic PLUs"}, ProduceItem{Barcodes: []string{"4011", "94011"}},
+			url.Values{"barcodes": {"only organic PLUs"}}},
+	}
+
+	fields := ruleFields(reflect.TypeOf(ProduceItem{}))
+	for _, test := range ruleTests {
+		assert.Nil(t, test.rule.compile(fields), fmt.Sprintf("rule rejected for %s", test.desc))
+		assert.Equal(t, test.expected, checkRules(&DBObject{}, &test.pItem, []FieldRule{test.rule}), fmt.Sprintf("unexpected errors for %s", test.desc))
+	}
+}
+
+func TestParseRuleSet(t *testing.T) {
+	t.Parallel()
+	var ruleSetTests = []struct {
+		desc     string
+		json     string
+		expected string
+	}{
+		{"valid", `{"rules":[{"field":"name","max_length":40},{"field":"unit_price","min":"$0.01"}]}`, ""},
+		{"unknown field", `{"rules":[{"field":"colour","required":true}]}`, `unknown field "colour"`},
+		{"field that can not be checked", `{"rules":[{"field":"stock","required":true}]}`, `unknown field "stock"`},
+		{"unknown condition field", `{"rules":[{"field":"name","when":{"field":"colour"}}]}`, `unknown condition field "colour"`},
+		{"unknown format", `{"rules":[{"field":"name","format":"email"}]}`, `unknown format "email"`},
+		{"invalid pattern", `{"rules":[{"field":"name","pattern":"("}]}`, "invalid pattern for \"name\": error parsing regexp: missing closing ): `(`"},
+		{"invalid number", `{"rules":[{"field":"unit_price","max":"ten"}]}`, `invalid number "ten" for "unit_price"`},
+		{"negative length", `{"rules":[{"field":"name","min_length":-1}]}`, `negative length for "name"`},
+	}
+
+	for _, test := range ruleSetTests {
+		_, err := parseRuleSet([]byte(test.json))
+		if test.expected == "" {
+			assert.Nil(t, err, fmt.Sprintf("rule set rejected for %s", test.desc))
+			continue
+		}
+		assert.EqualError(t, err, test.expected, fmt.Sprintf("unexpected error for %s", test.desc))
+	}
+}
+
+//test that the built in rules are read from the struct tags and that invalid tags are caught
+func TestTagRules(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "produce", produceItemRules[0].Label, "label tag not read")
+	assert.Equal(t, "produce_code", produceItemRules[0].Format, "format option not read")
+
+	type badTag struct {
+		Name string `json:"name" validate:"required,max_length=ten"`
+	}
+	assert.Panics(t, func() { mustTagRules(reflect.TypeOf(badTag{})) }, "invalid tag accepted")
+}
+
+func TestHandleRules(t *testing.T) {
+	rulesUrl := "/api/rules"
+	f := newFixture(t, nil)
+	f.db.adminKey = "admin-key"
+	reinitTenants()
+
+	var ruleTests = []struct {
+		desc         string
+		tenant       string
+		admin        bool
+		method       string
+		url          string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"no rules", "", false, "GET", rulesUrl, "", 200, `{"rules":[]}`},
+		//
+		{"set rules without admin key", "", false, "PUT", rulesUrl, `{"rules":[{"field":"name","max_length":12}]}`, 401,
+			"error 401 - missing or invalid admin api key\n"},
+		//
+		{"set rules with tenant key", "acme", false, "PUT", rulesUrl, `{"rules":[{"field":"name","max_length":12}]}`, 401,
+			"error 401 - missing or invalid admin api key\n"},
+		//
+		{"invalid rules", "", true, "PUT", rulesUrl, `{"rules":[{"field":"colour"}]}`, 400, "error 400 - unknown field \"colour\"\n"},
+		//
+		{"set rules", "", true, "PUT", rulesUrl, `{"rules":[{"field":"name","max_length":12},{"field":"unit_price","max":"$20","when":{"field":"price_unit","in":["lb","kg"]}}]}`, 200,
+			`{"rules":[{"field":"name","max_length":12},{"field":"unit_price","max":"$20","when":{"field":"price_unit","in":["lb","kg"]}}]}`},
+		//
+		{"rules add to built in rules", "", false, "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Organic Green Kale","unit_price":"$25.00","price_unit":"lb"}`, 400,
+			`{"validationError":{"name":["name must be at most 12 long"],"unit_price":["unit price must be at most $20"]}}`},
+		//
+		{"item within rules", "", false, "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$25.00"}`, 201,
+			`{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$25.00"}`},
+		//
+		{"other tenant unaffected", "acme", false, "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Organic Green Kale","unit_price":"$25.00","price_unit":"lb"}`, 201,
+			`{"produce_code":"1111-1111-1111-1111","name":"Organic Green Kale","unit_price":"$25.00","price_unit":"lb"}`},
+		//
+		{"other tenant has no rules", "acme", false, "GET", rulesUrl, "", 200, `{"rules":[]}`},
+	}
+
+	for _, item := range ruleTests {
+		var headers []string
+		if item.tenant != "" {
+			headers = tenantHeaders(item.tenant)
+		}
+		if item.admin {
+			headers = append(headers, "Authorization", "Bearer admin-key")
+		}
+		f.request(item.method, item.url, item.body, headers...).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
+
+func TestHandleStoreRules(t *testing.T) {
+	t.Parallel()
+	storeUrl := "/api/stores/columbus-1"
+	f := newFixture(t, nil)
+	f.db.adminKey = "admin-key"
+	f.db.addStore(Store{ID: "columbus-1", Name: "Columbus"})
+	admin := []string{"Authorization", "Bearer admin-key"}
+
+	var ruleTests = []struct {
+		desc         string
+		method       string
+		url          string
+		body         string
+		headers      []string
+		statusCode   int
+		expectedBody string
+	}{
+		{"no store rules", "GET", storeUrl + "/rules", "", nil, 200, `{"rules":[]}`},
+		//
+		{"unknown store", "GET", "/api/stores/dayton-2/rules", "", nil, 404, "error 404 - store does not exist\n"},
+		//
+		{"set without admin key", "PUT", storeUrl + "/rules", `{"rules":[{"field":"unit_price","min":"$1"}]}`, nil, 401,
+			"error 401 - missing or invalid admin api key\n"},
+		//
+		{"set with wrong admin key", "PUT", storeUrl + "/rules", `{"rules":[{"field":"unit_price","min":"$1"}]}`,
+			[]string{"Authorization", "Bearer other-key"}, 401, "error 401 - missing or invalid admin api key\n"},
+		//
+		{"invalid store rules", "PUT", storeUrl + "/rules", `{"rules":[{"field":"colour"}]}`, admin, 400,
+			"error 400 - unknown field \"colour\"\n"},
+		//
+		{"set for unknown store", "PUT", "/api/stores/dayton-2/rules", `{"rules":[]}`, admin, 404,
+			"error 404 - store does not exist\n"},
+		//
+		{"set store rules", "PUT", storeUrl + "/rules", `{"rules":[{"field":"unit_price","min":"$1","message":"store prices start at $1"}]}`, admin, 200,
+			`{"rules":[{"field":"unit_price","min":"$1","message":"store prices start at $1"}]}`},
+		//
+		{"override breaks store rules", "PUT", storeUrl + "/produce/A12T-4GH7-QPL9-3N4M", `{"unit_price":"$0.99"}`, nil, 400,
+			`{"validationError":{"unit_price":["store prices start at $1"]}}`},
+		//
+		{"override within store rules", "PUT", storeUrl + "/produce/A12T-4GH7-QPL9-3N4M", `{"unit_price":"$1.99"}`, nil, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$1.99","available":true,"master_price":"$3.46"}`},
+		//
+		{"master catalog unaffected", "POST", "/api/produce", `{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$0.50"}`, nil, 201,
+			`{"produce_code":"1111-1111-1111-1111","name":"Kale","unit_price":"$0.50"}`},
+		//
+		{"store rules listed", "GET", storeUrl + "/rules", "", nil, 200,
+			`{"rules":[{"field":"unit_price","min":"$1","message":"store prices start at $1"}]}`},
+		//
+		{"delete store", "DELETE", storeUrl, "", nil, 200, `{"store_id":"columbus-1","name":"Columbus"}`},
+		//
+		{"recreate store", "POST", "/api/stores", `{"store_id":"columbus-1","name":"Columbus"}`, nil, 201,
+			`{"store_id":"columbus-1","name":"Columbus"}`},
+		//
+		{"rules removed with store", "GET", storeUrl + "/rules", "", nil, 200, `{"rules":[]}`},
+	}
+
+	for _, item := range ruleTests {
+		f.request(item.method, item.url, item.body, item.headers...).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
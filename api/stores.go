@@ -0,0 +1,412 @@
+//contains store catalogs that merge the master produce catalog with per store price and availability overrides, along
+//with their handler functions
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//type to store a store. Its catalog is the master catalog merged with its overrides. Carts of the store are taxed with
+//the tax table of its Jurisdiction, the active tax table of the tenant when it is left out.
+type Store struct {
+	ID           string `json:"store_id"`
+	Name         string `json:"name"`
+	Jurisdiction string `json:"jurisdiction,omitempty"`
+}
+
+//error returned when pricing for a store that does not exist
+var errStoreNotFound = errors.New("store does not exist")
+
+//type to store the overrides of a store for one produce item. A nil field falls back to the master catalog.
+type StoreOverride struct {
+	ProduceCode string  `json:"produce_code"`
+	UnitPrice   *string `json:"unit_price,omitempty"`
+	Available   *bool   `json:"available,omitempty"`
+}
+
+//type to store a produce item as sold in a store. MasterPrice is only set when the store overrides the price.
+type StoreItem struct {
+	pricedItem
+	Available   bool   `json:"available"`
+	MasterPrice string `json:"master_price,omitempty"`
+}
+
+//type to hold the stores of a database and their overrides keyed by produce code
+type storeRegistry struct {
+	mu        sync.RWMutex
+	stores    []Store
+	overrides map[string]map[string]StoreOverride
+}
+
+//checks that the store id is a short lowercase slug, the store has a name and a tax table of db is loaded for its
+//jurisdiction
+func (store *Store) validateStore(db *DBObject) url.Values {
+	errs := url.Values{}
+
+	if store.ID == "" {
+		errs.Add("store_id", "store id field is required")
+	}
+	if match, _ := regexp.MatchString(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`, store.ID); !match {
+		errs.Add("store_id", "invalid store id format")
+	}
+	if store.Name == "" {
+		errs.Add("name", "name field is required")
+	}
+	if _, ok := db.taxBook().jurisdictionTable(store.Jurisdiction); !ok {
+		errs.Add("jurisdiction", "jurisdiction has no tax table")
+	}
+	return errs
+}
+
+//checks that an overridden price is a valid unit price
+func (override *StoreOverride) validateStoreOverride() url.Values {
+	errs := url.Values{}
+
+	if override.UnitPrice != nil && !isValidUnitPrice(*override.UnitPrice) {
+		errs.Add("unit_price", "invalid unit price format")
+	}
+	return errs
+}
+
+//adds a store, returning false if the id is already taken
+func (db *DBObject) addStore(store Store) bool {
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+
+	for _, existing := range db.stores.stores {
+		if existing.ID == store.ID {
+			return false
+		}
+	}
+	db.stores.stores = append(db.stores.stores, store)
+	if db.stores.overrides == nil {
+		db.stores.overrides = map[string]map[string]StoreOverride{}
+	}
+	db.stores.overrides[store.ID] = map[string]StoreOverride{}
+	return true
+}
+
+//returns all stores
+func (db *DBObject) getStores() []Store {
+	db.stores.mu.RLock()
+	defer db.stores.mu.RUnlock()
+	return append([]Store{}, db.stores.stores...)
+}
+
+//returns the store of the given id or false if it does not exist
+func (db *DBObject) getStore(id string) (Store, bool) {
+	for _, store := range db.getStores() {
+		if store.ID == id {
+			return store, true
+		}
+	}
+	return Store{}, false
+}
+
+//removes the store of the given id along with its overrides, returning false if it does not exist
+func (db *DBObject) removeStore(id string) (Store, bool) {
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+
+	for index, store := range db.stores.stores {
+		if store.ID == id {
+			db.stores.stores = append(db.stores.stores[:index], db.stores.stores[index+1:]...)
+			delete(db.stores.overrides, id)
+			db.removeStoreRules(id)
+			return store, true
+		}
+	}
+	return Store{}, false
+}
+
+//sets the override of a store for a produce code and returns the master item of the code. The item is looked up under
+//the write lock so an override can not be set for an item deleted meanwhile, errProduceNotFound is returned if it does
+//not exist and errStoreNotFound if the store does not exist. A referenceError is returned if the item as sold in the
+//store breaks the rules of the store.
+func (db *DBObject) setStoreOverride(ctx context.Context, id string, override StoreOverride) (ProduceItem, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return ProduceItem{}, err
+	}
+	current := db.catalog()
+
+	override.ProduceCode = strings.ToUpper(override.ProduceCode)
+	index := current.index(override.ProduceCode)
+	if index < 0 {
+		return ProduceItem{}, errProduceNotFound
+	}
+
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+	overrides, ok := db.stores.overrides[id]
+	if !ok {
+		return ProduceItem{}, errStoreNotFound
+	}
+
+	sold := current.Data[index].clone()
+	if override.UnitPrice != nil {
+		sold.UnitPrice = *override.UnitPrice
+	}
+	if errs := checkRules(db, &sold, db.storeRules(id).Rules); len(errs) > 0 {
+		return ProduceItem{}, referenceError{errs}
+	}
+	overrides[override.ProduceCode] = override
+	return current.Data[index].clone(), nil
+}
+
+//removes the override of a store for a produce code, returning false if there was none
+func (db *DBObject) removeStoreOverride(id string, pCode string) (StoreOverride, bool) {
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+
+	override, ok := db.stores.overrides[id][pCode]
+	if ok {
+		delete(db.stores.overrides[id], pCode)
+	}
+	return override, ok
+}
+
+//moves the overrides of every store from one produce code to another after the master item was renamed
+func (db *DBObject) renameStoreOverrides(oldCode string, newCode string) {
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+
+	for _, overrides := range db.stores.overrides {
+		if override, ok := overrides[oldCode]; ok {
+			delete(overrides, oldCode)
+			override.ProduceCode = newCode
+			overrides[newCode] = override
+		}
+	}
+}
+
+//removes the overrides of every store for a produce code after the master item was deleted
+func (db *DBObject) removeItemOverrides(pCode string) {
+	db.stores.mu.Lock()
+	defer db.stores.mu.Unlock()
+
+	for _, overrides := range db.stores.overrides {
+		delete(overrides, pCode)
+	}
+}
+
+//merges a master item with the override of a store and applies the promotions active now to the store price
+func (db *DBObject) storeItem(pItem ProduceItem, override StoreOverride) StoreItem {
+	item := StoreItem{Available: true}
+	if override.UnitPrice != nil && *override.UnitPrice != pItem.UnitPrice {
+		item.MasterPrice = pItem.UnitPrice
+		pItem.UnitPrice = *override.UnitPrice
+	}
+	if override.Available != nil {
+		item.Available = *override.Available
+	}
+	item.pricedItem = db.withEffectivePrice(pItem)
+	return item
+}
+
+//returns the overrides of a store, false if the store does not exist
+func (db *DBObject) storeOverrides(id string) (map[string]StoreOverride, bool) {
+	db.stores.mu.RLock()
+	defer db.stores.mu.RUnlock()
+
+	overrides, ok := db.stores.overrides[id]
+	if !ok {
+		return nil, false
+	}
+	copied := make(map[string]StoreOverride, len(overrides))
+	for pCode, override := range overrides {
+		copied[pCode] = override
+	}
+	return copied, true
+}
+
+//This function lists the stores with a 200 status code.
+func handleGetStores(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	jsonResponse(w, http.StatusOK, db.getStores())
+}
+
+//This function creates a store from the JSON body and returns it with a 201 status code. Invalid JSON or a store
+//that fails validation triggers a status 400 and a store id that is already taken a status 409.
+func handleCreateStore(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var store Store
+
+	err := json.NewDecoder(r.Body).Decode(&store) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	validErrs := store.validateStore(db)
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if !db.addStore(store) {
+		http.Error(w, "error 409 - store id already exists", 409)
+		return
+	}
+	jsonResponse(w, http.StatusCreated, store)
+}
+
+//This function returns the store of the id in the URL with a 200 status code or triggers a 404.
+func handleGetStore(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	store, ok := db.getStore(mux.Vars(r)["store_id"])
+	if !ok {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, store)
+}
+
+//This function removes the store of the id in the URL along with its overrides and returns it with a 200 status code
+//or triggers a 404.
+func handleDeleteStore(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	store, ok := db.removeStore(mux.Vars(r)["store_id"])
+	if !ok {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, store)
+}
+
+//This function lists the catalog of the store in the URL, every master item merged with the overrides of the store,
+//with a 200 status code. Unavailable items are included with available false unless available=true is given. An
+//unknown store triggers a status 404.
+func handleGetStoreProduce(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	overrides, ok := db.storeOverrides(mux.Vars(r)["store_id"])
+	if !ok {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+	availableOnly := r.URL.Query().Get("available") == "true"
+
+	allItems, err := db.getAllProduceItems(r.Context()) //get all items from DB
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+
+	items := []StoreItem{}
+	for _, pItem := range allItems {
+		item := db.storeItem(pItem, overrides[pItem.ProduceCode])
+		if item.Available || !availableOnly {
+			items = append(items, item)
+		}
+	}
+	jsonResponse(w, http.StatusOK, items)
+}
+
+//This function returns the produce code in the URL as sold in the store in the URL with a 200 status code. An
+//invalid code triggers a status 400, an unknown store or code a status 404.
+func handleGetStoreProduceItem(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	params["produce_code"] = strings.ToUpper(params["produce_code"])
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	overrides, ok := db.storeOverrides(params["store_id"])
+	if !ok {
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	}
+
+	pItem, err := db.getProduceItem(r.Context(), params["produce_code"]) // get item of corresponding code from DB
+
+	//if produce code not found
+	if err == errProduceNotFound {
+		http.Error(w, "error 404 - produce code does not exist", 404)
+		return
+	}
+	if err != nil {
+		modelErrorResponse(w, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, db.storeItem(pItem, overrides[pItem.ProduceCode]))
+}
+
+//This function sets the price and availability override of the store in the URL for the produce code in the URL from
+//the JSON body and returns the merged item with a 200 status code. Fields left out of the body fall back to the master
+//catalog. An invalid code, invalid JSON, an invalid price or an item that breaks the rules of the store triggers a
+//status 400, an unknown store or code a 404.
+func handleSetStoreOverride(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var override StoreOverride
+	params := mux.Vars(r)
+
+	//check if produce code format is valid
+	params["produce_code"] = strings.ToUpper(params["produce_code"])
+	if !db.isValidProduceCode(params["produce_code"]) {
+		http.Error(w, "error 400 - invalid produce code format", 400)
+		return
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&override) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	validErrs := override.validateStoreOverride()
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	override.ProduceCode = params["produce_code"]
+	pItem, err := db.setStoreOverride(r.Context(), params["store_id"], override) // overrides can only be set for master items
+
+	switch err {
+	case nil:
+	case errProduceNotFound:
+		http.Error(w, "error 404 - produce code does not exist", 404)
+		return
+	case errStoreNotFound:
+		http.Error(w, "error 404 - store does not exist", 404)
+		return
+	default:
+		modelErrorResponse(w, err)
+		return
+	}
+	jsonResponse(w, http.StatusOK, db.storeItem(pItem, override))
+}
+
+//This function removes the override of the store in the URL for the produce code in the URL so the master catalog
+//applies again, returning the removed override with a 200 status code or triggering a 404 if there was none.
+func handleDeleteStoreOverride(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	params := mux.Vars(r)
+
+	override, ok := db.removeStoreOverride(params["store_id"], strings.ToUpper(params["produce_code"]))
+	if !ok {
+		http.Error(w, "error 404 - store override does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, override)
+}
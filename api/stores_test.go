@@ -0,0 +1,108 @@
+//tests for stores.go
+package api
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHandleStores(t *testing.T) {
+	t.Parallel()
+	storeUrl := "/api/stores"
+	f := newFixture(t, seedItems()[:2])
+
+	var storeTests = []struct {
+		desc         string
+		method       string
+		path         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"invalid store", "POST", "", `{"store_id":"Columbus 1"}`, 400,
+			`{"validationError":{"name":["name field is required"],"store_id":["invalid store id format"]}}`},
+		//
+		{"create store", "POST", "", `{"store_id":"columbus-1","name":"Columbus"}`, 201,
+			`{"store_id":"columbus-1","name":"Columbus"}`},
+		//
+		{"duplicate store", "POST", "", `{"store_id":"columbus-1","name":"Columbus"}`, 409,
+			"error 409 - store id already exists\n"},
+		//
+		{"listing without overrides", "GET", "/columbus-1/produce", "", 200,
+			`[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","available":true},{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","available":true}]`},
+		//
+		{"override price", "PUT", "/columbus-1/produce/a12t-4gh7-qpl9-3n4m", `{"unit_price":"$2.99"}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$2.99","available":true,"master_price":"$3.46"}`},
+		//
+		{"invalid override price", "PUT", "/columbus-1/produce/A12T-4GH7-QPL9-3N4M", `{"unit_price":"2.99"}`, 400,
+			`{"validationError":{"unit_price":["invalid unit price format"]}}`},
+		//
+		{"override availability", "PUT", "/columbus-1/produce/E5T6-9UI3-TH15-QR88", `{"available":false}`, 200,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","available":false}`},
+		//
+		{"merged listing", "GET", "/columbus-1/produce", "", 200,
+			`[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$2.99","available":true,"master_price":"$3.46"},{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","available":false}]`},
+		//
+		{"available only listing", "GET", "/columbus-1/produce?available=true", "", 200,
+			`[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$2.99","available":true,"master_price":"$3.46"}]`},
+		//
+		{"remove override", "DELETE", "/columbus-1/produce/A12T-4GH7-QPL9-3N4M", "", 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","unit_price":"$2.99"}`},
+		//
+		{"master price again", "GET", "/columbus-1/produce/A12T-4GH7-QPL9-3N4M", "", 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.46","available":true}`},
+		//
+		{"override unknown item", "PUT", "/columbus-1/produce/A12T-4GH7-QPL9-0000", `{"available":false}`, 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"unknown store", "GET", "/dayton-2/produce", "", 404, "error 404 - store does not exist\n"},
+		//
+		{"delete store", "DELETE", "/columbus-1", "", 200, `{"store_id":"columbus-1","name":"Columbus"}`},
+	}
+
+	for _, item := range storeTests {
+		f.request(item.method, storeUrl+item.path, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
+
+//test that overrides follow a master item when its code is changed
+func TestStoreOverrideRename(t *testing.T) {
+	t.Parallel()
+	db := newTestDB(nil)
+	available := false
+	db.addStore(Store{ID: "rename-test", Name: "Rename"})
+	db.setStoreOverride(context.Background(), "rename-test", StoreOverride{ProduceCode: "2222-2222-2222-2222", Available: &available})
+
+	db.updateProduceItem(context.Background(), "2222-2222-2222-2222", ProduceItem{ProduceCode: "3333-3333-3333-3333", Name: "Gala Apple", UnitPrice: "$3.59"})
+
+	overrides, _ := db.storeOverrides("rename-test")
+	_, oldFound := overrides["2222-2222-2222-2222"]
+	assert.False(t, oldFound, "override left on old code")
+	assert.Equal(t, &available, overrides["3333-3333-3333-3333"].Available, "override not moved to new code")
+}
+
+//test that overrides are only set for existing items and removed along with a deleted item
+func TestStoreOverrideDelete(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := newTestDB(nil)
+	available := false
+	db.addStore(Store{ID: "delete-test", Name: "Delete"})
+
+	_, err := db.setStoreOverride(ctx, "other-store", StoreOverride{ProduceCode: "2222-2222-2222-2222", Available: &available})
+	assert.Equal(t, errStoreNotFound, err, "override set for unknown store")
+	pItem, err := db.setStoreOverride(ctx, "delete-test", StoreOverride{ProduceCode: "2222-2222-2222-2222", Available: &available})
+	assert.Nil(t, err, "override not set")
+	assert.Equal(t, "Gala Apple", pItem.Name, "unexpected master item")
+
+	db.deleteProduceItem(ctx, "2222-2222-2222-2222")
+	overrides, _ := db.storeOverrides("delete-test")
+	assert.Equal(t, 0, len(overrides), "override left on deleted item")
+	_, err = db.setStoreOverride(ctx, "delete-test", StoreOverride{ProduceCode: "2222-2222-2222-2222", Available: &available})
+	assert.Equal(t, errProduceNotFound, err, "override set for deleted item")
+
+	db.restoreProduceItem(ctx, "2222-2222-2222-2222")
+	overrides, _ = db.storeOverrides("delete-test")
+	assert.Equal(t, 0, len(overrides), "override came back with restored item")
+}
@@ -0,0 +1,236 @@
+//contains the sales tax rate tables of jurisdictions and the rounding rules used to compute tax, along with its
+//handler function
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+)
+
+//rounding modes a jurisdiction can require for tax amounts
+const (
+	roundHalfUp   = "half_up"   //halves are rounded away from zero
+	roundHalfEven = "half_even" //halves are rounded to the even cent
+	roundUp       = "up"        //any fraction of a cent is rounded up
+)
+
+//levels tax can be rounded at
+const (
+	roundPerLine    = "line"    //tax is rounded on every line and the rounded amounts are added up
+	roundPerInvoice = "invoice" //tax is added up exactly and rounded once for the whole cart
+)
+
+//type to store the tax rates of a jurisdiction. Rates maps a tax category to a rate given as a decimal fraction from 0
+//to 1, items without a tax category are taxed at the rate of DefaultCategory.
+type TaxTable struct {
+	Jurisdiction    string            `json:"jurisdiction"`
+	Rounding        string            `json:"rounding"`
+	RoundPer        string            `json:"round_per"`
+	DefaultCategory string            `json:"default_category"`
+	Rates           map[string]string `json:"rates"`
+	rates           map[string]*big.Rat
+}
+
+//type to hold the tax settings of a database, the tables loaded for its jurisdictions and the rate everything is
+//taxed at while no table is loaded
+type taxBook struct {
+	mu     sync.RWMutex
+	active *TaxTable            //first table loaded, nil until one is loaded
+	tables map[string]*TaxTable //tables loaded by jurisdiction
+	rate   *big.Rat
+}
+
+//tax settings of the default database and of every tenant that has none of its own
+var defaultTaxes = &taxBook{rate: new(big.Rat)}
+
+//sets the rate everything is taxed at while no tax table is loaded. The rate is a decimal fraction, e.g. "0.0575", and
+//an error is returned if it is not a number from 0 to 1.
+func SetSalesTaxRate(rate string) error {
+	return defaultTaxes.setSalesTaxRate(rate)
+}
+
+//reads a tax table from a JSON file and adds it as the table of its jurisdiction, stores in the jurisdiction price
+//carts with it. The first table loaded is also used for carts not priced for a store. The file is checked in full
+//before it is added and an error is returned if a table of the same jurisdiction was loaded already.
+func LoadTaxTable(path string) error {
+	return defaultTaxes.loadTaxTable(path)
+}
+
+//sets the rate of the book, see SetSalesTaxRate
+func (book *taxBook) setSalesTaxRate(rate string) error {
+	parsed, ok := new(big.Rat).SetString(rate)
+	if !ok || parsed.Sign() < 0 || parsed.Cmp(big.NewRat(1, 1)) > 0 {
+		return fmt.Errorf("invalid sales tax rate %q, expected a decimal fraction such as 0.0575", rate)
+	}
+
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	book.rate = parsed
+	return nil
+}
+
+//adds a table from a JSON file to the book, see LoadTaxTable
+func (book *taxBook) loadTaxTable(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	table, err := parseTaxTable(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	book.mu.Lock()
+	defer book.mu.Unlock()
+	if _, ok := book.tables[table.Jurisdiction]; ok {
+		return fmt.Errorf("%s: a table of jurisdiction %q is already loaded", path, table.Jurisdiction)
+	}
+	if book.tables == nil {
+		book.tables = map[string]*TaxTable{}
+	}
+	book.tables[table.Jurisdiction] = table
+	if book.active == nil {
+		book.active = table
+	}
+	return nil
+}
+
+//decodes and checks a tax table. Rounding defaults to half_up and RoundPer to line.
+func parseTaxTable(data []byte) (*TaxTable, error) {
+	var table TaxTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("invalid JSON syntax: %v", err)
+	}
+
+	if table.Rounding == "" {
+		table.Rounding = roundHalfUp
+	}
+	if table.Rounding != roundHalfUp && table.Rounding != roundHalfEven && table.Rounding != roundUp {
+		return nil, fmt.Errorf("unknown rounding %q", table.Rounding)
+	}
+	if table.RoundPer == "" {
+		table.RoundPer = roundPerLine
+	}
+	if table.RoundPer != roundPerLine && table.RoundPer != roundPerInvoice {
+		return nil, fmt.Errorf("unknown round_per %q", table.RoundPer)
+	}
+
+	table.rates = map[string]*big.Rat{}
+	for category, rate := range table.Rates {
+		parsed, ok := new(big.Rat).SetString(rate)
+		if !ok || parsed.Sign() < 0 || parsed.Cmp(big.NewRat(1, 1)) > 0 {
+			return nil, fmt.Errorf("invalid rate %q for category %q, expected a decimal fraction from 0 to 1", rate, category)
+		}
+		table.rates[category] = parsed
+	}
+	if _, ok := table.rates[table.DefaultCategory]; !ok {
+		return nil, fmt.Errorf("default category %q has no rate", table.DefaultCategory)
+	}
+	return &table, nil
+}
+
+//returns the tax settings of the database, the default settings unless the tenant was given its own
+func (db *DBObject) taxBook() *taxBook {
+	if db.taxes != nil {
+		return db.taxes
+	}
+	return defaultTaxes
+}
+
+//returns the first loaded tax table or, if none was loaded, a table that taxes everything at the sales tax rate rounded
+//once per cart
+func (book *taxBook) activeTable() *TaxTable {
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	if book.active != nil {
+		return book.active
+	}
+
+	return &TaxTable{
+		Rounding:        roundHalfUp,
+		RoundPer:        roundPerInvoice,
+		DefaultCategory: "general",
+		Rates:           map[string]string{"general": book.rate.FloatString(6)},
+		rates:           map[string]*big.Rat{"general": book.rate},
+	}
+}
+
+//returns the tax table loaded for a jurisdiction, false if none was. An empty jurisdiction returns the active table.
+func (book *taxBook) jurisdictionTable(jurisdiction string) (*TaxTable, bool) {
+	if jurisdiction == "" {
+		return book.activeTable(), true
+	}
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	table, ok := book.tables[jurisdiction]
+	return table, ok
+}
+
+//checks if the tax category has a rate in the active table or in the table of any jurisdiction
+func (book *taxBook) knownCategory(category string) bool {
+	if _, ok := book.activeTable().rate(category); ok {
+		return true
+	}
+	book.mu.RLock()
+	defer book.mu.RUnlock()
+	for _, table := range book.tables {
+		if _, ok := table.rate(category); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//returns the rate of a tax category, an empty category uses the default category. false is returned for unknown
+//categories.
+func (table *TaxTable) rate(category string) (*big.Rat, bool) {
+	if category == "" {
+		category = table.DefaultCategory
+	}
+	rate, ok := table.rates[category]
+	return rate, ok
+}
+
+//rounds an exact dollar amount of tax to whole cents using the rounding mode of the jurisdiction. errAmountTooLarge is
+//returned if the amount is beyond maxAmountCents.
+func (table *TaxTable) round(dollars *big.Rat) (int64, error) {
+	cents := new(big.Rat).Mul(dollars, big.NewRat(100, 1))
+	quotient, remainder := new(big.Int).QuoRem(cents.Num(), cents.Denom(), new(big.Int))
+	if remainder.Sign() == 0 {
+		return centsInRange(quotient)
+	}
+
+	away := big.NewInt(int64(cents.Sign()))
+	switch table.Rounding {
+	case roundUp:
+		quotient.Add(quotient, away)
+	case roundHalfEven:
+		switch new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(cents.Denom()) {
+		case 1:
+			quotient.Add(quotient, away)
+		case 0:
+			if quotient.Bit(0) == 1 {
+				quotient.Add(quotient, away)
+			}
+		}
+	default:
+		return roundToCents(dollars)
+	}
+	return centsInRange(quotient)
+}
+
+//This function returns the tax table of the tenant for the jurisdiction query parameter or, when it is left out, the
+//active tax table of the tenant with a 200 status code. A jurisdiction without a loaded table triggers a status 404.
+func handleGetTaxTable(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	table, ok := db.taxBook().jurisdictionTable(r.URL.Query().Get("jurisdiction"))
+	if !ok {
+		http.Error(w, "error 404 - jurisdiction has no tax table", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, table)
+}
@@ -0,0 +1,212 @@
+//tests for tax.go
+package api
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+//swaps in tax tables of the default tax settings for the duration of a test, the first becomes the active table and
+//every table the table of its jurisdiction
+func useTaxTable(t *testing.T, tablesJSON ...string) {
+	tables := map[string]*TaxTable{}
+	var first *TaxTable
+	for _, tableJSON := range tablesJSON {
+		table, err := parseTaxTable([]byte(tableJSON))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tables[table.Jurisdiction] = table
+		if first == nil {
+			first = table
+		}
+	}
+	defaultTaxes.mu.Lock()
+	previous, previousTables := defaultTaxes.active, defaultTaxes.tables
+	defaultTaxes.active, defaultTaxes.tables = first, tables
+	defaultTaxes.mu.Unlock()
+	t.Cleanup(func() {
+		defaultTaxes.mu.Lock()
+		defaultTaxes.active, defaultTaxes.tables = previous, previousTables
+		defaultTaxes.mu.Unlock()
+	})
+}
+
+//sets the default sales tax rate for the duration of a test
+func useSalesTaxRate(t *testing.T, rate string) {
+	defaultTaxes.mu.RLock()
+	previous := defaultTaxes.rate
+	defaultTaxes.mu.RUnlock()
+	if err := SetSalesTaxRate(rate); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		defaultTaxes.mu.Lock()
+		defaultTaxes.rate = previous
+		defaultTaxes.mu.Unlock()
+	})
+}
+
+//test that only decimal fractions from 0 to 1 are accepted as the sales tax rate
+func TestSetSalesTaxRate(t *testing.T) {
+	useSalesTaxRate(t, "0")
+	var rateTests = []struct {
+		rate    string
+		isError bool
+	}{
+		{"0.0575", false},
+		{"1", false},
+		{"0", false},
+		{"5.75%", true},
+		{"-0.01", true},
+		{"1.5", true},
+		{"", true},
+	}
+
+	for _, item := range rateTests {
+		err := SetSalesTaxRate(item.rate)
+		assert.Equal(t, item.isError, err != nil, fmt.Sprintf("unexpected result for `%s`", item.rate))
+	}
+}
+
+//test that malformed tables are rejected
+func TestParseTaxTable(t *testing.T) {
+	t.Parallel()
+	var parseTests = []struct {
+		desc      string
+		tableJSON string
+		isError   bool
+	}{
+		{"valid table", `{"default_category":"grocery","rates":{"grocery":"0","prepared":"0.075"}}`, false},
+		{"default category without rate", `{"default_category":"general","rates":{"grocery":"0"}}`, true},
+		{"negative rate", `{"default_category":"grocery","rates":{"grocery":"-0.01"}}`, true},
+		{"rate above 1", `{"default_category":"grocery","rates":{"grocery":"0","luxury":"2.5"}}`, true},
+		{"rate of 1", `{"default_category":"grocery","rates":{"grocery":"1"}}`, false},
+		{"unknown rounding", `{"rounding":"bankers","default_category":"grocery","rates":{"grocery":"0"}}`, true},
+		{"unknown round per", `{"round_per":"item","default_category":"grocery","rates":{"grocery":"0"}}`, true},
+		{"bad JSON syntax", `{"rates":`, true},
+	}
+
+	for _, item := range parseTests {
+		_, err := parseTaxTable([]byte(item.tableJSON))
+		assert.Equal(t, item.isError, err != nil, fmt.Sprintf("unexpected result for %s", item.desc))
+	}
+}
+
+//test the rounding modes on exact tax amounts
+func TestTaxRounding(t *testing.T) {
+	t.Parallel()
+	var roundingTests = []struct {
+		rounding string
+		dollars  string
+		expected int64
+	}{
+		{roundHalfUp, "0.125", 13},
+		{roundHalfUp, "0.1249", 12},
+		{roundHalfEven, "0.125", 12},
+		{roundHalfEven, "0.135", 14},
+		{roundHalfEven, "0.1251", 13},
+		{roundUp, "0.1201", 13},
+		{roundUp, "0.12", 12},
+	}
+
+	for _, item := range roundingTests {
+		table := TaxTable{Rounding: item.rounding}
+		dollars, _ := new(big.Rat).SetString(item.dollars)
+		cents, err := table.round(dollars)
+		assert.Nil(t, err, fmt.Sprintf("unexpected error on %s rounding of `%s`", item.rounding, item.dollars))
+		assert.Equal(t, item.expected, cents, fmt.Sprintf("On %s rounding of `%s`", item.rounding, item.dollars))
+	}
+}
+
+//test per line tax by category and the difference between rounding per line and per invoice
+func TestCartTax(t *testing.T) {
+	var cartTaxTests = []struct {
+		desc      string
+		tableJSON string
+		lineTaxes []string
+		tax       string
+	}{
+		{"round per line", `{"round_per":"line","default_category":"grocery","rates":{"grocery":"0","prepared":"0.06"}}`,
+			[]string{"$0.05", "$0.05", "$0.00"}, "$0.10"},
+		{"round per invoice", `{"round_per":"invoice","default_category":"grocery","rates":{"grocery":"0","prepared":"0.06"}}`,
+			[]string{"$0.05", "$0.05", "$0.00"}, "$0.09"},
+	}
+
+	for _, item := range cartTaxTests {
+		seed := seedItems()
+		seed[2].TaxCategory = "prepared"
+		f := newFixture(t, seed)
+		useTaxTable(t, item.tableJSON)
+		var totals CartTotals
+		f.request("POST", "/api/cart", `{"items":[{"produce_code":"YRT6-72AS-K736-L4AR"},{"produce_code":"YRT6-72AS-K736-L4AR"},{"produce_code":"2222-2222-2222-2222"}]}`).decode(t, &totals)
+
+		for index, lineTax := range item.lineTaxes {
+			assert.Equal(t, lineTax, totals.Lines[index].Tax, fmt.Sprintf("unexpected line %d tax for %s", index, item.desc))
+		}
+		assert.Equal(t, item.tax, totals.Tax, fmt.Sprintf("unexpected tax for %s", item.desc))
+	}
+}
+
+//test that items can only use categories of the active table
+func TestValidateTaxCategory(t *testing.T) {
+	useTaxTable(t, `{"default_category":"grocery","rates":{"grocery":"0","prepared":"0.075"}}`)
+	pItem := ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Salad", UnitPrice: "$4.99", TaxCategory: "prepared"}
+	assert.Equal(t, 0, len(pItem.validateProduceItem(&DBObject{})), "known category rejected")
+
+	pItem.TaxCategory = "alcohol"
+	assert.Equal(t, []string{"unknown tax category"}, pItem.validateProduceItem(&DBObject{})["tax_category"], "unknown category accepted")
+}
+
+//test that carts of a store are priced as sold in the store and taxed with the table of its jurisdiction
+func TestStoreJurisdiction(t *testing.T) {
+	useTaxTable(t, `{"jurisdiction":"OH-Franklin","default_category":"grocery","rates":{"grocery":"0"}}`,
+		`{"jurisdiction":"KY-Jefferson","default_category":"grocery","rates":{"grocery":"0.06"}}`)
+	f := newFixture(t, seedItems()[:2])
+	cartJSON := `{"items":[{"produce_code":"A12T-4GH7-QPL9-3N4M"},{"produce_code":"E5T6-9UI3-TH15-QR88"}]}`
+
+	var jurisdictionTests = []struct {
+		desc         string
+		method       string
+		path         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"store without tax table", "POST", "/api/stores", `{"store_id":"muncie-1","name":"Muncie","jurisdiction":"IN-Delaware"}`, 400,
+			`{"validationError":{"jurisdiction":["jurisdiction has no tax table"]}}`},
+		//
+		{"create store", "POST", "/api/stores", `{"store_id":"louisville-1","name":"Louisville","jurisdiction":"KY-Jefferson"}`, 201,
+			`{"store_id":"louisville-1","name":"Louisville","jurisdiction":"KY-Jefferson"}`},
+		//
+		{"override price", "PUT", "/api/stores/louisville-1/produce/A12T-4GH7-QPL9-3N4M", `{"unit_price":"$3.00"}`, 200,
+			`{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","unit_price":"$3.00","available":true,"master_price":"$3.46"}`},
+		//
+		{"override availability", "PUT", "/api/stores/louisville-1/produce/E5T6-9UI3-TH15-QR88", `{"available":false}`, 200,
+			`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","unit_price":"$2.99","available":false}`},
+		//
+		{"store cart", "POST", "/api/stores/louisville-1/cart", cartJSON, 200,
+			`{"lines":[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","quantity":"1","unit":"each","unit_price":"$3.00","price_unit":"each","extended_price":"$3.00","tax":"$0.18"},` +
+				`{"produce_code":"E5T6-9UI3-TH15-QR88","error":"error 404 - produce code is not sold in this store"}],` +
+				`"subtotal":"$3.00","tax":"$0.18","total":"$3.18"}`},
+		//
+		{"master cart", "POST", "/api/cart", cartJSON, 200,
+			`{"lines":[{"produce_code":"A12T-4GH7-QPL9-3N4M","name":"Lettuce","quantity":"1","unit":"each","unit_price":"$3.46","price_unit":"each","extended_price":"$3.46","tax":"$0.00"},` +
+				`{"produce_code":"E5T6-9UI3-TH15-QR88","name":"Peach","quantity":"1","unit":"each","unit_price":"$2.99","price_unit":"each","extended_price":"$2.99","tax":"$0.00"}],` +
+				`"subtotal":"$6.45","tax":"$0.00","total":"$6.45"}`},
+		//
+		{"unknown store cart", "POST", "/api/stores/dayton-2/cart", cartJSON, 404, "error 404 - store does not exist\n"},
+		//
+		{"table of jurisdiction", "GET", "/api/tax?jurisdiction=KY-Jefferson", "", 200,
+			`{"jurisdiction":"KY-Jefferson","rounding":"half_up","round_per":"line","default_category":"grocery","rates":{"grocery":"0.06"}}`},
+		//
+		{"jurisdiction without table", "GET", "/api/tax?jurisdiction=IN-Delaware", "", 404,
+			"error 404 - jurisdiction has no tax table\n"},
+	}
+
+	for _, item := range jurisdictionTests {
+		f.request(item.method, item.path, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+}
@@ -0,0 +1,196 @@
+//contains tenant identification and the per tenant databases that keep the catalogs of tenants apart from each other
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/gorilla/mux"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+//header a client names its tenant in
+const tenantHeader = "X-Tenant-ID"
+
+//domain tenants are served under as subdomains, e.g. with "shop.example.com" tenant acme is served at
+//acme.shop.example.com. When left empty tenants are only identified by the X-Tenant-ID header. Must be set before
+//Handlers is called.
+var TenantDomain string
+
+//type of the request context key the database of the tenant is stored under
+type tenantContextKey struct{}
+
+var (
+	tenantsMu sync.RWMutex
+	tenants   = map[string]*DBObject{} //database of every tenant keyed by tenant id
+)
+
+//key admin writes, e.g. replacing validation rules, to the default database must carry as a bearer token. It never
+//unlocks a tenant. Admin writes are refused while it is empty. Must be set before the server starts.
+var AdminAPIKey string
+
+//type to store the settings of a tenant. APIKey is required, requests for the tenant must carry it as a bearer token.
+//AdminAPIKey is the key admin writes of the tenant must carry, they are refused for a tenant without one as the
+//AdminAPIKey of the default database does not unlock tenants. The other settings fall back to those of the default
+//database when left empty: ProduceCodeScheme is a scheme as taken by ParseCodeScheme, SalesTaxRate a rate as taken by
+//SetSalesTaxRate and TaxTables the files of the tax tables of the jurisdictions of the tenant as read by LoadTaxTable.
+type TenantConfig struct {
+	APIKey            string
+	AdminAPIKey       string
+	ProduceCodeScheme string
+	SalesTaxRate      string
+	TaxTables         []string
+}
+
+//adds a tenant with an empty database of its own. The id must be a short lowercase slug so it can be used as a
+//subdomain. The settings are checked in full before the tenant is added. Adding a tenant that already exists keeps
+//its data and replaces its settings. Must be called before the server starts.
+func AddTenant(id string, config TenantConfig) error {
+	if match, _ := regexp.MatchString(`^[a-z0-9](?:[a-z0-9-]{0,30}[a-z0-9])?$`, id); !match {
+		return fmt.Errorf("invalid tenant id %q", id)
+	}
+	if config.APIKey == "" {
+		return fmt.Errorf("tenant %q has no api key", id)
+	}
+
+	var codes CodeScheme
+	if config.ProduceCodeScheme != "" {
+		scheme, err := ParseCodeScheme(config.ProduceCodeScheme)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %v", id, err)
+		}
+		codes = scheme
+	}
+	var taxes *taxBook
+	if config.SalesTaxRate != "" || len(config.TaxTables) > 0 {
+		taxes = &taxBook{rate: new(big.Rat)}
+		if config.SalesTaxRate != "" {
+			if err := taxes.setSalesTaxRate(config.SalesTaxRate); err != nil {
+				return fmt.Errorf("tenant %q: %v", id, err)
+			}
+		}
+		for _, path := range config.TaxTables {
+			if err := taxes.loadTaxTable(path); err != nil {
+				return fmt.Errorf("tenant %q: %v", id, err)
+			}
+		}
+	}
+
+	tenantsMu.Lock()
+	defer tenantsMu.Unlock()
+	db, ok := tenants[id]
+	if !ok {
+		db = &DBObject{}
+		tenants[id] = db
+	}
+	db.apiKey, db.adminKey, db.codes, db.taxes = config.APIKey, config.AdminAPIKey, codes, taxes
+	return nil
+}
+
+//returns the database of the tenant of the given id or false if the tenant does not exist
+func getTenant(id string) (*DBObject, bool) {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+	db, ok := tenants[id]
+	return db, ok
+}
+
+//returns the default database followed by the database of every tenant
+func allDatabases() []*DBObject {
+	tenantsMu.RLock()
+	defer tenantsMu.RUnlock()
+
+	dbs := []*DBObject{currentDB}
+	for _, db := range tenants {
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+//returns the database of the tenant the request was made for. Handlers must only touch the database returned here so
+//one tenant can never read or write the items of another. Requests without a tenant use the default database.
+func tenantDB(r *http.Request) *DBObject {
+	if db, ok := r.Context().Value(tenantContextKey{}).(*DBObject); ok {
+		return db
+	}
+	return currentDB
+}
+
+//returns a middleware that serves requests from db unless resolveTenant finds a tenant for them
+func withDatabase(db *DBObject) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, db)))
+		})
+	}
+}
+
+//returns the key admin writes to the database must carry. A tenant, which always has an api key, only accepts its own
+//admin key so the key of the default database can not reach into it. Only the default database falls back to
+//AdminAPIKey.
+func (db *DBObject) adminAPIKey() string {
+	if db.adminKey != "" || db.apiKey != "" {
+		return db.adminKey
+	}
+	return AdminAPIKey
+}
+
+//checks that the request carries key as a bearer token in its Authorization header. An empty key never matches.
+func hasBearerToken(r *http.Request, key string) bool {
+	header := r.Header.Get("Authorization")
+	if key == "" || !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(key)) == 1
+}
+
+//middleware that identifies the tenant of a request from its subdomain or the X-Tenant-ID header and stores the
+//database of the tenant in the request context. A subdomain and header that name different tenants trigger a status
+//400, an unknown tenant a status 404 and a request without the api key or the admin key of the tenant as its bearer
+//token a status 401. Requests that name no tenant are served from the default database.
+func resolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["tenant"]
+		header := r.Header.Get(tenantHeader)
+		if id != "" && header != "" && id != header {
+			http.Error(w, "error 400 - tenant header does not match host", http.StatusBadRequest)
+			return
+		}
+		if id == "" {
+			id = header
+		}
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		db, ok := getTenant(id)
+		if !ok {
+			http.Error(w, "error 404 - tenant does not exist", http.StatusNotFound)
+			return
+		}
+		if !hasBearerToken(r, db.apiKey) && !hasBearerToken(r, db.adminKey) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tenant"`)
+			http.Error(w, "error 401 - missing or invalid tenant api key", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, db)))
+	})
+}
+
+//returns a handler that only calls handler for requests carrying the admin key of their database as a bearer token,
+//other requests trigger a status 401
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !hasBearerToken(r, tenantDB(r).adminAPIKey()) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "error 401 - missing or invalid admin api key", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
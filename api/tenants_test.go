@@ -0,0 +1,280 @@
+//tests for tenants.go
+package api
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+//gives the acme and globex tenants an empty catalog and the default settings, their api keys are the tenant id
+//followed by "-key"
+func reinitTenants() {
+	for _, id := range []string{"acme", "globex"} {
+		AddTenant(id, TenantConfig{APIKey: id + "-key"})
+		db, _ := getTenant(id)
+		db.mu.Lock()
+		db.storeCatalog(emptyCatalog)
+		db.mu.Unlock()
+	}
+}
+
+//returns the headers of a request for the tenant made with its api key
+func tenantHeaders(tenant string) []string {
+	return []string{"X-Tenant-ID", tenant, "Authorization", "Bearer " + tenant + "-key"}
+}
+
+func TestAddTenant(t *testing.T) {
+	defer reinitTenants()
+	var addTests = []struct {
+		desc    string
+		id      string
+		config  TenantConfig
+		isError bool
+	}{
+		{"valid tenant", "acme", TenantConfig{APIKey: "acme-key"}, false},
+		{"own settings", "acme", TenantConfig{APIKey: "acme-key", ProduceCodeScheme: "sku:8", SalesTaxRate: "0.07",
+			TaxTables: []string{"../tax_rates.example.json"}}, false},
+		{"invalid tenant id", "Acme Corp", TenantConfig{APIKey: "acme-key"}, true},
+		{"empty tenant id", "", TenantConfig{APIKey: "acme-key"}, true},
+		{"no api key", "acme", TenantConfig{}, true},
+		{"unknown code scheme", "acme", TenantConfig{APIKey: "acme-key", ProduceCodeScheme: "isbn"}, true},
+		{"invalid sales tax rate", "acme", TenantConfig{APIKey: "acme-key", SalesTaxRate: "7%"}, true},
+		{"missing tax table", "acme", TenantConfig{APIKey: "acme-key", TaxTables: []string{"missing.json"}}, true},
+	}
+
+	for _, test := range addTests {
+		assert.Equal(t, test.isError, AddTenant(test.id, test.config) != nil, test.desc)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	f := newFixture(t, nil)
+	reinitTenants()
+
+	var tenantTests = []struct {
+		desc         string
+		tenant       string
+		method       string
+		path         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"create in acme", "acme", "POST", "", `{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}`, 201,
+			`{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}`},
+		//
+		{"acme lists its item", "acme", "GET", "", "", 200,
+			`[{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}]`},
+		//
+		{"globex lists nothing", "globex", "GET", "", "", 200, `[]`},
+		//
+		{"globex can not read acme item", "globex", "GET", "/AAAA-1111-AAAA-1111", "", 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"globex can not update acme item", "globex", "POST", "/AAAA-1111-AAAA-1111", `{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$9.99"}`, 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"globex can not patch acme item", "globex", "PATCH", "/AAAA-1111-AAAA-1111", `{"unit_price":"$9.99"}`, 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"globex can not delete acme item", "globex", "DELETE", "/AAAA-1111-AAAA-1111", "", 404,
+			"error 404 - produce code not found.\n"},
+		//
+		{"globex can not stock acme item", "globex", "GET", "/AAAA-1111-AAAA-1111/inventory", "", 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"globex reuses the code", "globex", "PUT", "/AAAA-1111-AAAA-1111", `{"name":"Gold Kiwi","unit_price":"$0.75"}`, 201,
+			`{"produce_code":"AAAA-1111-AAAA-1111","name":"Gold Kiwi","unit_price":"$0.75"}`},
+		//
+		{"acme item unchanged", "acme", "GET", "/AAAA-1111-AAAA-1111", "", 200,
+			`{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}`},
+		//
+		{"acme deletes its item", "acme", "DELETE", "/AAAA-1111-AAAA-1111", "", 200,
+			`{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}`},
+		//
+		{"globex trash is empty", "globex", "GET", "/trash", "", 200, `[]`},
+		//
+		{"globex item survives", "globex", "GET", "/AAAA-1111-AAAA-1111", "", 200,
+			`{"produce_code":"AAAA-1111-AAAA-1111","name":"Gold Kiwi","unit_price":"$0.75"}`},
+		//
+		{"default catalog can not see tenants", "", "GET", "/AAAA-1111-AAAA-1111", "", 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"tenants can not see default catalog", "acme", "GET", "/A12T-4GH7-QPL9-3N4M", "", 404,
+			"error 404 - produce code does not exist\n"},
+		//
+		{"unknown tenant", "initech", "GET", "", "", 404, "error 404 - tenant does not exist\n"},
+	}
+
+	for _, test := range tenantTests {
+		var headers []string
+		if test.tenant != "" {
+			headers = tenantHeaders(test.tenant)
+		}
+		if test.method == "PATCH" {
+			headers = append(headers, "Content-Type", "application/merge-patch+json")
+		}
+		f.request(test.method, "/api/produce"+test.path, test.body, headers...).assert(t, test.statusCode, test.expectedBody, test.desc)
+	}
+	assert.Len(t, f.db.catalog().Data, 4, "tenant request changed the default catalog")
+
+	//a tenant can only be named along with its own api key
+	unauthorized := "error 401 - missing or invalid tenant api key\n"
+	f.request("GET", "/api/produce", "", "X-Tenant-ID", "acme").assert(t, 401, unauthorized, "missing api key")
+	f.request("GET", "/api/produce", "", "X-Tenant-ID", "acme", "Authorization", "Bearer globex-key").assert(t, 401,
+		unauthorized, "api key of another tenant")
+	f.request("GET", "/api/produce", "", "X-Tenant-ID", "acme", "Authorization", "acme-key").assert(t, 401,
+		unauthorized, "api key without bearer scheme")
+}
+
+//test that tenants check produce codes against their own scheme and tax carts at their own rate
+func TestTenantSettings(t *testing.T) {
+	f := newFixture(t, nil)
+	reinitTenants()
+	defer reinitTenants()
+	AddTenant("acme", TenantConfig{APIKey: "acme-key", AdminAPIKey: "acme-admin", ProduceCodeScheme: "sku:8", SalesTaxRate: "0.1"})
+
+	var settingsTests = []struct {
+		desc         string
+		tenant       string
+		method       string
+		path         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"tenant scheme accepted", "acme", "POST", "/api/produce", `{"produce_code":"12345678","name":"Kiwi","unit_price":"$1.00"}`, 201,
+			`{"produce_code":"12345678","name":"Kiwi","unit_price":"$1.00"}`},
+		//
+		{"default scheme rejected by tenant", "acme", "GET", "/api/produce/A12T-4GH7-QPL9-3N4M", "", 400,
+			"error 400 - invalid produce code format\n"},
+		//
+		{"tenant scheme rejected by default", "", "GET", "/api/produce/12345678", "", 400,
+			"error 400 - invalid produce code format\n"},
+		//
+		{"other tenant keeps default scheme", "globex", "POST", "/api/produce", `{"produce_code":"12345678","name":"Kiwi","unit_price":"$1.00"}`, 400,
+			`{"validationError":{"produce_code":["invalid produce code format"]}}`},
+		//
+		{"tenant tax rate", "acme", "POST", "/api/cart", `{"items":[{"produce_code":"12345678"}]}`, 200,
+			`{"lines":[{"produce_code":"12345678","name":"Kiwi","quantity":"1","unit":"each","unit_price":"$1.00","price_unit":"each","extended_price":"$1.00","tax":"$0.10"}],` +
+				`"subtotal":"$1.00","tax":"$0.10","total":"$1.10"}`},
+	}
+
+	for _, test := range settingsTests {
+		var headers []string
+		if test.tenant != "" {
+			headers = tenantHeaders(test.tenant)
+		}
+		f.request(test.method, test.path, test.body, headers...).assert(t, test.statusCode, test.expectedBody, test.desc)
+	}
+
+	//admin writes of a tenant need its admin key, which opens the other end points of the tenant as well
+	admin := []string{"X-Tenant-ID", "acme", "Authorization", "Bearer acme-admin"}
+	f.request("PUT", "/api/rules", `{"rules":[]}`, tenantHeaders("acme")...).assert(t, 401,
+		"error 401 - missing or invalid admin api key\n", "admin write with tenant api key")
+	f.request("PUT", "/api/rules", `{"rules":[]}`, admin...).assert(t, 200, `{"rules":[]}`, "admin write with tenant admin key")
+	f.request("GET", "/api/produce/12345678", "", admin...).assert(t, 200,
+		`{"produce_code":"12345678","name":"Kiwi","unit_price":"$1.00"}`, "read with tenant admin key")
+}
+
+//test that the admin key of the default database unlocks no tenant, neither for reads nor for admin writes
+func TestAdminKeyDoesNotUnlockTenants(t *testing.T) {
+	defer func(key string) { AdminAPIKey = key }(AdminAPIKey)
+	AdminAPIKey = "server-admin"
+	f := newFixture(t, nil)
+	reinitTenants()
+	admin := []string{"Authorization", "Bearer server-admin"}
+
+	f.request("PUT", "/api/rules", `{"rules":[]}`, admin...).assert(t, 200, `{"rules":[]}`, "admin write to the default database")
+	f.request("GET", "/api/produce", "", append([]string{"X-Tenant-ID", "acme"}, admin...)...).assert(t, 401,
+		"error 401 - missing or invalid tenant api key\n", "tenant read with server admin key")
+	f.request("PUT", "/api/rules", `{"rules":[]}`, append([]string{"X-Tenant-ID", "acme"}, admin...)...).assert(t, 401,
+		"error 401 - missing or invalid tenant api key\n", "tenant admin write with server admin key")
+	f.request("PUT", "/api/rules", `{"rules":[]}`, tenantHeaders("acme")...).assert(t, 401,
+		"error 401 - missing or invalid admin api key\n", "admin write of tenant without admin key")
+}
+
+func TestTenantSubdomain(t *testing.T) {
+	reinitTenants()
+	acme, _ := getTenant("acme")
+	acme.storeCatalog(&catalog{Data: []ProduceItem{{ProduceCode: "AAAA-1111-AAAA-1111", Name: "Kiwi", UnitPrice: "$0.50"}}})
+
+	TenantDomain = "shop.test"
+	router := Handlers()
+	TenantDomain = ""
+
+	var subdomainTests = []struct {
+		desc         string
+		host         string
+		header       string
+		statusCode   int
+		expectedBody string
+	}{
+		{"subdomain names tenant", "acme.shop.test", "", 200,
+			`[{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}]`},
+		//
+		{"subdomain with port", "globex.shop.test:8080", "", 200, `[]`},
+		//
+		{"matching header", "acme.shop.test", "acme", 200,
+			`[{"produce_code":"AAAA-1111-AAAA-1111","name":"Kiwi","unit_price":"$0.50"}]`},
+		//
+		{"header names another tenant", "acme.shop.test", "globex", 400, "error 400 - tenant header does not match host\n"},
+		//
+		{"unknown subdomain", "initech.shop.test", "", 404, "error 404 - tenant does not exist\n"},
+		//
+		{"header without subdomain", "shop.test", "globex", 200, `[]`},
+	}
+
+	for _, test := range subdomainTests {
+		req := httptest.NewRequest("GET", "/api/produce", nil)
+		req.Host = test.host
+		if test.header != "" {
+			req.Header.Set("X-Tenant-ID", test.header)
+		}
+		tenant := test.header
+		if tenant == "" {
+			tenant = strings.Split(test.host, ".")[0]
+		}
+		req.Header.Set("Authorization", "Bearer "+tenant+"-key")
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		assert.Equal(t, test.statusCode, res.Code, test.desc)
+		assert.Equal(t, test.expectedBody, res.Body.String(), test.desc)
+	}
+}
+
+//handlers must reach the database through tenantDB, a handler that uses currentDB directly would serve every tenant
+//from the default catalog
+func TestHandlersUseTenantDB(t *testing.T) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handlers := 0
+	for _, file := range pkgs["api"].Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv != nil || !strings.HasPrefix(fn.Name.Name, "handle") {
+				continue
+			}
+			handlers++
+			ast.Inspect(fn.Body, func(node ast.Node) bool {
+				if ident, ok := node.(*ast.Ident); ok && ident.Name == "currentDB" {
+					t.Errorf("%s uses currentDB at %s", fn.Name.Name, fset.Position(ident.Pos()))
+				}
+				return true
+			})
+		}
+	}
+	assert.NotZero(t, handlers, "no handlers found")
+}
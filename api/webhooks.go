@@ -0,0 +1,483 @@
+//contains outbound webhook subscriptions that POST signed change events to downstream systems, along with their
+//handler functions
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+//number of delivery attempts made before an event is moved to the dead letter list
+var WebhookMaxAttempts = 5
+
+//wait before the first retry, doubled for every further attempt
+var WebhookBaseBackoff = time.Second
+
+//number of dead letters kept per database, the oldest are dropped first
+var WebhookMaxDeadLetters = 1000
+
+//allows subscriptions to loopback, private and link-local addresses, which are refused by default so a subscription
+//can not be used to reach internal services. Meant for development where the receiver runs on the same machine.
+var WebhookAllowPrivateTargets = false
+
+//number of events waiting for delivery per subscription, events that arrive while the queue is full are dead lettered
+const webhookQueueSize = 256
+
+//returned when a webhook would be delivered to an address WebhookAllowPrivateTargets does not allow
+var errPrivateWebhookTarget = errors.New("webhook target is not a public address")
+
+//client used for deliveries, a subscriber that does not answer within the timeout counts as a failed attempt. The
+//address is checked when the connection is made, so a host name that resolves to an internal address after the
+//subscription was validated is refused as well.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: checkWebhookDial}).DialContext,
+	},
+}
+
+//type to store a webhook subscription. Events lists the change types to deliver, all types are delivered when empty.
+//The secret is only shown when the subscription is created.
+type WebhookSubscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//type to store an event that could not be delivered after all attempts
+type DeadLetter struct {
+	ID             string      `json:"id"`
+	SubscriptionID string      `json:"subscription_id"`
+	URL            string      `json:"url"`
+	Event          ChangeEvent `json:"event"`
+	Attempts       int         `json:"attempts"`
+	LastError      string      `json:"last_error"`
+	FailedAt       time.Time   `json:"failed_at"`
+}
+
+//type to hold the webhook subscriptions and dead letters of a database. The dispatcher that follows the change feed is
+//started with the first subscription. Every subscription has a queue of events in queues, by subscription id, that a
+//single worker delivers in order.
+type webhookRegistry struct {
+	mu            sync.RWMutex
+	subscriptions []WebhookSubscription
+	queues        map[string]chan ChangeEvent
+	deadLetters   []DeadLetter
+	dispatcher    sync.Once
+}
+
+//errors returned by retryDeadLetter
+var (
+	errDeadLetterNotFound = errors.New("dead letter does not exist")
+	errWebhookQueueFull   = errors.New("delivery queue of the webhook is full")
+)
+
+//returns a random hex identifier of n bytes
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+//checks that the subscription has an absolute http(s) URL of a public address and only known event types. A host
+//name is resolved and refused if any of its addresses is not public, see isPublicAddress.
+func (sub *WebhookSubscription) validateWebhookSubscription() url.Values {
+	errs := url.Values{}
+
+	if sub.URL == "" {
+		errs.Add("url", "url field is required")
+	}
+	target, err := url.Parse(sub.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		errs.Add("url", "invalid url format")
+	} else if !WebhookAllowPrivateTargets {
+		addresses, err := net.LookupIP(target.Hostname())
+		if err != nil || len(addresses) == 0 {
+			errs.Add("url", "url host can not be resolved")
+		}
+		for _, address := range addresses {
+			if !isPublicAddress(address) {
+				errs.Add("url", "url must not point at a loopback, private or link-local address")
+				break
+			}
+		}
+	}
+
+	for _, event := range sub.Events {
+		if event != "create" && event != "update" && event != "delete" {
+			errs.Add("events", fmt.Sprintf("unknown event type %q", event))
+		}
+	}
+	return errs
+}
+
+//shared address space of carrier-grade NAT (RFC 6598), often used for internal networks by cloud providers
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+//reports whether webhooks may be delivered to the address: it must not be a loopback, private, shared (carrier-grade
+//NAT), link-local, multicast or unspecified address
+func isPublicAddress(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+//refuses connections of the webhook client to addresses that are not public unless WebhookAllowPrivateTargets is set
+func checkWebhookDial(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); !WebhookAllowPrivateTargets && (ip == nil || !isPublicAddress(ip)) {
+		return errPrivateWebhookTarget
+	}
+	return nil
+}
+
+//reports whether the subscription wants events of the given type
+func (sub *WebhookSubscription) wants(eventType string) bool {
+	if len(sub.Events) == 0 {
+		return true
+	}
+	for _, event := range sub.Events {
+		if event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+//stores a new subscription, generating its id and a secret if none was given, starts the worker that delivers its
+//queue and makes sure the dispatcher for the database is running
+func (db *DBObject) addWebhook(sub WebhookSubscription) WebhookSubscription {
+	sub.ID = randomID(8)
+	if sub.Secret == "" {
+		sub.Secret = randomID(24)
+	}
+	sub.CreatedAt = time.Now().UTC()
+	queue := make(chan ChangeEvent, webhookQueueSize)
+
+	db.webhooks.mu.Lock()
+	db.webhooks.subscriptions = append(db.webhooks.subscriptions, sub)
+	if db.webhooks.queues == nil {
+		db.webhooks.queues = map[string]chan ChangeEvent{}
+	}
+	db.webhooks.queues[sub.ID] = queue
+	db.webhooks.mu.Unlock()
+
+	go db.runWebhookQueue(sub, queue)
+
+	db.webhooks.dispatcher.Do(func() {
+		go db.dispatchWebhooks(db.feed.lastSeq())
+	})
+	return sub
+}
+
+//returns the subscriptions without their secrets
+func (db *DBObject) getWebhooks() []WebhookSubscription {
+	db.webhooks.mu.RLock()
+	defer db.webhooks.mu.RUnlock()
+
+	subs := make([]WebhookSubscription, 0, len(db.webhooks.subscriptions))
+	for _, sub := range db.webhooks.subscriptions {
+		sub.Secret = ""
+		subs = append(subs, sub)
+	}
+	return subs
+}
+
+//returns the subscription of the given id without its secret, or false if it does not exist
+func (db *DBObject) getWebhook(id string) (WebhookSubscription, bool) {
+	for _, sub := range db.getWebhooks() {
+		if sub.ID == id {
+			return sub, true
+		}
+	}
+	return WebhookSubscription{}, false
+}
+
+//removes the subscription of the given id and returns it without its secret, or false if it does not exist. Events
+//already queued for it are still delivered.
+func (db *DBObject) removeWebhook(id string) (WebhookSubscription, bool) {
+	db.webhooks.mu.Lock()
+	defer db.webhooks.mu.Unlock()
+
+	for index, sub := range db.webhooks.subscriptions {
+		if sub.ID == id {
+			db.webhooks.subscriptions = append(db.webhooks.subscriptions[:index], db.webhooks.subscriptions[index+1:]...)
+			if queue, ok := db.webhooks.queues[id]; ok {
+				close(queue)
+				delete(db.webhooks.queues, id)
+			}
+			sub.Secret = ""
+			return sub, true
+		}
+	}
+	return WebhookSubscription{}, false
+}
+
+//returns a copy of the dead letter list
+func (db *DBObject) getDeadLetters() []DeadLetter {
+	db.webhooks.mu.RLock()
+	defer db.webhooks.mu.RUnlock()
+	return append([]DeadLetter{}, db.webhooks.deadLetters...)
+}
+
+//adds a dead letter, dropping the oldest letters beyond WebhookMaxDeadLetters. The caller holds the write lock.
+func (registry *webhookRegistry) addDeadLetter(letter DeadLetter) {
+	registry.deadLetters = append(registry.deadLetters, letter)
+	if excess := len(registry.deadLetters) - WebhookMaxDeadLetters; excess > 0 {
+		registry.deadLetters = append([]DeadLetter{}, registry.deadLetters[excess:]...)
+	}
+}
+
+//removes the dead letter of the given id and queues its event for delivery to the subscription again.
+//errDeadLetterNotFound is returned if the dead letter or its subscription no longer exists and errWebhookQueueFull if
+//the queue of the subscription has no room, the dead letter is kept then.
+func (db *DBObject) retryDeadLetter(id string) (DeadLetter, error) {
+	db.webhooks.mu.Lock()
+	defer db.webhooks.mu.Unlock()
+
+	for index, letter := range db.webhooks.deadLetters {
+		if letter.ID != id {
+			continue
+		}
+		queue, ok := db.webhooks.queues[letter.SubscriptionID]
+		if !ok {
+			break
+		}
+		select {
+		case queue <- letter.Event:
+			db.webhooks.deadLetters = append(db.webhooks.deadLetters[:index], db.webhooks.deadLetters[index+1:]...)
+			return letter, nil
+		default:
+			return DeadLetter{}, errWebhookQueueFull
+		}
+	}
+	return DeadLetter{}, errDeadLetterNotFound
+}
+
+//follows the change feed from sequence number since and queues every event for the subscriptions that want it. If the
+//dispatcher falls behind and is dropped by the feed it resubscribes from the last event it handled, events dropped
+//from the history in the meantime are recorded as lost.
+func (db *DBObject) dispatchWebhooks(since uint64) {
+	for {
+		backlog, events, complete := db.feed.subscribe(since)
+		if !complete {
+			lostUntil := db.feed.lastSeq()
+			if len(backlog) > 0 {
+				lostUntil = backlog[0].Seq - 1
+			}
+			db.recordLostEvents(since+1, lostUntil)
+		}
+		for _, event := range backlog {
+			db.queueWebhooks(event)
+			since = event.Seq
+		}
+		for event := range events {
+			db.queueWebhooks(event)
+			since = event.Seq
+		}
+	}
+}
+
+//adds the event to the queue of every subscription that wants it. An event that does not fit in the queue of a
+//subscription is added to the dead letter list instead.
+func (db *DBObject) queueWebhooks(event ChangeEvent) {
+	db.webhooks.mu.Lock()
+	defer db.webhooks.mu.Unlock()
+
+	for _, sub := range db.webhooks.subscriptions {
+		if !sub.wants(event.Type) {
+			continue
+		}
+		select {
+		case db.webhooks.queues[sub.ID] <- event:
+		default:
+			db.webhooks.addDeadLetter(DeadLetter{ID: randomID(8), SubscriptionID: sub.ID, URL: sub.URL, Event: event,
+				LastError: errWebhookQueueFull.Error(), FailedAt: time.Now().UTC()})
+		}
+	}
+}
+
+//adds a dead letter for every subscription for the events from sequence number from to until, which were dropped from
+//the feed history before the dispatcher saw them. Their contents are gone, so the letter carries a "resync" event with
+//the last lost sequence number, retrying it tells the receiver to reload the catalog.
+func (db *DBObject) recordLostEvents(from uint64, until uint64) {
+	db.webhooks.mu.Lock()
+	defer db.webhooks.mu.Unlock()
+
+	event := ChangeEvent{Seq: until, Type: "resync", Time: time.Now().UTC()}
+	for _, sub := range db.webhooks.subscriptions {
+		db.webhooks.addDeadLetter(DeadLetter{ID: randomID(8), SubscriptionID: sub.ID, URL: sub.URL, Event: event,
+			LastError: fmt.Sprintf("events %d to %d were dropped from the feed before they were dispatched", from, until),
+			FailedAt:  time.Now().UTC()})
+	}
+}
+
+//delivers the events of the queue of the subscription one at a time, so the receiver gets them in the order they
+//happened. Runs until the subscription is removed and its queue is drained.
+func (db *DBObject) runWebhookQueue(sub WebhookSubscription, queue chan ChangeEvent) {
+	for event := range queue {
+		db.deliverWebhook(sub, event)
+	}
+}
+
+//POSTs the event to the subscription URL until it answers with a 2xx status, waiting WebhookBaseBackoff doubled on
+//every retry. After WebhookMaxAttempts failures the event is added to the dead letter list.
+func (db *DBObject) deliverWebhook(sub WebhookSubscription, event ChangeEvent) {
+	payload, _ := json.Marshal(event)
+	deliveryID := randomID(8)
+	backoff := WebhookBaseBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= WebhookMaxAttempts; attempt++ {
+		if lastErr = postWebhook(sub, event.Type, deliveryID, payload); lastErr == nil {
+			return
+		}
+		if attempt < WebhookMaxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	db.webhooks.mu.Lock()
+	defer db.webhooks.mu.Unlock()
+	db.webhooks.addDeadLetter(DeadLetter{
+		ID:             deliveryID,
+		SubscriptionID: sub.ID,
+		URL:            sub.URL,
+		Event:          event,
+		Attempts:       WebhookMaxAttempts,
+		LastError:      lastErr.Error(),
+		FailedAt:       time.Now().UTC(),
+	})
+}
+
+//makes a single delivery attempt. The body is signed with HMAC-SHA256 over "<timestamp>.<body>" using the subscription
+//secret so receivers can check both the sender and the freshness of the request.
+func postWebhook(sub WebhookSubscription, eventType string, deliveryID string, payload []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request, err := http.NewRequest("POST", sub.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Gannett-Event", eventType)
+	request.Header.Set("X-Gannett-Delivery", deliveryID)
+	request.Header.Set("X-Gannett-Timestamp", timestamp)
+	request.Header.Set("X-Gannett-Signature", "sha256="+signWebhook(sub.Secret, timestamp, payload))
+
+	response, err := webhookClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("subscriber answered with status %d", response.StatusCode)
+	}
+	return nil
+}
+
+//returns the hex encoded HMAC-SHA256 of "<timestamp>.<payload>" keyed with secret
+func signWebhook(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//This function lists the webhook subscriptions, without their secrets, with a 200 status code.
+func handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	jsonResponse(w, http.StatusOK, db.getWebhooks())
+}
+
+//This function decodes a subscription from the JSON body, if it is not valid JSON or fails validation a status 400 is
+//triggered. Otherwise the subscription is stored and returned with a 201 status code. The response is the only place
+//the signing secret is shown, a secret is generated when the body does not give one.
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	var sub WebhookSubscription
+
+	err := json.NewDecoder(r.Body).Decode(&sub) //get request body and decode into JSON format
+
+	//if unable to put the body into JSON format
+	if err != nil {
+		http.Error(w, "error 400 - invalid JSON syntax", http.StatusBadRequest)
+		return
+	}
+
+	validErrs := sub.validateWebhookSubscription()
+	if len(validErrs) > 0 {
+		err := ValidationError{validErrs}
+		jsonResponse(w, http.StatusBadRequest, err)
+		return
+	}
+
+	jsonResponse(w, http.StatusCreated, db.addWebhook(sub))
+}
+
+//This function returns the subscription of the id in the URL with a 200 status code or triggers a 404 if it does not
+//exist.
+func handleGetWebhook(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	sub, ok := db.getWebhook(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "error 404 - webhook does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, sub)
+}
+
+//This function removes the subscription of the id in the URL and returns it with a 200 status code or triggers a 404
+//if it does not exist.
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	sub, ok := db.removeWebhook(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "error 404 - webhook does not exist", 404)
+		return
+	}
+	jsonResponse(w, http.StatusOK, sub)
+}
+
+//This function lists the events that could not be delivered with a 200 status code.
+func handleGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	jsonResponse(w, http.StatusOK, db.getDeadLetters())
+}
+
+//This function queues the dead letter of the id in the URL for another round of delivery attempts and returns it with
+//a 202 status code. If the dead letter or its subscription no longer exists a 404 is triggered, if the queue of the
+//subscription is full a 503.
+func handleRetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	db := tenantDB(r)
+	letter, err := db.retryDeadLetter(mux.Vars(r)["id"])
+	switch err {
+	case nil:
+	case errDeadLetterNotFound:
+		http.Error(w, "error 404 - dead letter does not exist", 404)
+		return
+	default:
+		http.Error(w, "error 503 - "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	jsonResponse(w, http.StatusAccepted, letter)
+}
@@ -0,0 +1,223 @@
+//tests for webhooks.go
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//sets short retry settings for the duration of a test
+func fastWebhookRetries(t *testing.T, attempts int) {
+	maxAttempts, backoff := WebhookMaxAttempts, WebhookBaseBackoff
+	WebhookMaxAttempts, WebhookBaseBackoff = attempts, time.Millisecond
+	t.Cleanup(func() {
+		WebhookMaxAttempts, WebhookBaseBackoff = maxAttempts, backoff
+	})
+}
+
+//allows webhooks to the local receivers of the tests for the duration of a test
+func allowPrivateWebhookTargets(t *testing.T) {
+	allow := WebhookAllowPrivateTargets
+	WebhookAllowPrivateTargets = true
+	t.Cleanup(func() {
+		WebhookAllowPrivateTargets = allow
+	})
+}
+
+//test that deliveries are signed and only sent for subscribed event types
+func TestWebhookDelivery(t *testing.T) {
+	allowPrivateWebhookTargets(t)
+	received := make(chan *http.Request, 4)
+	bodies := make(chan []byte, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	defer receiver.Close()
+
+	var db DBObject
+	sub := db.addWebhook(WebhookSubscription{URL: receiver.URL, Secret: "s3cret", Events: []string{"update"}})
+	db.feed.publish("create", "1111-1111-1111-1111", ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.23"})
+	db.feed.publish("update", "1111-1111-1111-1111", ProduceItem{ProduceCode: "1111-1111-1111-1111", Name: "Bacon", UnitPrice: "$1.50"})
+
+	request, body := <-received, <-bodies
+	assert.Equal(t, "update", request.Header.Get("X-Gannett-Event"), "unsubscribed event delivered")
+	signature := "sha256=" + signWebhook(sub.Secret, request.Header.Get("X-Gannett-Timestamp"), body)
+	assert.Equal(t, signature, request.Header.Get("X-Gannett-Signature"), "signature does not verify")
+
+	var event ChangeEvent
+	json.Unmarshal(body, &event)
+	assert.Equal(t, "$1.50", event.Item.UnitPrice, "unexpected payload")
+	select {
+	case <-received:
+		t.Error("create event delivered to update only subscription")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+//test that failed deliveries are retried and end up in the dead letter list once attempts run out
+func TestWebhookRetryAndDeadLetter(t *testing.T) {
+	fastWebhookRetries(t, 3)
+	allowPrivateWebhookTargets(t)
+	var calls int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	var db DBObject
+	sub := db.addWebhook(WebhookSubscription{URL: receiver.URL})
+	db.feed.publish("delete", "1111-1111-1111-1111", ProduceItem{})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(db.getDeadLetters()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	letters := db.getDeadLetters()
+	if assert.Equal(t, 1, len(letters), "event not dead lettered") {
+		assert.Equal(t, sub.ID, letters[0].SubscriptionID, "unexpected subscription")
+		assert.Equal(t, 3, letters[0].Attempts, "unexpected attempts")
+		assert.Equal(t, "subscriber answered with status 500", letters[0].LastError, "unexpected error")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "unexpected number of attempts")
+}
+
+func TestHandleWebhooks(t *testing.T) {
+	allowPrivateWebhookTargets(t)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer receiver.Close()
+	webhookUrl := "/api/webhooks"
+	f := newFixture(t, nil)
+
+	var webhookTests = []struct {
+		desc         string
+		body         string
+		statusCode   int
+		expectedBody string
+	}{
+		{"missing url", `{"events":["update"]}`, 400,
+			`{"validationError":{"url":["url field is required","invalid url format"]}}`},
+		//
+		{"unknown event", fmt.Sprintf(`{"url":"%s","events":["sold"]}`, receiver.URL), 400,
+			`{"validationError":{"events":["unknown event type \"sold\""]}}`},
+		//
+		{"bad JSON syntax", `{"url":`, 400, "error 400 - invalid JSON syntax\n"},
+	}
+
+	for _, item := range webhookTests {
+		f.request("POST", webhookUrl, item.body).assert(t, item.statusCode, item.expectedBody, item.desc)
+	}
+
+	//create, read back without secret, then delete
+	response := f.request("POST", webhookUrl, fmt.Sprintf(`{"url":"%s"}`, receiver.URL))
+	var created WebhookSubscription
+	response.decode(t, &created)
+	assert.Equal(t, 201, response.StatusCode, "webhook not created")
+	assert.NotEqual(t, "", created.Secret, "secret not generated")
+
+	response = f.request("GET", fmt.Sprintf("%s/%s", webhookUrl, created.ID), "")
+	assert.Equal(t, 200, response.StatusCode, "webhook not found")
+	assert.False(t, strings.Contains(response.Body, created.Secret), "secret exposed")
+
+	response = f.request("DELETE", fmt.Sprintf("%s/%s", webhookUrl, created.ID), "")
+	assert.Equal(t, 200, response.StatusCode, "webhook not deleted")
+
+	response = f.request("GET", fmt.Sprintf("%s/%s", webhookUrl, created.ID), "")
+	assert.Equal(t, 404, response.StatusCode, "deleted webhook still found")
+}
+
+//test that events are delivered to a subscription one at a time in the order they happened
+func TestWebhookDeliveryOrder(t *testing.T) {
+	allowPrivateWebhookTargets(t)
+	received := make(chan uint64, 50)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event ChangeEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event.Seq
+	}))
+	defer receiver.Close()
+
+	var db DBObject
+	db.addWebhook(WebhookSubscription{URL: receiver.URL})
+	for index := 0; index < 50; index++ {
+		db.feed.publish("update", "1111-1111-1111-1111", ProduceItem{})
+	}
+
+	var previous uint64
+	for index := 0; index < 50; index++ {
+		select {
+		case seq := <-received:
+			assert.True(t, seq > previous, fmt.Sprintf("event %d delivered after %d", seq, previous))
+			previous = seq
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d events delivered", index)
+		}
+	}
+}
+
+//test that subscriptions to addresses that are not public are refused, both when they are created and when a
+//delivery connects
+func TestWebhookTargets(t *testing.T) {
+	t.Parallel()
+	f := newFixture(t, nil)
+	refused := `{"validationError":{"url":["url must not point at a loopback, private or link-local address"]}}`
+	var targetTests = []string{"http://127.0.0.1:8080/hook", "http://localhost/hook", "http://10.1.2.3/hook",
+		"https://192.168.0.10/hook", "http://169.254.169.254/latest/meta-data", "http://[::1]/hook", "http://[fe80::1]/hook",
+		"http://0.0.0.0/hook", "http://100.64.0.1/hook", "http://100.127.255.254/hook"}
+
+	for _, target := range targetTests {
+		f.request("POST", "/api/webhooks", fmt.Sprintf(`{"url":"%s"}`, target)).assert(t, 400, refused, target)
+	}
+	assert.Equal(t, errPrivateWebhookTarget, checkWebhookDial("tcp", "127.0.0.1:80", nil), "loopback dial allowed")
+	assert.Equal(t, errPrivateWebhookTarget, checkWebhookDial("tcp", "[fd00::1]:443", nil), "private dial allowed")
+	assert.Equal(t, errPrivateWebhookTarget, checkWebhookDial("tcp", "100.100.100.200:80", nil), "carrier-grade NAT dial allowed")
+	assert.Nil(t, checkWebhookDial("tcp", "100.128.0.1:443", nil), "public dial next to carrier-grade NAT refused")
+	assert.Nil(t, checkWebhookDial("tcp", "93.184.216.34:443", nil), "public dial refused")
+}
+
+//test that the dead letter list keeps only the newest WebhookMaxDeadLetters letters
+func TestDeadLetterCap(t *testing.T) {
+	defer func(max int) { WebhookMaxDeadLetters = max }(WebhookMaxDeadLetters)
+	WebhookMaxDeadLetters = 2
+
+	var registry webhookRegistry
+	for _, id := range []string{"first", "second", "third"} {
+		registry.addDeadLetter(DeadLetter{ID: id})
+	}
+	assert.Equal(t, []DeadLetter{{ID: "second"}, {ID: "third"}}, registry.deadLetters, "unexpected dead letters")
+}
+
+//test that events the dispatcher missed because it fell behind the feed history are recorded as dead letters
+func TestWebhookLostEvents(t *testing.T) {
+	t.Parallel()
+	var db DBObject
+	sub := db.addWebhook(WebhookSubscription{URL: "http://127.0.0.1:1/hook", Events: []string{"delete"}})
+
+	//the dispatcher can not queue while the registry is locked, so it is dropped by the feed and history is lost
+	db.webhooks.mu.Lock()
+	for index := 0; index < feedHistory+subscriberBuffer+10; index++ {
+		db.feed.publish("update", "1111-1111-1111-1111", ProduceItem{})
+	}
+	db.webhooks.mu.Unlock()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(db.getDeadLetters()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	letters := db.getDeadLetters()
+	if assert.Equal(t, 1, len(letters), "lost events not dead lettered") {
+		assert.Equal(t, sub.ID, letters[0].SubscriptionID, "unexpected subscription")
+		assert.Equal(t, "resync", letters[0].Event.Type, "unexpected event")
+		assert.Contains(t, letters[0].LastError, "were dropped from the feed", "unexpected error")
+	}
+}
@@ -0,0 +1,365 @@
+//generates load against the supermarket API with a configurable mix of reads and writes and reports the throughput
+//and latency percentiles of every operation. Without -url the API is started in process on the routes of
+//api.Handlers(), e.g.
+//
+//	go run ./cmd/loadgen -duration 30s -concurrency 64 -mix get=80,list=5,create=5,update=5,delete=5
+//
+//Produce codes are made up in the default four groups of four scheme, so the server must run with it.
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"github.com/jstorer/gannett/api"
+	"io"
+	"io/ioutil"
+	"log"
+	mathrand "math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+//type to describe an operation of the mix. request returns the request to send, or nil when the worker has nothing to
+//run the operation on, and done is told the status code of the answer.
+type operation struct {
+	request func(w *worker) *http.Request
+	done    func(w *worker, statusCode int)
+}
+
+//the operations a mix is made of, keyed by the name used in -mix
+var operations = map[string]operation{
+	"list": {request: func(w *worker) *http.Request {
+		return w.newRequest("GET", "/api/produce", "")
+	}},
+	"get": {request: func(w *worker) *http.Request {
+		return w.newRequest("GET", "/api/produce/"+w.seededCode(), "")
+	}},
+	"price": {request: func(w *worker) *http.Request {
+		return w.newRequest("GET", "/api/produce/"+w.seededCode()+"/price?quantity=3", "")
+	}},
+	"cart": {request: func(w *worker) *http.Request {
+		return w.newRequest("POST", "/api/cart", fmt.Sprintf(`{"items":[{"produce_code":"%s","quantity":2},{"produce_code":"%s"}]}`,
+			w.seededCode(), w.seededCode()))
+	}},
+	"create": {
+		request: func(w *worker) *http.Request {
+			w.counter++
+			w.pending = fmt.Sprintf("%s-W%03X-%04X-0000", w.run, w.id&0xFFF, w.counter&0xFFFF)
+			return w.newRequest("POST", "/api/produce", itemJSON(w.pending, w.randomPrice()))
+		},
+		done: func(w *worker, statusCode int) {
+			if statusCode == http.StatusCreated {
+				w.created = append(w.created, w.pending)
+			}
+		},
+	},
+	"update": {request: func(w *worker) *http.Request {
+		code := w.seededCode()
+		return w.newRequest("POST", "/api/produce/"+code, itemJSON(code, w.randomPrice()))
+	}},
+	"patch": {request: func(w *worker) *http.Request {
+		request := w.newRequest("PATCH", "/api/produce/"+w.seededCode(), fmt.Sprintf(`{"unit_price":"%s"}`, w.randomPrice()))
+		request.Header.Set("Content-Type", "application/merge-patch+json")
+		return request
+	}},
+	"delete": {request: func(w *worker) *http.Request {
+		if len(w.created) == 0 {
+			return nil
+		}
+		code := w.created[len(w.created)-1]
+		w.created = w.created[:len(w.created)-1]
+		return w.newRequest("DELETE", "/api/produce/"+code, "")
+	}},
+}
+
+//type to store the state of one load generating goroutine. Items it creates are only deleted by itself.
+type worker struct {
+	id        int
+	run       string
+	baseURL   string
+	tenant    string
+	tenantKey string
+	client    *http.Client
+	random    *mathrand.Rand
+	seeded    []string
+	created   []string
+	pending   string
+	counter   int
+	latencies map[string][]time.Duration
+	statuses  map[string]map[int]int
+}
+
+//returns a request to the path of the server under load
+func (w *worker) newRequest(method string, path string, body string) *http.Request {
+	request, err := http.NewRequest(method, w.baseURL+path, strings.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if w.tenant != "" {
+		request.Header.Set("X-Tenant-ID", w.tenant)
+		request.Header.Set("Authorization", "Bearer "+w.tenantKey)
+	}
+	return request
+}
+
+//returns the code of a random item created before the run
+func (w *worker) seededCode() string {
+	return w.seeded[w.random.Intn(len(w.seeded))]
+}
+
+//returns a random unit price below $10
+func (w *worker) randomPrice() string {
+	return fmt.Sprintf("$%d.%02d", w.random.Intn(10), w.random.Intn(100))
+}
+
+//sends the request and records its latency and status code under the operation name, a request that fails to be
+//sent is recorded with status code 0
+func (w *worker) send(name string, request *http.Request) int {
+	start := time.Now()
+	statusCode := 0
+	if response, err := w.client.Do(request); err == nil {
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		statusCode = response.StatusCode
+	}
+	w.latencies[name] = append(w.latencies[name], time.Since(start))
+	if w.statuses[name] == nil {
+		w.statuses[name] = map[int]int{}
+	}
+	w.statuses[name][statusCode]++
+	return statusCode
+}
+
+//returns the JSON of an item of the given code. The name repeats the code so the names of any two codes are at least
+//two letters apart and never rejected as near duplicates.
+func itemJSON(code string, price string) string {
+	tag := strings.Replace(code, "-", "", -1)
+	return fmt.Sprintf(`{"produce_code":"%s","name":"Load %s %s","unit_price":"%s"}`, code, tag, tag, price)
+}
+
+//type to store the weighted operations of a mix
+type mix struct {
+	names   []string
+	weights []int
+	total   int
+}
+
+//parses a mix such as "get=80,list=10,create=10" into the operations and their weights
+func parseMix(spec string) (mix, error) {
+	var result mix
+	for _, part := range strings.Split(spec, ",") {
+		nameWeight := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if _, ok := operations[nameWeight[0]]; !ok {
+			return mix{}, fmt.Errorf("unknown operation %q", nameWeight[0])
+		}
+		weight := 1
+		if len(nameWeight) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(nameWeight[1]); err != nil || weight < 0 {
+				return mix{}, fmt.Errorf("invalid weight %q for %s", nameWeight[1], nameWeight[0])
+			}
+		}
+		result.names = append(result.names, nameWeight[0])
+		result.weights = append(result.weights, weight)
+		result.total += weight
+	}
+	if result.total == 0 {
+		return mix{}, fmt.Errorf("mix %q has no weight", spec)
+	}
+	return result, nil
+}
+
+//picks an operation of the mix at random by weight
+func (m mix) pick(random *mathrand.Rand) string {
+	n := random.Intn(m.total)
+	for index, weight := range m.weights {
+		if n < weight {
+			return m.names[index]
+		}
+		n -= weight
+	}
+	return m.names[len(m.names)-1]
+}
+
+//returns the duration below which the given fraction of the sorted latencies fall
+func percentile(sorted []time.Duration, fraction float64) time.Duration {
+	index := int(fraction*float64(len(sorted))+0.5) - 1
+	if index < 0 {
+		index = 0
+	} else if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return sorted[index]
+}
+
+//prints the requests, failures, throughput and latency percentiles of every operation and of all of them together.
+//Answers other than 2xx are failures and listed by status code, status code 0 stands for requests that got no answer.
+func report(out io.Writer, workers []*worker, elapsed time.Duration) {
+	latencies := map[string][]time.Duration{}
+	statuses := map[string]map[int]int{}
+	for _, w := range workers {
+		for name, durations := range w.latencies {
+			latencies[name] = append(latencies[name], durations...)
+			latencies["total"] = append(latencies["total"], durations...)
+			if statuses[name] == nil {
+				statuses[name] = map[int]int{}
+			}
+			for statusCode, count := range w.statuses[name] {
+				statuses[name][statusCode] += count
+			}
+		}
+	}
+
+	var names []string
+	for name := range statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(table, "operation\trequests\tfailed\treq/s\tp50\tp90\tp99\tmax\t")
+	failures := map[string]string{}
+	totalFailed := 0
+	for _, name := range append(names, "total") {
+		sorted := latencies[name]
+		if len(sorted) == 0 {
+			continue
+		}
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		failed := 0
+		var codes []string
+		for statusCode, count := range statuses[name] {
+			if statusCode < 200 || statusCode > 299 {
+				failed += count
+				codes = append(codes, fmt.Sprintf("%d x %d", count, statusCode))
+			}
+		}
+		if len(codes) > 0 {
+			sort.Strings(codes)
+			failures[name] = strings.Join(codes, ", ")
+		}
+		if name == "total" {
+			failed = totalFailed
+		}
+		totalFailed += failed
+
+		fmt.Fprintf(table, "%s\t%d\t%d\t%.1f\t%v\t%v\t%v\t%v\t\n", name, len(sorted), failed,
+			float64(len(sorted))/elapsed.Seconds(), percentile(sorted, 0.5).Round(time.Microsecond),
+			percentile(sorted, 0.9).Round(time.Microsecond), percentile(sorted, 0.99).Round(time.Microsecond),
+			sorted[len(sorted)-1].Round(time.Microsecond))
+	}
+	table.Flush()
+
+	for _, name := range names {
+		if codes, ok := failures[name]; ok {
+			fmt.Fprintf(out, "%s failed: %s\n", name, codes)
+		}
+	}
+}
+
+func main() {
+	baseURL := flag.String("url", "", "base URL of the server to load, the API is started in process when empty")
+	duration := flag.Duration("duration", 10*time.Second, "how long to generate load")
+	concurrency := flag.Int("concurrency", 16, "number of requests in flight")
+	mixSpec := flag.String("mix", "get=70,list=5,price=5,cart=5,create=5,update=5,delete=5", "operations and their weights")
+	items := flag.Int("items", 500, "number of items created before the run for the reads and updates to use")
+	tenant := flag.String("tenant", "", "tenant named in the X-Tenant-ID header of every request")
+	tenantKey := flag.String("tenant-key", "loadgen", "api key of the tenant sent as a bearer token")
+	flag.Parse()
+
+	operationMix, err := parseMix(*mixSpec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *concurrency < 1 || *items < 1 {
+		log.Fatal("concurrency and items must be at least 1")
+	}
+	if *baseURL == "" {
+		if *tenant != "" {
+			if err := api.AddTenant(*tenant, api.TenantConfig{APIKey: *tenantKey}); err != nil {
+				log.Fatal(err)
+			}
+		}
+		api.Initialize(false)
+		server := httptest.NewServer(api.Handlers())
+		defer server.Close()
+		*baseURL = server.URL
+	}
+	*baseURL = strings.TrimSuffix(*baseURL, "/")
+
+	//a run tag in every code keeps the items of repeated runs against the same server apart
+	tag := make([]byte, 2)
+	rand.Read(tag)
+	run := strings.ToUpper(hex.EncodeToString(tag))
+
+	client := &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{MaxIdleConns: *concurrency, MaxIdleConnsPerHost: *concurrency},
+	}
+
+	//items for the reads and updates, created once and shared by the workers
+	var seeded []string
+	for index := 0; index < *items; index++ {
+		code := fmt.Sprintf("%s-SEED-%04X-0000", run, index&0xFFFF)
+		request, _ := http.NewRequest("POST", *baseURL+"/api/produce", strings.NewReader(itemJSON(code, "$1.99")))
+		if *tenant != "" {
+			request.Header.Set("X-Tenant-ID", *tenant)
+			request.Header.Set("Authorization", "Bearer "+*tenantKey)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			log.Fatal(err)
+		}
+		io.Copy(ioutil.Discard, response.Body)
+		response.Body.Close()
+		if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusConflict {
+			log.Fatalf("creating item %s answered status %d", code, response.StatusCode)
+		}
+		seeded = append(seeded, code)
+	}
+
+	workers := make([]*worker, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(*duration)
+	for id := range workers {
+		w := &worker{id: id, run: run, baseURL: *baseURL, tenant: *tenant, tenantKey: *tenantKey, client: client, seeded: seeded,
+			random: mathrand.New(mathrand.NewSource(start.UnixNano() + int64(id))), latencies: map[string][]time.Duration{},
+			statuses: map[string]map[int]int{}}
+		workers[id] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				name := operationMix.pick(w.random)
+				op := operations[name]
+				request := op.request(w)
+				if request == nil {
+					continue
+				}
+				statusCode := w.send(name, request)
+				if op.done != nil {
+					op.done(w, statusCode)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	fmt.Printf("%s against %s, %d workers for %v\n", *mixSpec, *baseURL, *concurrency, elapsed.Round(time.Millisecond))
+	report(os.Stdout, workers, elapsed)
+}
@@ -4,12 +4,93 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 	"github.com/jstorer/gannett/api"
 )
 
 
 func main() {
 	fmt.Println("...Supermarket Server Starting...")
+
+	//how long deleted items stay restorable, e.g. TRASH_RETENTION=720h
+	if value := os.Getenv("TRASH_RETENTION"); value != "" {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention <= 0 {
+			log.Fatalf("invalid TRASH_RETENTION %q, expected a positive duration such as 720h", value)
+		}
+		api.TrashRetention = retention
+	}
+	//sales tax rate applied to carts, e.g. SALES_TAX_RATE=0.0575
+	if rate := os.Getenv("SALES_TAX_RATE"); rate != "" {
+		if err := api.SetSalesTaxRate(rate); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//tax rate tables of the store jurisdictions separated by commas, the first replaces SALES_TAX_RATE for carts not
+	//priced for a store, e.g. TAX_TABLE=tax_rates.example.json
+	for _, path := range strings.Split(os.Getenv("TAX_TABLE"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := api.LoadTaxTable(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//scheme produce codes are checked against and generated in, e.g. PRODUCE_CODE_SCHEME=sku:8+luhn
+	if spec := os.Getenv("PRODUCE_CODE_SCHEME"); spec != "" {
+		scheme, err := api.ParseCodeScheme(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		api.ProduceCodes = scheme
+	}
+	//key admin writes to the default catalog such as replacing validation rules carry as a bearer token, e.g.
+	//ADMIN_API_KEY=secret. Admin writes are refused while it is not set, tenants only accept their own admin key.
+	api.AdminAPIKey = os.Getenv("ADMIN_API_KEY")
+	//tenants served next to the default catalog, each with its own data, e.g. TENANTS=acme,globex. Every tenant needs
+	//an api key its requests carry as a bearer token and may have an admin key, a code scheme and tax settings of its
+	//own, e.g. TENANT_ACME_API_KEY, TENANT_ACME_ADMIN_API_KEY, TENANT_ACME_PRODUCE_CODE_SCHEME,
+	//TENANT_ACME_SALES_TAX_RATE and TENANT_ACME_TAX_TABLE
+	for _, tenant := range strings.Split(os.Getenv("TENANTS"), ",") {
+		if tenant = strings.TrimSpace(tenant); tenant == "" {
+			continue
+		}
+		prefix := "TENANT_" + strings.ToUpper(strings.Replace(tenant, "-", "_", -1)) + "_"
+		config := api.TenantConfig{
+			APIKey:            os.Getenv(prefix + "API_KEY"),
+			AdminAPIKey:       os.Getenv(prefix + "ADMIN_API_KEY"),
+			ProduceCodeScheme: os.Getenv(prefix + "PRODUCE_CODE_SCHEME"),
+			SalesTaxRate:      os.Getenv(prefix + "SALES_TAX_RATE"),
+		}
+		for _, path := range strings.Split(os.Getenv(prefix+"TAX_TABLE"), ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				config.TaxTables = append(config.TaxTables, path)
+			}
+		}
+		if err := api.AddTenant(tenant, config); err != nil {
+			log.Fatal(err)
+		}
+	}
+	//lets webhooks reach loopback and private addresses, for receivers on the same machine or network, e.g.
+	//WEBHOOK_ALLOW_PRIVATE_TARGETS=true
+	if allow := os.Getenv("WEBHOOK_ALLOW_PRIVATE_TARGETS"); allow != "" {
+		allowed, err := strconv.ParseBool(allow)
+		if err != nil {
+			log.Fatalf("invalid WEBHOOK_ALLOW_PRIVATE_TARGETS %q, expected true or false", allow)
+		}
+		api.WebhookAllowPrivateTargets = allowed
+	}
+	//domain tenants are served under as subdomains, e.g. TENANT_DOMAIN=shop.example.com serves acme.shop.example.com
+	api.TenantDomain = os.Getenv("TENANT_DOMAIN")
 	api.Initialize(false)
+	//validation rules added to the built in rules of produce items, e.g. VALIDATION_RULES=rules.json
+	if path := os.Getenv("VALIDATION_RULES"); path != "" {
+		if err := api.LoadValidationRules(path); err != nil {
+			log.Fatal(err)
+		}
+	}
 	log.Fatal(http.ListenAndServe(":8080", api.Handlers()))
 }